@@ -0,0 +1,42 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bearer provides implementations of muxer.Bearer for running the Ouroboros protocol over transports
+// other than a network connection. Any net.Conn can be used as a bearer directly.
+package bearer
+
+import (
+	"net"
+
+	"github.com/blinklabs-io/gouroboros/muxer"
+)
+
+// Ensure that our types implement the bearer interface
+var (
+	_ muxer.Bearer = (net.Conn)(nil)
+	_ muxer.Bearer = (*Pipe)(nil)
+	_ muxer.Bearer = (*Stream)(nil)
+	_ muxer.Bearer = (*WebSocket)(nil)
+)
+
+// addr is a net.Addr for bearers without a network address
+type addr string
+
+func (a addr) Network() string {
+	return string(a)
+}
+
+func (a addr) String() string {
+	return string(a)
+}
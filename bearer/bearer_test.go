@@ -0,0 +1,166 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bearer_test
+
+import (
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/bearer"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/muxer"
+)
+
+// testHandshake performs a handshake between a client and server over the provided bearers, and then checks
+// that the server sees the client hang up
+func testHandshake(t *testing.T, clientBearer muxer.Bearer, serverBearer muxer.Bearer) {
+	serverResultChan := make(chan *ouroboros.Connection, 1)
+	go func() {
+		oServer, err := ouroboros.New(
+			ouroboros.WithBearer(serverBearer),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+		)
+		if err != nil {
+			serverResultChan <- nil
+			return
+		}
+		serverResultChan <- oServer
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithBearer(clientBearer),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	oServer := <-serverResultChan
+	if oServer == nil {
+		t.Fatalf("unexpected error when creating server Connection object")
+	}
+	defer oServer.Close()
+	if oClient.ProtocolVersion() != oServer.ProtocolVersion() {
+		t.Fatalf("client and server negotiated different versions: %d, %d", oClient.ProtocolVersion(), oServer.ProtocolVersion())
+	}
+	if err := oClient.Close(); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	select {
+	case err := <-oServer.ErrorChan():
+		if err != io.EOF {
+			t.Fatalf("did not get expected error from server: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("server did not see client hang up")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	clientBearer, serverBearer := bearer.NewPipe()
+	testHandshake(t, clientBearer, serverBearer)
+}
+
+func TestPipeWriteNoReader(t *testing.T) {
+	a, b := bearer.NewPipe()
+	// Writes don't wait for the other end to read
+	for i := 0; i < 10; i++ {
+		if _, err := a.Write([]byte("test")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	a.Close()
+	data, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(data) != 40 {
+		t.Fatalf("did not read expected data, got %d bytes", len(data))
+	}
+	if _, err := b.Write([]byte("test")); err != io.ErrClosedPipe {
+		t.Fatalf("did not get expected error writing to closed pipe: %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	clientReader, serverWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	serverReader, clientWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testHandshake(
+		t,
+		bearer.NewStream(clientReader, clientWriter),
+		bearer.NewStream(serverReader, serverWriter),
+	)
+}
+
+// blockingReader blocks in Read until the test finishes, even after it's closed, like a blocking os.Stdin
+type blockingReader struct {
+	readingChan chan struct{}
+	onceReading sync.Once
+	releaseChan chan struct{}
+}
+
+func (r *blockingReader) Read(b []byte) (int, error) {
+	r.onceReading.Do(func() {
+		close(r.readingChan)
+	})
+	<-r.releaseChan
+	return 0, io.EOF
+}
+
+func (r *blockingReader) Close() error {
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestStreamCloseBlockingReader(t *testing.T) {
+	reader := &blockingReader{
+		readingChan: make(chan struct{}),
+		releaseChan: make(chan struct{}),
+	}
+	defer close(reader.releaseChan)
+	stream := bearer.NewStream(reader, nopWriteCloser{io.Discard})
+	m := muxer.New(stream)
+	m.Start()
+	// Wait for the muxer to be blocked reading
+	<-reader.readingChan
+	// Give the muxer read loop time to call Read on the stream
+	time.Sleep(50 * time.Millisecond)
+	stopChan := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(stopChan)
+	}()
+	select {
+	case <-stopChan:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("muxer did not stop with a read pending on a reader that can't be interrupted")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bearer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pipe is one end of an in-process bearer pair. Unlike net.Pipe, writes are buffered and never block, so
+// neither side needs to be reading for the other side to make progress
+type Pipe struct {
+	readBuffer  *pipeBuffer
+	writeBuffer *pipeBuffer
+	onceClose   sync.Once
+}
+
+// NewPipe returns both ends of an in-process bearer pair. Data written to one end can be read from the other
+func NewPipe() (*Pipe, *Pipe) {
+	a := newPipeBuffer()
+	b := newPipeBuffer()
+	return &Pipe{readBuffer: a, writeBuffer: b}, &Pipe{readBuffer: b, writeBuffer: a}
+}
+
+// Read reads data written to the other end of the pipe. It returns io.EOF after the other end is closed and all
+// written data has been read
+func (p *Pipe) Read(b []byte) (int, error) {
+	return p.readBuffer.read(b)
+}
+
+// Write queues data to be read from the other end of the pipe
+func (p *Pipe) Write(b []byte) (int, error) {
+	return p.writeBuffer.write(b)
+}
+
+// Close closes both directions of the pipe. Pending reads on this end return net.ErrClosed, and reads on the
+// other end return io.EOF once the data that was already written has been read
+func (p *Pipe) Close() error {
+	p.onceClose.Do(func() {
+		p.readBuffer.close(net.ErrClosed)
+		p.writeBuffer.close(io.EOF)
+	})
+	return nil
+}
+
+// RemoteAddr returns a placeholder address, since the pipe has no network address
+func (p *Pipe) RemoteAddr() net.Addr {
+	return addr("pipe")
+}
+
+// SetWriteDeadline does nothing, since writes never block
+func (p *Pipe) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// pipeBuffer holds the data for one direction of a pipe
+type pipeBuffer struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	buf   bytes.Buffer
+	err   error
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+func (b *pipeBuffer) read(data []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for b.buf.Len() == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+	// The reading side was closed, which discards any remaining data
+	if b.err == net.ErrClosed {
+		return 0, b.err
+	}
+	if b.buf.Len() == 0 {
+		return 0, b.err
+	}
+	return b.buf.Read(data)
+}
+
+func (b *pipeBuffer) write(data []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err != nil {
+		return 0, io.ErrClosedPipe
+	}
+	b.cond.Broadcast()
+	return b.buf.Write(data)
+}
+
+// close marks the buffer as closed. Reads return the provided error once the buffer is empty
+func (b *pipeBuffer) close(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
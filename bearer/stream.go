@@ -0,0 +1,132 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bearer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// streamReadBufferSize is the size of the buffer used for each read from the underlying reader
+const streamReadBufferSize = 32 * 1024
+
+// Stream is a bearer made up of a separate reader and writer, such as stdin and stdout when the other end of
+// the connection is forwarded over SSH.
+//
+// Closing some readers, such as os.Stdin when it's a terminal or a regular file, doesn't interrupt a pending
+// Read. The underlying reader is read from a separate goroutine so that Close can always interrupt a pending
+// Read on the Stream. That goroutine exits when its current read on the underlying reader returns
+type Stream struct {
+	reader     io.ReadCloser
+	writer     io.WriteCloser
+	readMutex  sync.Mutex
+	readBuf    []byte
+	readErr    error
+	readChan   chan streamReadResult
+	doneChan   chan struct{}
+	onceClose  sync.Once
+	closeError error
+}
+
+type streamReadResult struct {
+	data []byte
+	err  error
+}
+
+// NewStream returns a bearer that reads from the provided reader and writes to the provided writer. Use
+// NewStream(os.Stdin, os.Stdout) to run over stdio
+func NewStream(reader io.ReadCloser, writer io.WriteCloser) *Stream {
+	s := &Stream{
+		reader:   reader,
+		writer:   writer,
+		readChan: make(chan streamReadResult),
+		doneChan: make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+// readLoop reads from the underlying reader and passes the data along to Read
+func (s *Stream) readLoop() {
+	for {
+		buf := make([]byte, streamReadBufferSize)
+		n, err := s.reader.Read(buf)
+		select {
+		case s.readChan <- streamReadResult{data: buf[:n], err: err}:
+		case <-s.doneChan:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads from the underlying reader. A pending Read returns net.ErrClosed when the Stream is closed
+func (s *Stream) Read(b []byte) (int, error) {
+	s.readMutex.Lock()
+	defer s.readMutex.Unlock()
+	for len(s.readBuf) == 0 && s.readErr == nil {
+		select {
+		case <-s.doneChan:
+			return 0, net.ErrClosed
+		case result := <-s.readChan:
+			s.readBuf = result.data
+			s.readErr = result.err
+		}
+	}
+	if len(s.readBuf) == 0 {
+		return 0, s.readErr
+	}
+	n := copy(b, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+// Write writes to the underlying writer
+func (s *Stream) Write(b []byte) (int, error) {
+	return s.writer.Write(b)
+}
+
+// Close closes both the underlying reader and writer, and interrupts any pending Read
+func (s *Stream) Close() error {
+	s.onceClose.Do(func() {
+		close(s.doneChan)
+		readErr := s.reader.Close()
+		writeErr := s.writer.Close()
+		if readErr != nil {
+			s.closeError = readErr
+		} else {
+			s.closeError = writeErr
+		}
+	})
+	return s.closeError
+}
+
+// RemoteAddr returns a placeholder address, since the stream has no network address
+func (s *Stream) RemoteAddr() net.Addr {
+	return addr("stream")
+}
+
+// SetWriteDeadline sets the write deadline on the underlying writer, if it supports deadlines
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	if deadlineWriter, ok := s.writer.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return deadlineWriter.SetWriteDeadline(t)
+	}
+	return fmt.Errorf("write deadlines are not supported by %T", s.writer)
+}
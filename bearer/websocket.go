@@ -0,0 +1,142 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bearer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Timeout for sending the close message when closing a WebSocket
+const webSocketCloseTimeout = 1 * time.Second
+
+// WebSocket is a bearer that runs over a WebSocket connection. Data is sent as binary messages, and message
+// boundaries are not significant
+type WebSocket struct {
+	conn      *websocket.Conn
+	reader    io.Reader
+	onceClose sync.Once
+}
+
+// NewWebSocket returns a bearer for an established WebSocket connection
+func NewWebSocket(conn *websocket.Conn) *WebSocket {
+	return &WebSocket{
+		conn: conn,
+	}
+}
+
+// DialWebSocket establishes a WebSocket connection to the provided URL and returns a bearer for it. The
+// provided headers are sent with the opening handshake
+func DialWebSocket(ctx context.Context, url string, header http.Header) (*WebSocket, error) {
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	// The response body has already been consumed for a successful handshake
+	_ = resp.Body.Close()
+	return NewWebSocket(conn), nil
+}
+
+// UpgradeWebSocket upgrades an HTTP server connection to a WebSocket connection and returns a bearer for it.
+// The upgrader may be nil to use the default settings, which only allow requests from the same origin
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request, upgrader *websocket.Upgrader) (*WebSocket, error) {
+	if upgrader == nil {
+		upgrader = &websocket.Upgrader{}
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewWebSocket(conn), nil
+}
+
+// Read reads data from binary messages. It returns io.EOF when the peer closes the WebSocket
+func (w *WebSocket) Read(b []byte) (int, error) {
+	for {
+		if w.reader == nil {
+			msgType, reader, err := w.conn.NextReader()
+			if err != nil {
+				return 0, w.translateError(err)
+			}
+			if msgType != websocket.BinaryMessage {
+				return 0, fmt.Errorf("received unexpected WebSocket message type %d", msgType)
+			}
+			w.reader = reader
+		}
+		n, err := w.reader.Read(b)
+		if err == io.EOF {
+			// Move on to the next message
+			w.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends the provided data as a binary message
+func (w *WebSocket) Write(b []byte) (int, error) {
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, w.translateError(err)
+	}
+	return len(b), nil
+}
+
+// Close sends a close message to the peer and closes the underlying connection
+func (w *WebSocket) Close() error {
+	var err error
+	w.onceClose.Do(func() {
+		_ = w.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(webSocketCloseTimeout),
+		)
+		err = w.conn.Close()
+	})
+	return err
+}
+
+// RemoteAddr returns the address of the peer
+func (w *WebSocket) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+// SetWriteDeadline sets the deadline for sending messages
+func (w *WebSocket) SetWriteDeadline(t time.Time) error {
+	return w.conn.SetWriteDeadline(t)
+}
+
+// translateError returns io.EOF when the peer closed the WebSocket, which is how other bearers signal that the
+// connection was closed by the peer
+func (w *WebSocket) translateError(err error) error {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure:
+			return io.EOF
+		}
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bearer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/bearer"
+)
+
+func TestWebSocket(t *testing.T) {
+	serverBearerChan := make(chan *bearer.WebSocket, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverBearer, err := bearer.UpgradeWebSocket(w, r, nil)
+		if err != nil {
+			serverBearerChan <- nil
+			return
+		}
+		serverBearerChan <- serverBearer
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	clientBearer, err := bearer.DialWebSocket(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("unexpected error dialing WebSocket: %s", err)
+	}
+	serverBearer := <-serverBearerChan
+	if serverBearer == nil {
+		t.Fatalf("unexpected error upgrading WebSocket")
+	}
+	testHandshake(t, clientBearer, serverBearer)
+}
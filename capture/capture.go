@@ -0,0 +1,212 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture records the muxer segments exchanged on a connection to a file, and plays them back.
+//
+// A capture file starts with the 8 byte magic value "GOUROCAP" and a 2 byte format version. It's followed by
+// a record for each segment, consisting of a 1 byte direction, the time that the segment was sent or received
+// as an 8 byte count of nanoseconds since the Unix epoch, and the segment header and payload as they appeared
+// on the wire. All integers are big endian
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/muxer"
+)
+
+// Magic value at the start of a capture file
+const fileMagic = "GOUROCAP"
+
+// Version of the capture file format
+const fileVersion uint16 = 1
+
+// Size of the fixed-length part of a record, which is followed by the segment payload
+const recordHeaderLength = 1 + 8 + 8
+
+// Record is a segment from a capture
+type Record struct {
+	Direction muxer.TapDirection
+	Time      time.Time
+	Segment   *muxer.Segment
+}
+
+// Writer writes capture records. Its Tap method can be passed to muxer.WithTap or ouroboros.WithMuxerTap to
+// record the traffic on a connection
+type Writer struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	err    error
+}
+
+// NewWriter writes the capture file header to the provided io.Writer and returns a Writer for the records
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := &bytes.Buffer{}
+	header.WriteString(fileMagic)
+	_ = binary.Write(header, binary.BigEndian, fileVersion)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	return &Writer{writer: w}, nil
+}
+
+// Create creates a capture file with the provided path and returns a Writer for it. Close must be called to
+// close the file
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes a record. Each record is written with a single call to the underlying io.Writer
+func (w *Writer) Write(record Record) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte(byte(record.Direction))
+	_ = binary.Write(buf, binary.BigEndian, record.Time.UnixNano())
+	_ = binary.Write(buf, binary.BigEndian, record.Segment.SegmentHeader)
+	buf.Write(record.Segment.Payload)
+	if _, err := w.writer.Write(buf.Bytes()); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// Tap writes a record for a segment sent or received by the muxer. It has the signature of a muxer.TapFunc.
+// Write errors can't be returned to the muxer, so they're retained and returned by Err and Close, and no more
+// records are written after an error
+func (w *Writer) Tap(direction muxer.TapDirection, t time.Time, segment *muxer.Segment) {
+	_ = w.Write(
+		Record{
+			Direction: direction,
+			Time:      t,
+			Segment:   segment,
+		},
+	)
+}
+
+// Err returns the first error encountered when writing records
+func (w *Writer) Err() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.err
+}
+
+// Close closes the underlying io.Writer if it's an io.Closer. It returns the first error encountered when
+// writing records, if any
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if closer, ok := w.writer.(io.Closer); ok {
+		if err := closer.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
+// Reader reads the records from a capture
+type Reader struct {
+	reader io.Reader
+}
+
+// NewReader reads the capture file header from the provided io.Reader and returns a Reader for the records
+func NewReader(r io.Reader) (*Reader, error) {
+	header := make([]byte, len(fileMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read capture header: %w", err)
+	}
+	if string(header[:len(fileMagic)]) != fileMagic {
+		return nil, fmt.Errorf("not a capture file")
+	}
+	if version := binary.BigEndian.Uint16(header[len(fileMagic):]); version != fileVersion {
+		return nil, fmt.Errorf("unsupported capture file version: %d", version)
+	}
+	return &Reader{reader: r}, nil
+}
+
+// Next returns the next record. It returns io.EOF when there are no more records, and io.ErrUnexpectedEOF if
+// the capture ends part way through a record, which can happen if the capturing process didn't exit cleanly
+func (r *Reader) Next() (Record, error) {
+	buf := make([]byte, recordHeaderLength)
+	if _, err := io.ReadFull(r.reader, buf); err != nil {
+		return Record{}, err
+	}
+	direction := muxer.TapDirection(buf[0])
+	if direction != muxer.TapDirectionInbound && direction != muxer.TapDirectionOutbound {
+		return Record{}, fmt.Errorf("invalid direction in capture record: %d", buf[0])
+	}
+	record := Record{
+		Direction: direction,
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(buf[1:9]))),
+		Segment: &muxer.Segment{
+			SegmentHeader: muxer.SegmentHeader{
+				Timestamp:     binary.BigEndian.Uint32(buf[9:13]),
+				ProtocolId:    binary.BigEndian.Uint16(buf[13:15]),
+				PayloadLength: binary.BigEndian.Uint16(buf[15:17]),
+			},
+		},
+	}
+	record.Segment.Payload = make([]byte, record.Segment.PayloadLength)
+	if _, err := io.ReadFull(r.reader, record.Segment.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	return record, nil
+}
+
+// ReadFile returns all of the records from the capture file with the provided path. The records read before
+// any error are also returned
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		record, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/capture"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/muxer"
+	"github.com/blinklabs-io/gouroboros/protocol/handshake"
+)
+
+func TestWriterReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	records := []capture.Record{
+		{
+			Direction: muxer.TapDirectionOutbound,
+			Time:      time.Unix(1700000000, 123),
+			Segment:   muxer.NewSegment(2, []byte{0x82, 0x00, 0x01}, false),
+		},
+		{
+			Direction: muxer.TapDirectionInbound,
+			Time:      time.Unix(1700000001, 456),
+			Segment:   muxer.NewSegment(2, []byte{0x81, 0x02}, true),
+		},
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// Leave a partial record at the end, like a capture from a process that didn't exit cleanly
+	data := buf.Bytes()
+	data = append(data, byte(muxer.TapDirectionInbound), 0x00)
+	r, err := capture.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, expected := range records {
+		record, err := r.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(record, expected) {
+			t.Fatalf("did not get expected record\n  got:    %#v\n  wanted: %#v", record, expected)
+		}
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("did not get expected error for partial record: %v", err)
+	}
+	if _, err := capture.NewReader(bytes.NewReader([]byte("not a capture"))); err == nil {
+		t.Fatalf("did not get expected error for invalid capture")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	// Capture a handshake with a mock server
+	buf := &bytes.Buffer{}
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithMuxerTap(w.Tap),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Ouroboros object: %s", err)
+	}
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Ouroboros object: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	r, err := capture.NewReader(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var records []capture.Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 ||
+		records[0].Direction != muxer.TapDirectionOutbound ||
+		records[0].Segment.GetProtocolId() != handshake.ProtocolId ||
+		records[1].Direction != muxer.TapDirectionInbound ||
+		!records[1].Segment.IsResponse() {
+		t.Fatalf("did not get expected records: %#v", records)
+	}
+	// Replay the capture to a new connection
+	replay := capture.NewReplay(records)
+	oConn, err = ouroboros.New(
+		ouroboros.WithBearer(replay),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Ouroboros object: %s", err)
+	}
+	defer oConn.Close()
+	select {
+	case <-replay.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not finish replaying capture")
+	}
+	if oConn.ProtocolVersion() != ouroboros_mock.MockProtocolVersionNtC {
+		t.Fatalf("did not get expected protocol version: %d", oConn.ProtocolVersion())
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/muxer"
+)
+
+// Size of the segment header on the wire
+const segmentHeaderLength = 8
+
+// Replay is a bearer that plays back a capture to a Connection, taking the place of the peer. The Connection
+// takes the place of the side of the connection that made the capture.
+//
+// The inbound segments from the capture are returned from Read in order. Each one is held back until the
+// Connection has written at least as much payload data for each mini-protocol as was sent before it in the
+// capture, so that the Connection sees the same ordering of requests and responses. The data written isn't
+// otherwise checked. Once the capture has been played back, reads block until the bearer is closed
+type Replay struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	records  []Record
+	position int
+	readBuf  bytes.Buffer
+	writeBuf bytes.Buffer
+	// Payload bytes by protocol ID, including the response flag
+	expected map[uint16]int
+	written  map[uint16]int
+	closed   bool
+	doneChan chan struct{}
+	onceDone sync.Once
+}
+
+// NewReplay returns a bearer that plays back the provided capture records
+func NewReplay(records []Record) *Replay {
+	r := &Replay{
+		records:  records,
+		expected: make(map[uint16]int),
+		written:  make(map[uint16]int),
+		doneChan: make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mutex)
+	return r
+}
+
+// Done returns a channel that is closed once all of the inbound segments have been read and all of the
+// outbound data has been written
+func (r *Replay) Done() <-chan struct{} {
+	return r.doneChan
+}
+
+// Read returns the data for the next inbound segment from the capture, once the Connection has written the
+// data that preceded it
+func (r *Replay) Read(b []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for {
+		if r.closed {
+			return 0, net.ErrClosed
+		}
+		if r.readBuf.Len() > 0 {
+			return r.readBuf.Read(b)
+		}
+		// Add up the outbound data that we need to see before the next inbound segment
+		for r.position < len(r.records) && r.records[r.position].Direction == muxer.TapDirectionOutbound {
+			segment := r.records[r.position].Segment
+			r.expected[segment.ProtocolId] += len(segment.Payload)
+			r.position++
+		}
+		if r.outboundComplete() {
+			if r.position == len(r.records) {
+				r.onceDone.Do(func() {
+					close(r.doneChan)
+				})
+			} else {
+				segment := r.records[r.position].Segment
+				_ = binary.Write(&r.readBuf, binary.BigEndian, segment.SegmentHeader)
+				r.readBuf.Write(segment.Payload)
+				r.position++
+				continue
+			}
+		}
+		r.cond.Wait()
+	}
+}
+
+// Write accepts the segments written by the Connection, keeping track of the amount of payload data for each
+// mini-protocol
+func (r *Replay) Write(b []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	r.writeBuf.Write(b)
+	for r.writeBuf.Len() >= segmentHeaderLength {
+		data := r.writeBuf.Bytes()
+		protocolId := binary.BigEndian.Uint16(data[4:6])
+		payloadLength := int(binary.BigEndian.Uint16(data[6:8]))
+		if len(data) < segmentHeaderLength+payloadLength {
+			break
+		}
+		r.written[protocolId] += payloadLength
+		r.writeBuf.Next(segmentHeaderLength + payloadLength)
+	}
+	r.cond.Broadcast()
+	return len(b), nil
+}
+
+// Close stops the playback. Pending reads return net.ErrClosed
+func (r *Replay) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
+
+// RemoteAddr returns nil, since there is no peer
+func (r *Replay) RemoteAddr() net.Addr {
+	return nil
+}
+
+// SetWriteDeadline does nothing, since writes never block
+func (r *Replay) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// outboundComplete returns whether the Connection has written all of the data that we're expecting so far
+func (r *Replay) outboundComplete() bool {
+	for protocolId, expected := range r.expected {
+		if r.written[protocolId] < expected {
+			return false
+		}
+	}
+	return true
+}
@@ -16,7 +16,9 @@ package cbor
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"reflect"
 
 	_cbor "github.com/fxamacker/cbor/v2"
@@ -73,6 +75,118 @@ func DecodeIdFromList(cborData []byte) (int, error) {
 	}
 }
 
+// Maximum nesting depth for ItemLength, which matches the limit used for decoding
+const itemLengthMaxNestedLevels = 256
+
+// ItemLength returns the length in bytes of the CBOR data item at the start of the provided data, without
+// decoding it. It returns io.EOF if the data is empty and io.ErrUnexpectedEOF if the data only contains part of
+// a data item. This is much cheaper than decoding for finding the boundaries of messages in a stream
+func ItemLength(cborData []byte) (int, error) {
+	if len(cborData) == 0 {
+		return 0, io.EOF
+	}
+	return itemLength(cborData, 0, 0)
+}
+
+// itemLength returns the offset of the end of the data item that starts at the provided offset
+func itemLength(cborData []byte, offset int, depth int) (int, error) {
+	if depth > itemLengthMaxNestedLevels {
+		return 0, fmt.Errorf("exceeded max nested level %d", itemLengthMaxNestedLevels)
+	}
+	if offset >= len(cborData) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	majorType := cborData[offset] & CBOR_TYPE_MASK
+	additionalInfo := cborData[offset] & ^CBOR_TYPE_MASK
+	offset++
+	// Handle indefinite length items, which are terminated by a "break" byte
+	if additionalInfo == 31 {
+		switch majorType {
+		case CBOR_TYPE_BYTE_STRING, CBOR_TYPE_TEXT_STRING, CBOR_TYPE_ARRAY, CBOR_TYPE_MAP:
+		default:
+			return 0, fmt.Errorf("invalid indefinite length for major type 0x%x", majorType)
+		}
+		for {
+			if offset >= len(cborData) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if cborData[offset] == 0xff {
+				return offset + 1, nil
+			}
+			// The chunks of an indefinite length string must be definite length strings of the same type
+			if (majorType == CBOR_TYPE_BYTE_STRING || majorType == CBOR_TYPE_TEXT_STRING) &&
+				cborData[offset]&CBOR_TYPE_MASK != majorType {
+				return 0, fmt.Errorf("invalid chunk in indefinite length string")
+			}
+			var err error
+			offset, err = itemLength(cborData, offset, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			// Map entries consist of a key and a value
+			if majorType == CBOR_TYPE_MAP {
+				offset, err = itemLength(cborData, offset, depth+1)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	// Determine the argument for the item
+	var argument uint64
+	switch {
+	case additionalInfo <= CBOR_MAX_UINT_SIMPLE:
+		argument = uint64(additionalInfo)
+	case additionalInfo <= 27:
+		argumentLength := 1 << (additionalInfo - 24)
+		if len(cborData)-offset < argumentLength {
+			return 0, io.ErrUnexpectedEOF
+		}
+		switch argumentLength {
+		case 1:
+			argument = uint64(cborData[offset])
+		case 2:
+			argument = uint64(binary.BigEndian.Uint16(cborData[offset:]))
+		case 4:
+			argument = uint64(binary.BigEndian.Uint32(cborData[offset:]))
+		case 8:
+			argument = binary.BigEndian.Uint64(cborData[offset:])
+		}
+		offset += argumentLength
+	default:
+		return 0, fmt.Errorf("invalid additional information %d for major type 0x%x", additionalInfo, majorType)
+	}
+	switch majorType {
+	case CBOR_TYPE_BYTE_STRING, CBOR_TYPE_TEXT_STRING:
+		if argument > uint64(len(cborData)-offset) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return offset + int(argument), nil
+	case CBOR_TYPE_ARRAY, CBOR_TYPE_MAP:
+		numItems := argument
+		if majorType == CBOR_TYPE_MAP {
+			numItems *= 2
+		}
+		// Each item is at least 1 byte, which lets us reject a huge item count without looping over it
+		if argument > uint64(len(cborData)-offset) || numItems > uint64(len(cborData)-offset) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < numItems; i++ {
+			var err error
+			offset, err = itemLength(cborData, offset, depth+1)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return offset, nil
+	case CBOR_TYPE_TAG:
+		return itemLength(cborData, offset, depth+1)
+	default:
+		// Unsigned and negative integers, simple values, and floats consist of just the head
+		return offset, nil
+	}
+}
+
 // Determine the length of a CBOR list
 func ListLength(cborData []byte) (int, error) {
 	// If the list length is <= the max simple uint, then we can extract the length
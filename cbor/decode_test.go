@@ -17,6 +17,7 @@ package cbor_test
 import (
 	"encoding/hex"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
 
@@ -239,3 +240,75 @@ func TestDecodeById(t *testing.T) {
 		}
 	}
 }
+
+type itemLengthTestDefinition struct {
+	CborHex string
+	Length  int
+	Error   error
+}
+
+var itemLengthTests = []itemLengthTestDefinition{
+	// 1
+	{
+		CborHex: "01",
+		Length:  1,
+	},
+	// [1, 2, 3] followed by another item
+	{
+		CborHex: "8301020381",
+		Length:  4,
+	},
+	// {1: h'0102', 2: "a"}
+	{
+		CborHex: "a2014201020261",
+		Error:   io.ErrUnexpectedEOF,
+	},
+	{
+		CborHex: "a2014201020261616161",
+		Length:  8,
+	},
+	// 24(h'01') with a 2 byte length
+	{
+		CborHex: "d818590001" + "01",
+		Length:  6,
+	},
+	// Indefinite length list containing an indefinite length byte string
+	{
+		CborHex: "9f5f4201024103ffff01",
+		Length:  9,
+	},
+	// 2^64-1 element list with no elements
+	{
+		CborHex: "9bffffffffffffffff",
+		Error:   io.ErrUnexpectedEOF,
+	},
+	// Partial argument
+	{
+		CborHex: "1a0001",
+		Error:   io.ErrUnexpectedEOF,
+	},
+	{
+		CborHex: "",
+		Error:   io.EOF,
+	},
+}
+
+func TestItemLength(t *testing.T) {
+	for _, test := range itemLengthTests {
+		cborData, err := hex.DecodeString(test.CborHex)
+		if err != nil {
+			t.Fatalf("failed to decode CBOR hex: %s", err)
+		}
+		length, err := cbor.ItemLength(cborData)
+		if err != test.Error {
+			t.Fatalf("did not get expected error for %s: got %v, wanted %v", test.CborHex, err, test.Error)
+		}
+		if length != test.Length {
+			t.Fatalf("did not get expected length for %s: got %d, wanted %d", test.CborHex, length, test.Length)
+		}
+	}
+	// Invalid additional information
+	if _, err := cbor.ItemLength([]byte{0x1c}); err == nil || err == io.ErrUnexpectedEOF {
+		t.Fatalf("did not get expected error for malformed data: %v", err)
+	}
+}
@@ -23,13 +23,15 @@ import (
 )
 
 type GlobalFlags struct {
-	Flagset      *flag.FlagSet
-	Socket       string
-	Address      string
-	UseTls       bool
-	NtnProto     bool
-	Network      string
-	NetworkMagic int
+	Flagset         *flag.FlagSet
+	Socket          string
+	Address         string
+	UseTls          bool
+	NtnProto        bool
+	Network         string
+	NetworkMagic    int
+	NetworkConfig   string
+	NetworkTopology string
 }
 
 func NewGlobalFlags() *GlobalFlags {
@@ -42,6 +44,8 @@ func NewGlobalFlags() *GlobalFlags {
 	f.Flagset.BoolVar(&f.NtnProto, "ntn", false, "use node-to-node protocol (defaults to node-to-client)")
 	f.Flagset.StringVar(&f.Network, "network", "preview", "specifies network that node is participating in")
 	f.Flagset.IntVar(&f.NetworkMagic, "network-magic", 0, "specifies network magic value. this overrides the -network option")
+	f.Flagset.StringVar(&f.NetworkConfig, "network-config", "", "path to cardano-node config file for a custom network, which is registered with the name from the -network option (required with this option)")
+	f.Flagset.StringVar(&f.NetworkTopology, "network-topology", "", "path to cardano-node topology file for a custom network")
 	return f
 }
 
@@ -50,9 +54,32 @@ func (f *GlobalFlags) Parse() {
 		fmt.Printf("failed to parse command args: %s\n", err)
 		os.Exit(1)
 	}
+	if f.NetworkConfig != "" {
+		// The -network option has a default value, so we make sure that it was given explicitly rather
+		// than registering the custom network as one of the predefined networks
+		networkSet := false
+		f.Flagset.Visit(func(fl *flag.Flag) {
+			if fl.Name == "network" {
+				networkSet = true
+			}
+		})
+		if !networkSet {
+			fmt.Printf("the -network option must specify a name for the custom network when using -network-config\n")
+			os.Exit(1)
+		}
+		network, err := ouroboros.NetworkFromNodeConfig(f.Network, f.NetworkConfig, f.NetworkTopology)
+		if err != nil {
+			fmt.Printf("failed to load network config: %s\n", err)
+			os.Exit(1)
+		}
+		if _, err := ouroboros.RegisterNetwork(network); err != nil {
+			fmt.Printf("failed to register network: %s\n", err)
+			os.Exit(1)
+		}
+	}
 	if f.NetworkMagic == 0 {
 		network := ouroboros.NetworkByName(f.Network)
-		if network == ouroboros.NetworkInvalid {
+		if network.Name == ouroboros.NetworkInvalid.Name {
 			fmt.Printf("Invalid network specified: %s\n", f.Network)
 			os.Exit(1)
 		}
@@ -25,13 +25,15 @@ import (
 )
 
 type globalFlags struct {
-	flagset      *flag.FlagSet
-	socket       string
-	address      string
-	useTls       bool
-	ntnProto     bool
-	network      string
-	networkMagic int
+	flagset         *flag.FlagSet
+	socket          string
+	address         string
+	useTls          bool
+	ntnProto        bool
+	network         string
+	networkMagic    int
+	networkConfig   string
+	networkTopology string
 }
 
 func newGlobalFlags() *globalFlags {
@@ -44,6 +46,8 @@ func newGlobalFlags() *globalFlags {
 	f.flagset.BoolVar(&f.ntnProto, "ntn", false, "use node-to-node protocol (defaults to node-to-client)")
 	f.flagset.StringVar(&f.network, "network", "preview", "specifies network that node is participating in")
 	f.flagset.IntVar(&f.networkMagic, "network-magic", 0, "specifies network magic value. this overrides the -network option")
+	f.flagset.StringVar(&f.networkConfig, "network-config", "", "path to cardano-node config file for a custom network, which is registered with the name from the -network option (required with this option)")
+	f.flagset.StringVar(&f.networkTopology, "network-topology", "", "path to cardano-node topology file for a custom network")
 	return f
 }
 
@@ -55,9 +59,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	if f.networkConfig != "" {
+		// The -network option has a default value, so we make sure that it was given explicitly rather
+		// than registering the custom network as one of the predefined networks
+		networkSet := false
+		f.flagset.Visit(func(fl *flag.Flag) {
+			if fl.Name == "network" {
+				networkSet = true
+			}
+		})
+		if !networkSet {
+			fmt.Printf("the -network option must specify a name for the custom network when using -network-config\n")
+			os.Exit(1)
+		}
+		network, err := ouroboros.NetworkFromNodeConfig(f.network, f.networkConfig, f.networkTopology)
+		if err != nil {
+			fmt.Printf("failed to load network config: %s\n", err)
+			os.Exit(1)
+		}
+		if _, err := ouroboros.RegisterNetwork(network); err != nil {
+			fmt.Printf("failed to register network: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	if f.networkMagic == 0 {
 		network := ouroboros.NetworkByName(f.network)
-		if network == ouroboros.NetworkInvalid {
+		if network.Name == ouroboros.NetworkInvalid.Name {
 			fmt.Printf("Invalid network specified: %s\n", f.network)
 			os.Exit(1)
 		}
@@ -69,30 +69,22 @@ func testServer(f *globalFlags) {
 		os.Exit(1)
 	}
 
-	for {
-		conn, err := listen.Accept()
-		if err != nil {
-			fmt.Printf("ERROR: failed to accept connection: %s\n", err)
-			continue
-		}
-		errorChan := make(chan error)
-		go func() {
-			for {
-				err := <-errorChan
-				fmt.Printf("ERROR: %s\n", err)
-			}
-		}()
-		_, err = ouroboros.New(
-			ouroboros.WithConnection(conn),
+	listener := ouroboros.NewListener(
+		listen,
+		ouroboros.WithListenerConnectionOptions(
 			ouroboros.WithNetworkMagic(uint32(f.networkMagic)),
-			ouroboros.WithErrorChan(errorChan),
 			ouroboros.WithNodeToNode(f.ntnProto),
-			ouroboros.WithServer(true),
-		)
-		if err != nil {
+		),
+		ouroboros.WithListenerConnectionFunc(func(conn *ouroboros.Connection) error {
+			fmt.Printf("handshake completed\n")
+			return nil
+		}),
+		ouroboros.WithListenerErrorFunc(func(conn *ouroboros.Connection, err error) {
 			fmt.Printf("ERROR: %s\n", err)
-		}
-		fmt.Printf("handshake completed...disconnecting\n")
-		conn.Close()
+		}),
+	)
+	if err := listener.Serve(); err != nil {
+		fmt.Printf("ERROR: failed to accept connection: %s\n", err)
+		os.Exit(1)
 	}
 }
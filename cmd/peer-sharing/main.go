@@ -42,12 +42,18 @@ func main() {
 		ouroboros.WithErrorChan(errorChan),
 		ouroboros.WithNodeToNode(f.NtnProto),
 		ouroboros.WithKeepAlive(true),
+		ouroboros.WithPeerSharing(true),
 	)
 	if err != nil {
 		fmt.Printf("ERROR: %s\n", err)
 		os.Exit(1)
 	}
 
+	if o.PeerSharing() == nil {
+		fmt.Printf("ERROR: peer sharing was not negotiated with peer\n")
+		os.Exit(1)
+	}
+
 	peers, err := o.PeerSharing().Client.GetPeers(10)
 	if err != nil {
 		fmt.Printf("ERROR: %s\n", err)
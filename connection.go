@@ -24,12 +24,16 @@
 package ouroboros
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"time"
 
+	"github.com/blinklabs-io/gouroboros/logging"
+	"github.com/blinklabs-io/gouroboros/metrics"
 	"github.com/blinklabs-io/gouroboros/muxer"
 	"github.com/blinklabs-io/gouroboros/protocol"
 	"github.com/blinklabs-io/gouroboros/protocol/blockfetch"
@@ -43,9 +47,12 @@ import (
 	"github.com/blinklabs-io/gouroboros/protocol/txsubmission"
 )
 
+// Time allowed for writing out the final handshake message, such as a Refuse, when the handshake fails
+const handshakeErrorDrainTimeout = 5 * time.Second
+
 // The Connection type is a wrapper around a net.Conn object that handles communication using the Ouroboros network protocol over that connection
 type Connection struct {
-	conn                  net.Conn
+	conn                  muxer.Bearer
 	networkMagic          uint32
 	server                bool
 	useNodeToNodeProto    bool
@@ -53,6 +60,8 @@ type Connection struct {
 	errorChan             chan error
 	protoErrorChan        chan error
 	handshakeFinishedChan chan interface{}
+	onceHandshakeFinished sync.Once
+	handshakeMutex        sync.Mutex
 	doneChan              chan interface{}
 	waitGroup             sync.WaitGroup
 	onceClose             sync.Once
@@ -60,6 +69,20 @@ type Connection struct {
 	delayMuxerStart       bool
 	delayProtocolStart    bool
 	fullDuplex            bool
+	enablePeerSharing     bool
+	logger                logging.Logger
+	metrics               metrics.Provider
+	metricsPeer           string
+	metricsPeerDefault    bool
+	handshakeQuery        bool
+	queryVersions         map[uint16]handshake.VersionData
+	versionData           handshake.VersionData
+	protocolVersion       uint16
+	handshakeFullDuplex   bool
+	eventFuncs            []ConnectionEventFunc
+	ingressLimitOverrides map[uint16]int
+	sduSize               int
+	muxerTapFunc          muxer.TapFunc
 	// Mini-protocols
 	blockFetch              *blockfetch.BlockFetch
 	blockFetchConfig        *blockfetch.Config
@@ -83,6 +106,13 @@ type Connection struct {
 // NewConnection returns a new Connection object with the specified options. If a connection is provided, the
 // handshake will be started. An error will be returned if the handshake fails
 func NewConnection(options ...ConnectionOptionFunc) (*Connection, error) {
+	return NewConnectionContext(context.Background(), options...)
+}
+
+// NewConnectionContext is like NewConnection, but the provided context can be used to abort the handshake.
+// The Connection is closed and the context error is returned if the context is done before the handshake
+// completes
+func NewConnectionContext(ctx context.Context, options ...ConnectionOptionFunc) (*Connection, error) {
 	c := &Connection{
 		protoErrorChan:        make(chan error, 10),
 		handshakeFinishedChan: make(chan interface{}),
@@ -96,13 +126,60 @@ func NewConnection(options ...ConnectionOptionFunc) (*Connection, error) {
 		c.errorChan = make(chan error, 10)
 	}
 	if c.conn != nil {
-		if err := c.setupConnection(); err != nil {
+		if err := c.setupConnection(ctx); err != nil {
 			return nil, err
 		}
 	}
 	return c, nil
 }
 
+// QueryVersions performs a handshake with the query flag set, which asks the peer for its supported protocol
+// versions and their version data without opening any mini-protocols. The options are the same as for
+// NewConnection, and an existing connection must be provided with WithConnection. The connection is closed
+// afterward. If the peer does not support the query flag, only the version that it accepted is returned. NtC
+// version numbers are returned without the bit used to signify NtC versions in the handshake
+func QueryVersions(options ...ConnectionOptionFunc) (map[uint16]handshake.VersionData, error) {
+	return QueryVersionsContext(context.Background(), options...)
+}
+
+// QueryVersionsContext is like QueryVersions, but the provided context can be used to abort the handshake
+func QueryVersionsContext(ctx context.Context, options ...ConnectionOptionFunc) (map[uint16]handshake.VersionData, error) {
+	c := &Connection{
+		protoErrorChan:        make(chan error, 10),
+		handshakeFinishedChan: make(chan interface{}),
+		doneChan:              make(chan interface{}),
+		handshakeQuery:        true,
+	}
+	// Apply provided options functions
+	for _, option := range options {
+		option(c)
+	}
+	if c.conn == nil {
+		return nil, fmt.Errorf("no connection provided")
+	}
+	if c.server {
+		return nil, fmt.Errorf("version query is not supported in server mode")
+	}
+	if c.errorChan == nil {
+		c.errorChan = make(chan error, 10)
+	}
+	if err := c.setupConnection(ctx); err != nil {
+		return nil, err
+	}
+	versions := make(map[uint16]handshake.VersionData)
+	c.handshakeMutex.Lock()
+	queryVersions := c.queryVersions
+	c.handshakeMutex.Unlock()
+	for version, versionData := range queryVersions {
+		// Drop bit used to signify NtC protocol versions
+		if !c.useNodeToNodeProto && version > protocolVersionNtCFlag {
+			version = version - protocolVersionNtCFlag
+		}
+		versions[version] = versionData
+	}
+	return versions, nil
+}
+
 // New is an alias to NewConnection for backward compatibility
 func New(options ...ConnectionOptionFunc) (*Connection, error) {
 	return NewConnection(options...)
@@ -113,6 +190,54 @@ func (c *Connection) Muxer() *muxer.Muxer {
 	return c.muxer
 }
 
+// VersionData returns the version data accepted in the handshake. This is nil until the handshake completes
+func (c *Connection) VersionData() handshake.VersionData {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	return c.versionData
+}
+
+// ProtocolVersion returns the protocol version negotiated in the handshake. NtC versions are returned without
+// the bit used to signify NtC versions in the handshake
+func (c *Connection) ProtocolVersion() uint16 {
+	return c.protocolVersion
+}
+
+// ProtocolVersionNtN returns the features of the negotiated NtN protocol version. This is nil for NtC
+// connections and until the handshake completes
+func (c *Connection) ProtocolVersionNtN() *ProtocolVersionNtN {
+	if !c.useNodeToNodeProto || c.protocolVersion == 0 {
+		return nil
+	}
+	versionNtN := GetProtocolVersionNtN(c.protocolVersion)
+	return &versionNtN
+}
+
+// ProtocolVersionNtC returns the features of the negotiated NtC protocol version. This is nil for NtN
+// connections and until the handshake completes
+func (c *Connection) ProtocolVersionNtC() *ProtocolVersionNtC {
+	if c.useNodeToNodeProto || c.protocolVersion == 0 {
+		return nil
+	}
+	versionNtC := GetProtocolVersionNtC(c.protocolVersion)
+	return &versionNtC
+}
+
+// FullDuplex returns whether full duplex mode was negotiated in the handshake, which allows both sides to run
+// the client and server side of the mini-protocols
+func (c *Connection) FullDuplex() bool {
+	return c.handshakeFullDuplex
+}
+
+// TimingStats returns the round-trip time and clock drift estimated from the segments exchanged with the peer.
+// These can be used to compare peers without sending extra traffic to them
+func (c *Connection) TimingStats() muxer.TimingStats {
+	if c.muxer == nil {
+		return muxer.TimingStats{}
+	}
+	return c.muxer.TimingStats()
+}
+
 // ErrorChan returns the channel for asynchronous errors
 func (c *Connection) ErrorChan() chan error {
 	return c.errorChan
@@ -123,15 +248,23 @@ func (c *Connection) ErrorChan() chan error {
 // An error will be returned if the connection fails, a connection was already established, or the
 // handshake fails
 func (c *Connection) Dial(proto string, address string) error {
+	return c.DialContext(context.Background(), proto, address)
+}
+
+// DialContext is like Dial, but the provided context is used when establishing the connection and can be used
+// to abort the handshake. The Connection is closed and the context error is returned if the context is done
+// before the handshake completes
+func (c *Connection) DialContext(ctx context.Context, proto string, address string) error {
 	if c.conn != nil {
 		return fmt.Errorf("a connection was already established")
 	}
-	conn, err := net.Dial(proto, address)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, proto, address)
 	if err != nil {
 		return err
 	}
 	c.conn = conn
-	if err := c.setupConnection(); err != nil {
+	if err := c.setupConnection(ctx); err != nil {
 		return err
 	}
 	return nil
@@ -139,36 +272,135 @@ func (c *Connection) Dial(proto string, address string) error {
 
 // Close will shutdown the Ouroboros connection
 func (c *Connection) Close() error {
+	return c.close(false, time.Time{}, nil)
+}
+
+// CloseGracefully shuts down the Ouroboros connection after each running mini-protocol client sends its
+// terminating message. Clients wait for pending responses before sending that message, and the messages are
+// written out before the connection is closed. An active chain-sync stops requesting blocks, but its outstanding
+// requests must still be answered, which at the chain tip means waiting for new blocks. The connection is closed immediately and the context error is
+// returned if the context is done first. The context deadline, if any, also limits the time spent writing.
+// TxSubmission has no terminating message that the client can send on its own, so it's not waited on
+func (c *Connection) CloseGracefully(ctx context.Context) error {
+	finishedChans := []chan struct{}{}
+	for _, client := range c.stoppableClients() {
+		if !client.Started() {
+			continue
+		}
+		finishedChans = append(finishedChans, client.FinishedChan())
+		// Stopping can block while waiting for another operation to complete, so we don't wait on it here
+		go func(client stoppableClient) {
+			if err := client.Stop(); err != nil {
+				c.logger.Warn("failed to stop mini-protocol", "error", err)
+			}
+		}(client)
+	}
+	c.logger.Debug("closing connection gracefully", "protocols", len(finishedChans))
+	for _, finishedChan := range finishedChans {
+		select {
+		case <-ctx.Done():
+			c.logger.Warn("graceful close aborted", "error", ctx.Err())
+			c.close(false, time.Time{}, ctx.Err())
+			return ctx.Err()
+		case <-c.doneChan:
+			// The connection was closed by something else in the meantime
+			return nil
+		case <-finishedChan:
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	return c.close(true, deadline, nil)
+}
+
+// stoppableClient is implemented by the mini-protocol clients that can send a terminating message
+type stoppableClient interface {
+	Started() bool
+	FinishedChan() chan struct{}
+	Stop() error
+}
+
+// stoppableClients returns the mini-protocol clients that can send a terminating message
+func (c *Connection) stoppableClients() []stoppableClient {
+	ret := []stoppableClient{}
+	if c.chainSync != nil {
+		ret = append(ret, c.chainSync.Client)
+	}
+	if c.blockFetch != nil {
+		ret = append(ret, c.blockFetch.Client)
+	}
+	if c.keepAlive != nil {
+		ret = append(ret, c.keepAlive.Client)
+	}
+	if c.peerSharing != nil {
+		ret = append(ret, c.peerSharing.Client)
+	}
+	if c.localTxSubmission != nil {
+		ret = append(ret, c.localTxSubmission.Client)
+	}
+	if c.localStateQuery != nil {
+		ret = append(ret, c.localStateQuery.Client)
+	}
+	if c.localTxMonitor != nil {
+		ret = append(ret, c.localTxMonitor.Client)
+	}
+	return ret
+}
+
+// close shuts down the connection. When draining, segments that are queued for sending are written before the
+// underlying connection is closed, with writes limited by the provided deadline. The provided error is the
+// cause of the close, which is nil for a local close
+func (c *Connection) close(drain bool, deadline time.Time, closeErr error) error {
 	var err error
+	closed := false
 	c.onceClose.Do(func() {
+		closed = true
 		// Close doneChan to signify that we're shutting down
 		close(c.doneChan)
 		// Gracefully stop the muxer
 		if c.muxer != nil {
-			c.muxer.Stop()
+			if drain {
+				c.muxer.Drain(deadline)
+			} else {
+				c.muxer.Stop()
+			}
 		}
 		// Wait for other goroutines to finish
 		c.waitGroup.Wait()
+		// Remove the metrics for a connection that isn't using a stable peer label, since they would otherwise
+		// be kept forever
+		if c.metrics != nil && c.metricsPeerDefault {
+			metrics.DeleteLabels(c.metrics)
+		}
 		// Close channels
 		close(c.errorChan)
 		close(c.protoErrorChan)
-		// We can only close a channel once, so we have to jump through a few hoops
-		select {
-		// The channel is either closed or has an item pending
-		case _, ok := <-c.handshakeFinishedChan:
-			// We successfully retrieved an item
-			// This will probably never happen, but it doesn't hurt to cover this case
-			if ok {
-				close(c.handshakeFinishedChan)
-			}
-		// The channel is open and has no pending items
-		default:
-			close(c.handshakeFinishedChan)
-		}
+		c.finishHandshake()
 	})
+	// The event is sent outside of the sync.Once, since the event function may call Close()
+	if closed {
+		event := ConnectionEvent{
+			Type:   ConnectionEventClosed,
+			Reason: closeReasonForError(closeErr),
+			Error:  closeErr,
+		}
+		var protoErr protocol.ProtocolError
+		if errors.As(closeErr, &protoErr) {
+			event.Protocol = protoErr.Protocol
+			event.Role = protoErr.Role
+		}
+		c.emitEvent(event)
+	}
 	return err
 }
 
+// finishHandshake signals that the handshake is no longer pending. This happens either when it completes or when
+// the connection is closed, which may race with each other
+func (c *Connection) finishHandshake() {
+	c.onceHandshakeFinished.Do(func() {
+		close(c.handshakeFinishedChan)
+	})
+}
+
 // BlockFetch returns the block-fetch protocol handler
 func (c *Connection) BlockFetch() *blockfetch.BlockFetch {
 	return c.blockFetch
@@ -204,7 +436,8 @@ func (c *Connection) LocalTxSubmission() *localtxsubmission.LocalTxSubmission {
 	return c.localTxSubmission
 }
 
-// PeerSharing returns the peer-sharing protocol handler
+// PeerSharing returns the peer-sharing protocol handler. This is nil unless both sides agreed to share peers in
+// the handshake
 func (c *Connection) PeerSharing() *peersharing.PeerSharing {
 	return c.peerSharing
 }
@@ -216,8 +449,33 @@ func (c *Connection) TxSubmission() *txsubmission.TxSubmission {
 
 // setupConnection establishes the muxer, configures and starts the handshake process, and initializes
 // the appropriate mini-protocols
-func (c *Connection) setupConnection() error {
-	c.muxer = muxer.New(c.conn)
+func (c *Connection) setupConnection(ctx context.Context) error {
+	// The remote address is used as the metrics peer label by default. It usually includes an ephemeral port,
+	// so the connection's metrics are removed when it's closed
+	if c.metricsPeer == "" {
+		c.metricsPeerDefault = true
+	}
+	// Add the remote address to all log events for this connection
+	if remoteAddr := c.conn.RemoteAddr(); remoteAddr != nil {
+		c.logger = logging.With(c.logger, "peer", remoteAddr.String())
+		if c.metricsPeerDefault {
+			c.metricsPeer = remoteAddr.String()
+		}
+	} else {
+		c.logger = logging.With(c.logger)
+	}
+	// Give the metrics for this connection their own label, so that connections can be told apart
+	if c.metrics != nil {
+		c.metrics = metrics.WithLabels(c.metrics, "peer", c.metricsPeer)
+	}
+	c.muxer = muxer.New(
+		c.conn,
+		muxer.WithLogger(c.logger),
+		muxer.WithMetrics(c.metrics),
+		muxer.WithIngressLimits(c.ingressLimits()),
+		muxer.WithSDUSize(c.sduSize),
+		muxer.WithTap(c.muxerTapFunc),
+	)
 	// Start Goroutine to pass along errors from the muxer
 	c.waitGroup.Add(1)
 	go func() {
@@ -232,25 +490,43 @@ func (c *Connection) setupConnection() error {
 			}
 			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 				// Return a bare io.EOF error if error is EOF/ErrUnexpectedEOF
+				c.logger.Info("connection closed by peer")
 				c.errorChan <- io.EOF
 			} else {
 				// Wrap error message to denote it comes from the muxer
-				c.errorChan <- fmt.Errorf("muxer error: %s", err)
+				c.logger.Error("muxer error", "error", err)
+				c.errorChan <- fmt.Errorf("muxer error: %w", err)
 			}
 			// Close connection on muxer errors
-			c.Close()
+			// We do this in a goroutine, since close() waits for this goroutine to finish
+			go c.close(false, time.Time{}, err)
 		}
 	}()
 	protoOptions := protocol.ProtocolOptions{
 		Muxer:     c.muxer,
 		ErrorChan: c.protoErrorChan,
+		Logger:    c.logger,
+		Metrics:   c.metrics,
+		StartedFunc: func(name string, role protocol.ProtocolRole) {
+			c.emitEvent(ConnectionEvent{
+				Type:     ConnectionEventProtocolStarted,
+				Protocol: name,
+				Role:     role,
+			})
+		},
 	}
 	var protoVersions []uint16
 	if c.useNodeToNodeProto {
 		protoVersions = GetProtocolVersionsNtN()
+		if c.handshakeQuery {
+			protoVersions = getQueryProtocolVersionsNtN()
+		}
 		protoOptions.Mode = protocol.ProtocolModeNodeToNode
 	} else {
 		protoVersions = GetProtocolVersionsNtC()
+		if c.handshakeQuery {
+			protoVersions = getQueryProtocolVersionsNtC()
+		}
 		protoOptions.Mode = protocol.ProtocolModeNodeToClient
 	}
 	if c.server {
@@ -263,20 +539,40 @@ func (c *Connection) setupConnection() error {
 		return fmt.Errorf("invalid network magic value provided: %d\n", c.networkMagic)
 	}
 	// Perform handshake
+	peerSharingMode := uint(handshake.PeerSharingModeNoPeerSharing)
+	if c.enablePeerSharing {
+		peerSharingMode = handshake.PeerSharingModePeerSharingPublic
+	}
+	// The handshake callbacks run on the handshake protocol goroutine, so the results are published under the
+	// handshake lock
 	var handshakeVersion uint16
-	var handshakeFullDuplex bool
+	var handshakeComplete bool
 	handshakeConfig := handshake.NewConfig(
 		handshake.WithProtocolVersions(protoVersions),
 		handshake.WithNetworkMagic(c.networkMagic),
 		handshake.WithClientFullDuplex(c.fullDuplex),
-		handshake.WithFinishedFunc(func(version uint16, fullDuplex bool) error {
+		handshake.WithPeerSharing(peerSharingMode),
+		handshake.WithFinishedFunc(func(version uint16, versionData handshake.VersionData) error {
+			c.handshakeMutex.Lock()
 			handshakeVersion = version
-			handshakeFullDuplex = fullDuplex
-			close(c.handshakeFinishedChan)
+			handshakeComplete = true
+			c.versionData = versionData
+			c.handshakeMutex.Unlock()
+			c.finishHandshake()
+			return nil
+		}),
+		handshake.WithQuery(c.handshakeQuery),
+		handshake.WithQueryReplyFunc(func(versions map[uint16]handshake.VersionData) error {
+			c.handshakeMutex.Lock()
+			handshakeComplete = true
+			c.queryVersions = versions
+			c.handshakeMutex.Unlock()
+			c.finishHandshake()
 			return nil
 		}),
 	)
 	c.handshake = handshake.New(protoOptions, &handshakeConfig)
+	c.logger.Debug("starting handshake", "server", c.server, "node_to_node", c.useNodeToNodeProto)
 	if c.server {
 		c.handshake.Server.Start()
 	} else {
@@ -287,17 +583,57 @@ func (c *Connection) setupConnection() error {
 	case <-c.doneChan:
 		// Return an error if we're shutting down
 		return io.EOF
+	case <-ctx.Done():
+		// Shutdown the connection, since the handshake can't be resumed
+		c.logger.Warn("handshake aborted", "error", ctx.Err())
+		c.close(false, time.Time{}, ctx.Err())
+		return ctx.Err()
 	case err := <-c.protoErrorChan:
+		c.logger.Warn("handshake failed", "error", err)
+		c.emitProtocolError(err)
+		// Shutdown the connection, since nothing else can be done with it. We drain the muxer so that a
+		// Refuse message from the server side reaches the peer
+		c.close(true, time.Now().Add(handshakeErrorDrainTimeout), err)
+		// Return the handshake error itself rather than the wrapper with the protocol name
+		var protoErr protocol.ProtocolError
+		if errors.As(err, &protoErr) {
+			return protoErr.Err
+		}
 		return err
 	case <-c.handshakeFinishedChan:
 		// This is purposely empty, but we need this case to break out when this channel is closed
 	}
+	c.handshakeMutex.Lock()
+	complete := handshakeComplete
+	negotiatedVersion := handshakeVersion
+	versionData := c.versionData
+	queryVersions := c.queryVersions
+	c.handshakeMutex.Unlock()
+	// The channel is also closed when the connection is closed, in which case there are no results
+	if !complete {
+		return io.EOF
+	}
+	// The connection can't be used after a version query
+	if c.handshakeQuery {
+		c.logger.Info("version query complete", "versions", len(queryVersions))
+		c.Close()
+		return nil
+	}
+	// Only NtN connections can be full duplex
+	handshakeFullDuplex := c.useNodeToNodeProto && !versionData.InitiatorOnlyDiffusionMode()
+	c.logger.Info("handshake complete", "version", negotiatedVersion, "full_duplex", handshakeFullDuplex)
 	// Provide the negotiated protocol version to the various mini-protocols
-	protoOptions.Version = handshakeVersion
+	protoOptions.Version = negotiatedVersion
 	// Drop bit used to signify NtC protocol versions
 	if protoOptions.Version > protocolVersionNtCFlag {
 		protoOptions.Version = protoOptions.Version - protocolVersionNtCFlag
 	}
+	c.protocolVersion = protoOptions.Version
+	c.handshakeFullDuplex = handshakeFullDuplex
+	c.emitEvent(ConnectionEvent{
+		Type:    ConnectionEventHandshakeComplete,
+		Version: c.protocolVersion,
+	})
 	// Start Goroutine to pass along errors from the mini-protocols
 	c.waitGroup.Add(1)
 	go func() {
@@ -311,14 +647,16 @@ func (c *Connection) setupConnection() error {
 			if !ok {
 				return
 			}
-			c.errorChan <- fmt.Errorf("protocol error: %s", err)
+			c.emitProtocolError(err)
+			c.errorChan <- fmt.Errorf("protocol error: %w", err)
 			// Close connection on mini-protocol errors
-			c.Close()
+			// We do this in a goroutine, since close() waits for this goroutine to finish
+			go c.close(false, time.Time{}, err)
 		}
 	}()
 	// Configure the relevant mini-protocols
 	if c.useNodeToNodeProto {
-		versionNtN := GetProtocolVersionNtN(handshakeVersion)
+		versionNtN := GetProtocolVersionNtN(negotiatedVersion)
 		protoOptions.Mode = protocol.ProtocolModeNodeToNode
 		c.chainSync = chainsync.New(protoOptions, c.chainSyncConfig)
 		c.blockFetch = blockfetch.New(protoOptions, c.blockFetchConfig)
@@ -329,7 +667,9 @@ func (c *Connection) setupConnection() error {
 				c.keepAlive.Client.Start()
 			}
 		}
-		if versionNtN.EnablePeerSharingProtocol {
+		// Both sides must be willing to share peers. The server's accepted version data reflects its own
+		// willingness, and the server takes ours into account
+		if versionNtN.EnablePeerSharingProtocol && c.enablePeerSharing && versionData.PeerSharing() != handshake.PeerSharingModeNoPeerSharing {
 			c.peerSharing = peersharing.New(protoOptions, c.peerSharingConfig)
 		}
 		// Start protocols
@@ -352,7 +692,7 @@ func (c *Connection) setupConnection() error {
 			}
 		}
 	} else {
-		versionNtC := GetProtocolVersionNtC(handshakeVersion)
+		versionNtC := GetProtocolVersionNtC(negotiatedVersion)
 		protoOptions.Mode = protocol.ProtocolModeNodeToClient
 		c.chainSync = chainsync.New(protoOptions, c.chainSyncConfig)
 		c.localTxSubmission = localtxsubmission.New(protoOptions, c.localTxSubmissionConfig)
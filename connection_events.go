@@ -0,0 +1,150 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/blinklabs-io/gouroboros/muxer"
+	"github.com/blinklabs-io/gouroboros/protocol"
+)
+
+// ConnectionEventType is an enum of the connection lifecycle event types
+type ConnectionEventType uint
+
+const (
+	ConnectionEventHandshakeComplete ConnectionEventType = 1 // The handshake completed successfully
+	ConnectionEventProtocolStarted   ConnectionEventType = 2 // A mini-protocol was started
+	ConnectionEventProtocolError     ConnectionEventType = 3 // A mini-protocol failed
+	ConnectionEventClosed            ConnectionEventType = 4 // The connection was closed
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionEventHandshakeComplete:
+		return "handshake complete"
+	case ConnectionEventProtocolStarted:
+		return "protocol started"
+	case ConnectionEventProtocolError:
+		return "protocol error"
+	case ConnectionEventClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// CloseReason is an enum of the reasons for a connection being closed
+type CloseReason uint
+
+const (
+	CloseReasonNone              CloseReason = 0 // Default (invalid) close reason
+	CloseReasonLocal             CloseReason = 1 // The connection was closed on our side
+	CloseReasonRemote            CloseReason = 2 // The connection was closed by the peer
+	CloseReasonTimeout           CloseReason = 3 // The peer didn't respond in time
+	CloseReasonProtocolViolation CloseReason = 4 // A message was received that the protocol does not allow
+	CloseReasonError             CloseReason = 5 // Any other error, such as a failed handshake or a network error
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonLocal:
+		return "local close"
+	case CloseReasonRemote:
+		return "remote close"
+	case CloseReasonTimeout:
+		return "timeout"
+	case CloseReasonProtocolViolation:
+		return "protocol violation"
+	case CloseReasonError:
+		return "error"
+	default:
+		return "none"
+	}
+}
+
+// ConnectionEvent describes a change in the lifecycle of a Connection. Only the fields relevant to the event
+// type are populated
+type ConnectionEvent struct {
+	Type ConnectionEventType
+	// Version is the negotiated protocol version for ConnectionEventHandshakeComplete. NtC versions are provided
+	// without the bit used to signify NtC versions in the handshake
+	Version uint16
+	// Protocol and Role identify the mini-protocol for ConnectionEventProtocolStarted and
+	// ConnectionEventProtocolError, and for ConnectionEventClosed when the close was caused by a mini-protocol
+	Protocol string
+	Role     protocol.ProtocolRole
+	// Reason is the reason for ConnectionEventClosed
+	Reason CloseReason
+	// Error is the cause of ConnectionEventProtocolError, or the error that caused ConnectionEventClosed. It's
+	// nil for a local close
+	Error error
+}
+
+// ConnectionEventFunc is a function that is called for each connection lifecycle event. It's called
+// synchronously, so it should return quickly
+type ConnectionEventFunc func(*Connection, ConnectionEvent)
+
+// emitEvent calls the registered event functions with the provided event
+func (c *Connection) emitEvent(event ConnectionEvent) {
+	for _, eventFunc := range c.eventFuncs {
+		eventFunc(c, event)
+	}
+}
+
+// emitProtocolError calls the registered event functions with an event for the provided mini-protocol error
+func (c *Connection) emitProtocolError(err error) {
+	event := ConnectionEvent{
+		Type:  ConnectionEventProtocolError,
+		Error: err,
+	}
+	var protoErr protocol.ProtocolError
+	if errors.As(err, &protoErr) {
+		event.Protocol = protoErr.Protocol
+		event.Role = protoErr.Role
+		event.Error = protoErr.Err
+	}
+	c.emitEvent(event)
+}
+
+// closeReasonForError determines the close reason for the error that caused the connection to be closed
+func closeReasonForError(err error) CloseReason {
+	var netErr net.Error
+	var stateTimeoutErr protocol.StateTimeoutError
+	var invalidMessageErr protocol.InvalidMessageError
+	var decodeErr protocol.DecodeError
+	var unknownMessageErr protocol.UnknownMessageError
+	var ingressLimitErr muxer.IngressLimitExceededError
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return CloseReasonLocal
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return CloseReasonRemote
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &stateTimeoutErr),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return CloseReasonTimeout
+	case errors.As(err, &invalidMessageErr),
+		errors.As(err, &decodeErr),
+		errors.As(err, &unknownMessageErr),
+		errors.As(err, &ingressLimitErr):
+		return CloseReasonProtocolViolation
+	default:
+		return CloseReasonError
+	}
+}
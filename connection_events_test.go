@@ -0,0 +1,158 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/chainsync"
+)
+
+// newEventConnection returns a client Connection for the provided mock conversation and a channel that receives
+// its connection events
+func newEventConnection(t *testing.T, conversation []ouroboros_mock.ConversationEntry) (*ouroboros.Connection, chan ouroboros.ConnectionEvent) {
+	eventChan := make(chan ouroboros.ConnectionEvent, 50)
+	mockConn := ouroboros_mock.NewConnection(ouroboros_mock.ProtocolRoleClient, conversation)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithConnectionEventFunc(func(conn *ouroboros.Connection, event ouroboros.ConnectionEvent) {
+			eventChan <- event
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	return oConn, eventChan
+}
+
+// waitForClosedEvent returns the events up to and including the closed event
+func waitForClosedEvent(t *testing.T, eventChan chan ouroboros.ConnectionEvent) []ouroboros.ConnectionEvent {
+	var events []ouroboros.ConnectionEvent
+	for {
+		select {
+		case event := <-eventChan:
+			events = append(events, event)
+			if event.Type == ouroboros.ConnectionEventClosed {
+				return events
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("did not receive closed event, got events: %#v", events)
+		}
+	}
+}
+
+func findEvent(events []ouroboros.ConnectionEvent, matchFunc func(ouroboros.ConnectionEvent) bool) bool {
+	for _, event := range events {
+		if matchFunc(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConnectionEventsLocalClose(t *testing.T) {
+	oConn, eventChan := newEventConnection(
+		t,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Connection object: %s", err)
+	}
+	events := waitForClosedEvent(t, eventChan)
+	if !findEvent(events, func(event ouroboros.ConnectionEvent) bool {
+		return event.Type == ouroboros.ConnectionEventHandshakeComplete && event.Version == 14
+	}) {
+		t.Fatalf("did not receive handshake complete event, got events: %#v", events)
+	}
+	if !findEvent(events, func(event ouroboros.ConnectionEvent) bool {
+		return event.Type == ouroboros.ConnectionEventProtocolStarted &&
+			event.Protocol == chainsync.ProtocolName &&
+			event.Role == protocol.ProtocolRoleClient
+	}) {
+		t.Fatalf("did not receive protocol started event for chain-sync, got events: %#v", events)
+	}
+	closedEvent := events[len(events)-1]
+	if closedEvent.Reason != ouroboros.CloseReasonLocal || closedEvent.Error != nil {
+		t.Fatalf("did not get expected closed event, got: %#v", closedEvent)
+	}
+}
+
+func TestConnectionEventsRemoteClose(t *testing.T) {
+	_, eventChan := newEventConnection(
+		t,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+			{
+				Type: ouroboros_mock.EntryTypeClose,
+			},
+		},
+	)
+	events := waitForClosedEvent(t, eventChan)
+	closedEvent := events[len(events)-1]
+	if closedEvent.Reason != ouroboros.CloseReasonRemote {
+		t.Fatalf("did not get expected close reason, got: %#v", closedEvent)
+	}
+}
+
+func TestConnectionEventsProtocolViolation(t *testing.T) {
+	oConn, eventChan := newEventConnection(
+		t,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+			{
+				Type:             ouroboros_mock.EntryTypeInput,
+				ProtocolId:       chainsync.ProtocolIdNtC,
+				InputMessageType: chainsync.MessageTypeFindIntersect,
+			},
+			// AwaitReply is not allowed in response to FindIntersect
+			{
+				Type:       ouroboros_mock.EntryTypeOutput,
+				ProtocolId: chainsync.ProtocolIdNtC,
+				IsResponse: true,
+				OutputMessages: []protocol.Message{
+					chainsync.NewMsgAwaitReply(),
+				},
+			},
+		},
+	)
+	go func() {
+		_, _ = oConn.ChainSync().Client.GetCurrentTip()
+	}()
+	events := waitForClosedEvent(t, eventChan)
+	var invalidMessageErr protocol.InvalidMessageError
+	if !findEvent(events, func(event ouroboros.ConnectionEvent) bool {
+		return event.Type == ouroboros.ConnectionEventProtocolError &&
+			event.Protocol == chainsync.ProtocolName &&
+			event.Role == protocol.ProtocolRoleClient &&
+			errors.As(event.Error, &invalidMessageErr)
+	}) {
+		t.Fatalf("did not receive protocol error event for chain-sync, got events: %#v", events)
+	}
+	closedEvent := events[len(events)-1]
+	if closedEvent.Reason != ouroboros.CloseReasonProtocolViolation || closedEvent.Protocol != chainsync.ProtocolName {
+		t.Fatalf("did not get expected closed event, got: %#v", closedEvent)
+	}
+}
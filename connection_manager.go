@@ -0,0 +1,268 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnectionManager maintains an Ouroboros connection to a single address. It dials the address, performs
+// the handshake, and reconnects with exponential backoff when the connection fails
+type ConnectionManager struct {
+	dialProto         string
+	dialAddress       string
+	connOptions       []ConnectionOptionFunc
+	backoffInitial    time.Duration
+	backoffMax        time.Duration
+	backoffMultiplier float64
+	backoffJitter     float64
+	jitterRand        *rand.Rand
+	maxAttempts       int
+	connectedFunc     ConnectionManagerConnectedFunc
+	disconnectedFunc  ConnectionManagerDisconnectedFunc
+	giveUpFunc        ConnectionManagerGiveUpFunc
+	conn              *Connection
+	connMutex         sync.Mutex
+	ctx               context.Context
+	cancelFunc        context.CancelFunc
+	doneChan          chan interface{}
+	onceStart         sync.Once
+	onceStop          sync.Once
+}
+
+// Callback function types
+type ConnectionManagerConnectedFunc func(*Connection) error
+type ConnectionManagerDisconnectedFunc func(*Connection, error)
+type ConnectionManagerGiveUpFunc func(error)
+
+// ConnectionManagerOptionFunc is a type that represents functions that modify the ConnectionManager config
+type ConnectionManagerOptionFunc func(*ConnectionManager)
+
+// NewConnectionManager returns a new ConnectionManager for the specified protocol and address. These are
+// passed to [net.Dialer.DialContext] for each connection attempt
+func NewConnectionManager(proto string, address string, options ...ConnectionManagerOptionFunc) *ConnectionManager {
+	m := &ConnectionManager{
+		dialProto:         proto,
+		dialAddress:       address,
+		backoffInitial:    1 * time.Second,
+		backoffMax:        60 * time.Second,
+		backoffMultiplier: 2,
+		backoffJitter:     0.2,
+		doneChan:          make(chan interface{}),
+		// The global source isn't seeded, so each ConnectionManager gets its own. It's only used from the
+		// run loop, so it doesn't need a lock
+		jitterRand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	m.ctx, m.cancelFunc = context.WithCancel(context.Background())
+	// Apply provided options functions
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// WithConnectionOptions specifies the options to use when creating each Connection. WithErrorChan should not
+// be used here, since each Connection closes its error channel on shutdown
+func WithConnectionOptions(options ...ConnectionOptionFunc) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.connOptions = options
+	}
+}
+
+// WithBackoff specifies the delay before the first reconnect attempt, the maximum delay, and the multiplier
+// applied to the delay after each failed attempt
+func WithBackoff(initial time.Duration, max time.Duration, multiplier float64) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.backoffInitial = initial
+		m.backoffMax = max
+		m.backoffMultiplier = multiplier
+	}
+}
+
+// WithBackoffJitter specifies the fraction (0.0-1.0) of each delay that is randomized to avoid many clients
+// reconnecting at the same time
+func WithBackoffJitter(jitter float64) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.backoffJitter = jitter
+	}
+}
+
+// WithMaxAttempts specifies the number of consecutive failed connection attempts before giving up. The default
+// of 0 means that we never give up
+func WithMaxAttempts(maxAttempts int) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.maxAttempts = maxAttempts
+	}
+}
+
+// WithConnectedFunc specifies a callback function that is called after each successful connection and handshake.
+// Returning an error closes the connection and triggers a reconnect
+func WithConnectedFunc(connectedFunc ConnectionManagerConnectedFunc) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.connectedFunc = connectedFunc
+	}
+}
+
+// WithDisconnectedFunc specifies a callback function that is called with the reason when an established
+// connection fails
+func WithDisconnectedFunc(disconnectedFunc ConnectionManagerDisconnectedFunc) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.disconnectedFunc = disconnectedFunc
+	}
+}
+
+// WithGiveUpFunc specifies a callback function that is called with the last error when the maximum number of
+// connection attempts is reached
+func WithGiveUpFunc(giveUpFunc ConnectionManagerGiveUpFunc) ConnectionManagerOptionFunc {
+	return func(m *ConnectionManager) {
+		m.giveUpFunc = giveUpFunc
+	}
+}
+
+// Start begins connecting in the background
+func (m *ConnectionManager) Start() {
+	m.onceStart.Do(func() {
+		go m.run()
+	})
+}
+
+// Stop closes the current connection, if any, and stops reconnecting
+func (m *ConnectionManager) Stop() {
+	m.onceStop.Do(func() {
+		m.cancelFunc()
+		m.connMutex.Lock()
+		if m.conn != nil {
+			m.conn.Close()
+		}
+		m.connMutex.Unlock()
+	})
+}
+
+// DoneChan returns a channel that is closed once the ConnectionManager has stopped or given up
+func (m *ConnectionManager) DoneChan() <-chan interface{} {
+	return m.doneChan
+}
+
+// Connection returns the current Connection, or nil if not currently connected
+func (m *ConnectionManager) Connection() *Connection {
+	m.connMutex.Lock()
+	defer m.connMutex.Unlock()
+	return m.conn
+}
+
+func (m *ConnectionManager) run() {
+	defer close(m.doneChan)
+	// Number of consecutive failed connection attempts
+	attempt := 0
+	for {
+		conn, err := m.connect()
+		// Check if we're shutting down
+		if m.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// Reset the backoff after a successful connection
+			attempt = 0
+			m.waitForDisconnect(conn)
+			if m.ctx.Err() != nil {
+				return
+			}
+		} else {
+			attempt++
+			if m.maxAttempts > 0 && attempt >= m.maxAttempts {
+				if m.giveUpFunc != nil {
+					m.giveUpFunc(fmt.Errorf("giving up after %d attempts: %s", attempt, err))
+				}
+				return
+			}
+		}
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(m.backoffDelay(attempt)):
+		}
+	}
+}
+
+func (m *ConnectionManager) connect() (*Connection, error) {
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(m.ctx, m.dialProto, m.dialAddress)
+	if err != nil {
+		return nil, err
+	}
+	options := append([]ConnectionOptionFunc{}, m.connOptions...)
+	options = append(options, WithConnection(netConn))
+	conn, err := NewConnectionContext(m.ctx, options...)
+	if err != nil {
+		// Make sure the underlying connection doesn't leak on a failed handshake
+		_ = netConn.Close()
+		return nil, err
+	}
+	m.connMutex.Lock()
+	// Check if we were stopped while connecting
+	if m.ctx.Err() != nil {
+		m.connMutex.Unlock()
+		conn.Close()
+		return nil, m.ctx.Err()
+	}
+	m.conn = conn
+	m.connMutex.Unlock()
+	return conn, nil
+}
+
+// waitForDisconnect calls the connected callback and blocks until the connection fails
+func (m *ConnectionManager) waitForDisconnect(conn *Connection) {
+	var err error
+	if m.connectedFunc != nil {
+		err = m.connectedFunc(conn)
+	}
+	if err == nil {
+		var ok bool
+		err, ok = <-conn.ErrorChan()
+		if !ok {
+			// The connection was closed without an error
+			err = fmt.Errorf("connection closed")
+		}
+	}
+	conn.Close()
+	m.connMutex.Lock()
+	m.conn = nil
+	m.connMutex.Unlock()
+	if m.disconnectedFunc != nil && m.ctx.Err() == nil {
+		m.disconnectedFunc(conn, err)
+	}
+}
+
+// backoffDelay returns the delay before reconnecting after the specified number of failed attempts
+func (m *ConnectionManager) backoffDelay(attempt int) time.Duration {
+	delay := float64(m.backoffInitial)
+	for i := 1; i < attempt; i++ {
+		delay = delay * m.backoffMultiplier
+		if delay >= float64(m.backoffMax) {
+			delay = float64(m.backoffMax)
+			break
+		}
+	}
+	if m.backoffJitter > 0 {
+		// Randomly reduce the delay by up to the jitter fraction
+		delay = delay - (delay * m.backoffJitter * m.jitterRand.Float64())
+	}
+	return time.Duration(delay)
+}
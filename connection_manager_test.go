@@ -0,0 +1,147 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+)
+
+func TestConnectionManagerReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error creating listener: %s", err)
+	}
+	defer listener.Close()
+	// Accept connections and drop the first one after the handshake
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			oServer, err := ouroboros.New(
+				ouroboros.WithConnection(conn),
+				ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+				ouroboros.WithServer(true),
+			)
+			if err != nil {
+				continue
+			}
+			// Discard server errors
+			go func() {
+				for range oServer.ErrorChan() {
+				}
+			}()
+			if i == 0 {
+				// Give the client time to finish the handshake
+				time.Sleep(100 * time.Millisecond)
+				oServer.Close()
+			}
+		}
+	}()
+	connectedChan := make(chan *ouroboros.Connection, 10)
+	disconnectedChan := make(chan error, 10)
+	manager := ouroboros.NewConnectionManager(
+		"tcp",
+		listener.Addr().String(),
+		ouroboros.WithConnectionOptions(
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		),
+		ouroboros.WithBackoff(10*time.Millisecond, 100*time.Millisecond, 2),
+		ouroboros.WithConnectedFunc(func(conn *ouroboros.Connection) error {
+			connectedChan <- conn
+			return nil
+		}),
+		ouroboros.WithDisconnectedFunc(func(conn *ouroboros.Connection, err error) {
+			disconnectedChan <- err
+		}),
+	)
+	manager.Start()
+	// Wait for the initial connection
+	select {
+	case <-connectedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not get expected initial connection")
+	}
+	// Wait for the server to drop the connection
+	select {
+	case err := <-disconnectedChan:
+		if err == nil {
+			t.Fatalf("did not get expected disconnect reason")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not get expected disconnect")
+	}
+	// Wait for the reconnect
+	select {
+	case <-connectedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not get expected reconnect")
+	}
+	if manager.Connection() == nil {
+		t.Fatalf("did not get expected current connection")
+	}
+	manager.Stop()
+	select {
+	case <-manager.DoneChan():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection manager did not stop")
+	}
+	if manager.Connection() != nil {
+		t.Fatalf("connection was not cleared after stop")
+	}
+}
+
+func TestConnectionManagerGiveUp(t *testing.T) {
+	// Find an address with nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error creating listener: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	giveUpChan := make(chan error, 1)
+	manager := ouroboros.NewConnectionManager(
+		"tcp",
+		address,
+		ouroboros.WithConnectionOptions(
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		),
+		ouroboros.WithBackoff(10*time.Millisecond, 100*time.Millisecond, 2),
+		ouroboros.WithMaxAttempts(3),
+		ouroboros.WithGiveUpFunc(func(err error) {
+			giveUpChan <- err
+		}),
+	)
+	manager.Start()
+	select {
+	case err := <-giveUpChan:
+		if err == nil {
+			t.Fatalf("did not get expected give up reason")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection manager did not give up")
+	}
+	select {
+	case <-manager.DoneChan():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection manager did not stop after giving up")
+	}
+}
@@ -17,10 +17,14 @@ package ouroboros
 import (
 	"net"
 
+	"github.com/blinklabs-io/gouroboros/logging"
+	"github.com/blinklabs-io/gouroboros/metrics"
+	"github.com/blinklabs-io/gouroboros/muxer"
 	"github.com/blinklabs-io/gouroboros/protocol/blockfetch"
 	"github.com/blinklabs-io/gouroboros/protocol/chainsync"
 	"github.com/blinklabs-io/gouroboros/protocol/keepalive"
 	"github.com/blinklabs-io/gouroboros/protocol/localstatequery"
+	"github.com/blinklabs-io/gouroboros/protocol/localtxmonitor"
 	"github.com/blinklabs-io/gouroboros/protocol/localtxsubmission"
 	"github.com/blinklabs-io/gouroboros/protocol/peersharing"
 	"github.com/blinklabs-io/gouroboros/protocol/txsubmission"
@@ -37,6 +41,15 @@ func WithConnection(conn net.Conn) ConnectionOptionFunc {
 	}
 }
 
+// WithBearer specifies an existing bearer to use, which allows running over transports other than a net.Conn.
+// See the bearer package for the available implementations. The handshake will be started when the Connection
+// is created
+func WithBearer(bearer muxer.Bearer) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.conn = bearer
+	}
+}
+
 // WithNetwork specifies the network
 func WithNetwork(network Network) ConnectionOptionFunc {
 	return func(c *Connection) {
@@ -103,6 +116,43 @@ func WithFullDuplex(fullDuplex bool) ConnectionOptionFunc {
 	}
 }
 
+// WithPeerSharing specifies whether we're willing to share peers. For NtN protocol versions 11 and up, this is sent
+// in the handshake, and the PeerSharing protocol is started if both sides are willing. This is disabled by default
+func WithPeerSharing(peerSharing bool) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.enablePeerSharing = peerSharing
+	}
+}
+
+// WithIngressLimit specifies the maximum number of bytes that can be queued for a mini-protocol before it
+// processes them. The connection is closed with a muxer.IngressLimitExceededError if the peer sends more than
+// that. A limit of 0 disables the limit. The NtN mini-protocols use the limits from the Ouroboros network
+// specification by default, and the NtC mini-protocols use muxer.DefaultIngressQueueLimit
+func WithIngressLimit(protocolId uint16, limit int) ConnectionOptionFunc {
+	return func(c *Connection) {
+		if c.ingressLimitOverrides == nil {
+			c.ingressLimitOverrides = make(map[uint16]int)
+		}
+		c.ingressLimitOverrides[protocolId] = limit
+	}
+}
+
+// WithSDUSize specifies the maximum payload size for the muxer segments that we send. Smaller segments let the
+// mini-protocols take turns sending more often. The default is muxer.DefaultSDUSize
+func WithSDUSize(sduSize int) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.sduSize = sduSize
+	}
+}
+
+// WithMuxerTap specifies a function to call with each muxer segment that is sent or received on the connection.
+// A capture.Writer can be used to record the traffic to a file
+func WithMuxerTap(tapFunc muxer.TapFunc) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.muxerTapFunc = tapFunc
+	}
+}
+
 // WithBlockFetchConfig specifies BlockFetch protocol config
 func WithBlockFetchConfig(cfg blockfetch.Config) ConnectionOptionFunc {
 	return func(c *Connection) {
@@ -138,6 +188,13 @@ func WithLocalTxSubmissionConfig(cfg localtxsubmission.Config) ConnectionOptionF
 	}
 }
 
+// WithLocalTxMonitorConfig specifies LocalTxMonitor protocol config
+func WithLocalTxMonitorConfig(cfg localtxmonitor.Config) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.localTxMonitorConfig = &cfg
+	}
+}
+
 // WithPeerSharingConfig specifies PeerSharing protocol config
 func WithPeerSharingConfig(cfg peersharing.Config) ConnectionOptionFunc {
 	return func(c *Connection) {
@@ -151,3 +208,38 @@ func WithTxSubmissionConfig(cfg txsubmission.Config) ConnectionOptionFunc {
 		c.txSubmissionConfig = &cfg
 	}
 }
+
+// WithLogger specifies the logger to use for the connection. It is passed down to the muxer and all mini-protocols,
+// and log events include the remote address of the connection. No logging is done by default
+func WithLogger(logger logging.Logger) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.logger = logger
+	}
+}
+
+// WithMetrics specifies the metrics provider to use for the connection. It is passed down to the muxer and all
+// mini-protocols. All metrics for the connection have a "peer" label, which is the remote address unless
+// WithMetricsPeer is used. No metrics are collected by default
+func WithMetrics(provider metrics.Provider) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.metrics = provider
+	}
+}
+
+// WithMetricsPeer specifies the value of the "peer" label for the connection's metrics. Metrics are kept for
+// each distinct value, so a stable name such as the peer's host is useful when many short-lived connections
+// share a metrics provider. Without it, the metrics for the remote address are removed when the connection is
+// closed, if the metrics provider supports it
+func WithMetricsPeer(peer string) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.metricsPeer = peer
+	}
+}
+
+// WithConnectionEventFunc registers a function to be called for connection lifecycle events. This can be
+// specified more than once to register multiple functions
+func WithConnectionEventFunc(eventFunc ConnectionEventFunc) ConnectionOptionFunc {
+	return func(c *Connection) {
+		c.eventFuncs = append(c.eventFuncs, eventFunc)
+	}
+}
@@ -15,11 +15,23 @@
 package ouroboros_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	ouroboros "github.com/blinklabs-io/gouroboros"
 	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/metrics"
+	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/handshake"
 )
 
 // Ensure that we don't panic when closing the Connection object after a failed Dial() call
@@ -69,3 +81,550 @@ func TestDoubleClose(t *testing.T) {
 		t.Fatalf("unexpected error when closing Connection object again: %s", err)
 	}
 }
+
+// Ensure that a stalled handshake can be aborted with a context
+func TestNewConnectionContextCancel(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	// Consume the handshake request but never respond
+	go func() {
+		_, _ = io.Copy(io.Discard, serverConn)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ouroboros.NewConnectionContext(
+		ctx,
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("did not get expected error: got %v, wanted %s", err, context.DeadlineExceeded)
+	}
+	// The underlying connection should have been closed
+	if _, err := clientConn.Write([]byte{0}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("did not get expected error writing to closed connection: got %v", err)
+	}
+}
+
+// cancelOnReadConn cancels a context once data has been read from the connection
+type cancelOnReadConn struct {
+	net.Conn
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnReadConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestNewConnectionContextCancelRace(t *testing.T) {
+	// Cancel the context as the handshake response arrives, so that the handshake completing and the close from
+	// the cancellation race with each other. Neither should panic
+	for i := 0; i < 100; i++ {
+		clientConn, serverConn := net.Pipe()
+		serverResultChan := make(chan *ouroboros.Connection, 1)
+		go func() {
+			// The server handshake fails if the client gives up first
+			oServer, _ := ouroboros.New(
+				ouroboros.WithConnection(serverConn),
+				ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+				ouroboros.WithServer(true),
+			)
+			serverResultChan <- oServer
+		}()
+		ctx, cancel := context.WithCancel(context.Background())
+		oClient, err := ouroboros.NewConnectionContext(
+			ctx,
+			ouroboros.WithConnection(&cancelOnReadConn{Conn: clientConn, cancel: cancel}),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		)
+		cancel()
+		if err == nil {
+			if oClient.VersionData() == nil {
+				t.Fatalf("did not get version data for completed handshake")
+			}
+			oClient.Close()
+		} else if !errors.Is(err, context.Canceled) {
+			t.Fatalf("did not get expected error: got %v, wanted %s", err, context.Canceled)
+		}
+		clientConn.Close()
+		if oServer := <-serverResultChan; oServer != nil {
+			oServer.Close()
+		}
+	}
+}
+
+// testLogEvent is a log event captured by testLogger
+type testLogEvent struct {
+	level string
+	msg   string
+	args  map[interface{}]interface{}
+	keys  []interface{}
+}
+
+// testLogger records all log events
+type testLogger struct {
+	sync.Mutex
+	events []testLogEvent
+}
+
+func (l *testLogger) log(level string, msg string, args []interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	event := testLogEvent{
+		level: level,
+		msg:   msg,
+		args:  make(map[interface{}]interface{}),
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		event.args[args[i]] = args[i+1]
+		event.keys = append(event.keys, args[i])
+	}
+	l.events = append(l.events, event)
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+// find returns the first recorded event with the specified message and protocol, if any
+func (l *testLogger) find(msg string, protocol string) *testLogEvent {
+	l.Lock()
+	defer l.Unlock()
+	for _, event := range l.events {
+		if event.msg != msg {
+			continue
+		}
+		if protocol != "" && event.args["protocol"] != protocol {
+			continue
+		}
+		return &event
+	}
+	return nil
+}
+
+func TestConnectionLogger(t *testing.T) {
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	logger := &testLogger{}
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Connection object: %s", err)
+	}
+	event := logger.find("handshake complete", "")
+	if event == nil {
+		t.Fatalf("did not find handshake complete log event")
+	}
+	if event.level != "info" || event.args["peer"] != mockConn.RemoteAddr().String() {
+		t.Fatalf("did not get expected handshake complete log event: %#v", event)
+	}
+	event = logger.find("received message", "handshake")
+	if event == nil {
+		t.Fatalf("did not find received message log event for handshake protocol")
+	}
+	if event.args["message_type"] != "MsgAcceptVersion" || event.args["state"] == nil {
+		t.Fatalf("did not get expected received message log event: %#v", event)
+	}
+	event = logger.find("sent segment", "")
+	if event == nil {
+		t.Fatalf("did not find sent segment log event from muxer")
+	}
+	// The muxer shouldn't add the peer again, since the connection's logger already has it
+	peerCount := 0
+	for _, key := range event.keys {
+		if key == "peer" {
+			peerCount++
+		}
+	}
+	if peerCount != 1 {
+		t.Fatalf("did not get expected peer in sent segment log event: %#v", event.keys)
+	}
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	registry := metrics.NewPrometheusRegistry()
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithMetrics(registry),
+		ouroboros.WithMetricsPeer("relay-1"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Connection object: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing metrics: %s", err)
+	}
+	for _, expected := range []string{
+		`ouroboros_muxer_segments_total{peer="relay-1",protocol_id="0",direction="sent"} 1`,
+		`ouroboros_muxer_segments_total{peer="relay-1",protocol_id="0",direction="received"} 1`,
+		`ouroboros_protocol_messages_total{peer="relay-1",protocol="handshake",role="client",direction="sent",message_type="MsgProposeVersions"} 1`,
+		`ouroboros_protocol_messages_total{peer="relay-1",protocol="handshake",role="client",direction="received",message_type="MsgAcceptVersion"} 1`,
+		`ouroboros_protocol_state_duration_seconds_count{peer="relay-1",protocol="handshake",role="client",state="Propose"} 1`,
+		`ouroboros_protocol_send_queue_depth{peer="relay-1",protocol="handshake",role="client"} 0`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("did not find expected metric %q in output:\n%s", expected, buf.String())
+		}
+	}
+}
+
+// The metrics for a connection without a peer label set are removed when it's closed
+func TestConnectionMetricsDeletedOnClose(t *testing.T) {
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	registry := metrics.NewPrometheusRegistry()
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithMetrics(registry),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	peerLabel := fmt.Sprintf("peer=%q", mockConn.RemoteAddr().String())
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing metrics: %s", err)
+	}
+	if !strings.Contains(buf.String(), peerLabel) {
+		t.Fatalf("did not find metrics with %s in output:\n%s", peerLabel, buf.String())
+	}
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Connection object: %s", err)
+	}
+	buf.Reset()
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("unexpected error writing metrics: %s", err)
+	}
+	if strings.Contains(buf.String(), peerLabel) {
+		t.Fatalf("found metrics with %s after close in output:\n%s", peerLabel, buf.String())
+	}
+}
+
+// Our server supports a version with the query flag, so it replies with all of its supported versions
+func TestQueryVersions(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		oServer, err := ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+		)
+		if err != nil {
+			return
+		}
+		// Wait for the client to hang up
+		<-oServer.ErrorChan()
+		oServer.Close()
+	}()
+	versions, err := ouroboros.QueryVersions(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	serverVersions := ouroboros.GetProtocolVersionsNtC()
+	if len(versions) != len(serverVersions) {
+		t.Fatalf("did not get expected versions: %#v", versions)
+	}
+	for _, serverVersion := range serverVersions {
+		versionData, ok := versions[serverVersion&^0x8000]
+		if !ok {
+			t.Fatalf("did not find expected version %d in result: %#v", serverVersion&^0x8000, versions)
+		}
+		if versionData.NetworkMagic() != ouroboros_mock.MockNetworkMagic {
+			t.Fatalf("did not get expected version data: %#v", versionData)
+		}
+	}
+}
+
+func TestConnectionProtocolVersion(t *testing.T) {
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	defer oConn.Close()
+	if oConn.ProtocolVersion() != ouroboros_mock.MockProtocolVersionNtC {
+		t.Fatalf("did not get expected protocol version: got %d, wanted %d", oConn.ProtocolVersion(), ouroboros_mock.MockProtocolVersionNtC)
+	}
+	if oConn.ProtocolVersionNtN() != nil {
+		t.Fatalf("did not expect NtN protocol features for NtC connection")
+	}
+	versionNtC := oConn.ProtocolVersionNtC()
+	if versionNtC == nil || !versionNtC.EnableLocalTxMonitorProtocol {
+		t.Fatalf("did not get expected NtC protocol features: %#v", versionNtC)
+	}
+	if oConn.FullDuplex() {
+		t.Fatalf("did not expect full duplex for NtC connection")
+	}
+}
+
+func TestPeerSharingNegotiation(t *testing.T) {
+	testDefs := []struct {
+		name              string
+		enablePeerSharing bool
+		// Hand-built AcceptVersion messages using the mock network magic (999999)
+		acceptVersionHex  string
+		expectPeerSharing bool
+	}{
+		{
+			name:              "v13 peer sharing enabled",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010d841a000f423ff401f4",
+			expectPeerSharing: true,
+		},
+		{
+			name:              "v11 peer sharing private",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010b841a000f423ff401f4",
+			expectPeerSharing: true,
+		},
+		{
+			name:              "v12 peer sharing public",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010c841a000f423ff402f4",
+			expectPeerSharing: true,
+		},
+		{
+			name:              "v12 peer sharing private",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010c841a000f423ff401f4",
+			expectPeerSharing: true,
+		},
+		{
+			name:              "v12 peer sharing disabled by peer",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010c841a000f423ff400f4",
+		},
+		{
+			name:              "v13 peer sharing disabled by peer",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010d841a000f423ff400f4",
+		},
+		{
+			name:             "v13 peer sharing disabled locally",
+			acceptVersionHex: "83010d841a000f423ff401f4",
+		},
+		{
+			name:              "v10 without peer sharing",
+			enablePeerSharing: true,
+			acceptVersionHex:  "83010a821a000f423ff4",
+		},
+	}
+	for _, testDef := range testDefs {
+		t.Run(testDef.name, func(t *testing.T) {
+			cborData, err := hex.DecodeString(testDef.acceptVersionHex)
+			if err != nil {
+				t.Fatalf("failed to decode CBOR hex: %s", err)
+			}
+			acceptVersion, err := handshake.NewMsgFromCbor(handshake.MessageTypeAcceptVersion, cborData)
+			if err != nil {
+				t.Fatalf("failed to decode message: %s", err)
+			}
+			mockConn := ouroboros_mock.NewConnection(
+				ouroboros_mock.ProtocolRoleClient,
+				[]ouroboros_mock.ConversationEntry{
+					ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+					{
+						Type:           ouroboros_mock.EntryTypeOutput,
+						ProtocolId:     handshake.ProtocolId,
+						IsResponse:     true,
+						OutputMessages: []protocol.Message{acceptVersion},
+					},
+				},
+			)
+			oConn, err := ouroboros.New(
+				ouroboros.WithConnection(mockConn),
+				ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+				ouroboros.WithNodeToNode(true),
+				ouroboros.WithPeerSharing(testDef.enablePeerSharing),
+				ouroboros.WithDelayProtocolStart(true),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error when creating Connection object: %s", err)
+			}
+			defer oConn.Close()
+			if (oConn.PeerSharing() != nil) != testDef.expectPeerSharing {
+				t.Fatalf("did not get expected peer sharing state: got %v, wanted %v", oConn.PeerSharing() != nil, testDef.expectPeerSharing)
+			}
+		})
+	}
+}
+
+func TestPeerSharingNegotiationServer(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	serverResultChan := make(chan *ouroboros.Connection, 1)
+	go func() {
+		oServer, err := ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+			ouroboros.WithServer(true),
+			ouroboros.WithPeerSharing(true),
+		)
+		if err != nil {
+			serverResultChan <- nil
+			return
+		}
+		serverResultChan <- oServer
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithNodeToNode(true),
+		ouroboros.WithPeerSharing(true),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	defer oClient.Close()
+	oServer := <-serverResultChan
+	if oServer == nil {
+		t.Fatalf("unexpected error when creating server Connection object")
+	}
+	defer oServer.Close()
+	if oClient.ProtocolVersion() != 13 || oServer.ProtocolVersion() != 13 {
+		t.Fatalf("did not get expected protocol versions: client %d, server %d", oClient.ProtocolVersion(), oServer.ProtocolVersion())
+	}
+	if oClient.PeerSharing() == nil || oServer.PeerSharing() == nil {
+		t.Fatalf("did not get expected peer sharing protocol on both sides")
+	}
+}
+
+func TestCloseGracefully(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	registry := metrics.NewPrometheusRegistry()
+	serverResultChan := make(chan *ouroboros.Connection, 1)
+	go func() {
+		oServer, err := ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+			ouroboros.WithMetrics(registry),
+			// The metrics are checked after the server sees the client hang up, so they need to be kept
+			ouroboros.WithMetricsPeer("client"),
+		)
+		if err != nil {
+			serverResultChan <- nil
+			return
+		}
+		serverResultChan <- oServer
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	oServer := <-serverResultChan
+	if oServer == nil {
+		t.Fatalf("unexpected error when creating server Connection object")
+	}
+	defer oServer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := oClient.CloseGracefully(ctx); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	// The server should have received a Done message for each mini-protocol
+	expected := []string{}
+	for _, protocolName := range []string{"chain-sync", "local-tx-submission", "local-state-query", "local-tx-monitor"} {
+		expected = append(
+			expected,
+			fmt.Sprintf(`ouroboros_protocol_messages_total{peer="client",protocol="%s",role="server",direction="received",message_type="MsgDone"} 1`, protocolName),
+		)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var buf bytes.Buffer
+		if err := registry.Write(&buf); err != nil {
+			t.Fatalf("unexpected error writing metrics: %s", err)
+		}
+		missing := ""
+		for _, line := range expected {
+			if !strings.Contains(buf.String(), line) {
+				missing = line
+				break
+			}
+		}
+		if missing == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("did not find expected metric %q in output:\n%s", missing, buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseGracefullyDeadline(t *testing.T) {
+	// The server never responds to the Done messages, since it doesn't read anything after the handshake
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			ouroboros_mock.ConversationEntryHandshakeResponse,
+		},
+	)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	// Put the chain-sync client in a state where the server has agency, so that the Done message can't be sent
+	go func() {
+		_, _ = oConn.ChainSync().Client.GetCurrentTip()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := oConn.CloseGracefully(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/fxamacker/cbor/v2 v2.4.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/jinzhu/copier v0.3.5
 	golang.org/x/crypto v0.10.0
 )
@@ -0,0 +1,51 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros
+
+import (
+	"github.com/blinklabs-io/gouroboros/protocol/blockfetch"
+	"github.com/blinklabs-io/gouroboros/protocol/chainsync"
+	"github.com/blinklabs-io/gouroboros/protocol/handshake"
+	"github.com/blinklabs-io/gouroboros/protocol/keepalive"
+	"github.com/blinklabs-io/gouroboros/protocol/peersharing"
+	"github.com/blinklabs-io/gouroboros/protocol/txsubmission"
+)
+
+// Default ingress limits for the NtN mini-protocols, in bytes, from the Ouroboros network specification. The
+// NtC mini-protocols have no message size limits by default, since their messages can be very large, but the
+// data queued for each of them is still limited to muxer.DefaultIngressQueueLimit
+var defaultIngressLimitsNtN = map[uint16]int{
+	handshake.ProtocolId:     5760,
+	chainsync.ProtocolIdNtN:  462000,
+	blockfetch.PROTOCOL_ID:   230686940,
+	txsubmission.PROTOCOL_ID: 721424,
+	keepalive.PROTOCOL_ID:    1408,
+	peersharing.ProtocolId:   5760,
+}
+
+// ingressLimits returns the ingress limits for the connection, which are the defaults with any limits provided
+// with WithIngressLimit applied on top
+func (c *Connection) ingressLimits() map[uint16]int {
+	ret := make(map[uint16]int)
+	if c.useNodeToNodeProto {
+		for protocolId, limit := range defaultIngressLimitsNtN {
+			ret[protocolId] = limit
+		}
+	}
+	for protocolId, limit := range c.ingressLimitOverrides {
+		ret[protocolId] = limit
+	}
+	return ret
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros_mock
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/blinklabs-io/gouroboros/capture"
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/muxer"
+	"github.com/blinklabs-io/gouroboros/protocol"
+)
+
+// ConversationFromCapture converts the records from a capture into a conversation for a mock connection that
+// takes the place of the peer. Outbound messages become input entries that check the message type, and inbound
+// segments become output entries that send the captured payload as is. Outbound payload is buffered per
+// protocol, so that there's one input entry for each complete message, even when a segment contains several
+// messages or a message spans segments
+func ConversationFromCapture(records []capture.Record) ([]ConversationEntry, error) {
+	var conversation []ConversationEntry
+	// Outbound data that doesn't form a complete message yet, by protocol ID including the response flag
+	partialMessages := make(map[uint16]*bytes.Buffer)
+	for idx, record := range records {
+		segment := record.Segment
+		switch record.Direction {
+		case muxer.TapDirectionInbound:
+			msg := &protocol.MessageBase{}
+			msg.SetCbor(segment.Payload)
+			conversation = append(
+				conversation,
+				ConversationEntry{
+					Type:           EntryTypeOutput,
+					ProtocolId:     segment.GetProtocolId(),
+					IsResponse:     segment.IsResponse(),
+					OutputMessages: []protocol.Message{msg},
+				},
+			)
+		case muxer.TapDirectionOutbound:
+			buf, ok := partialMessages[segment.ProtocolId]
+			if !ok {
+				buf = bytes.NewBuffer(nil)
+				partialMessages[segment.ProtocolId] = buf
+			}
+			buf.Write(segment.Payload)
+			for buf.Len() > 0 {
+				msgLen, err := cbor.ItemLength(buf.Bytes())
+				if err != nil {
+					if err == io.ErrUnexpectedEOF {
+						// Wait for the rest of the message in a later segment
+						break
+					}
+					return nil, fmt.Errorf("record %d: failed to decode message: %w", idx, err)
+				}
+				msgType, err := cbor.DecodeIdFromList(buf.Bytes()[:msgLen])
+				if err != nil {
+					return nil, fmt.Errorf("record %d: failed to decode message type: %w", idx, err)
+				}
+				conversation = append(
+					conversation,
+					ConversationEntry{
+						Type:             EntryTypeInput,
+						ProtocolId:       segment.GetProtocolId(),
+						IsResponse:       segment.IsResponse(),
+						InputMessageType: uint(msgType),
+					},
+				)
+				buf.Next(msgLen)
+			}
+		default:
+			return nil, fmt.Errorf("record %d: invalid direction: %d", idx, record.Direction)
+		}
+	}
+	for protocolId, buf := range partialMessages {
+		if buf.Len() > 0 {
+			return nil, fmt.Errorf("capture ends with a partial outbound message for segment protocol ID %#04x", protocolId)
+		}
+	}
+	return conversation, nil
+}
@@ -17,6 +17,7 @@ package ouroboros_mock
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"time"
@@ -42,6 +43,9 @@ type Connection struct {
 	conversation  []ConversationEntry
 	muxer         *muxer.Muxer
 	muxerRecvChan chan *muxer.Segment
+	// Segment with messages that have not been matched to an input entry yet
+	pendingSegment *muxer.Segment
+	pendingPayload []byte
 }
 
 // NewConnection returns a new Connection with the provided conversation entries
@@ -132,7 +136,9 @@ func (c *Connection) asyncLoop() {
 				panic(fmt.Sprintf("output error: %s", err))
 			}
 		case EntryTypeClose:
-			c.Close()
+			// Only close our side, so that the client sees the peer closing the connection rather than a
+			// local close
+			c.muxer.Stop()
 		default:
 			panic(fmt.Sprintf("unknown conversation entry type: %d: %#v", entry.Type, entry))
 		}
@@ -140,11 +146,40 @@ func (c *Connection) asyncLoop() {
 }
 
 func (c *Connection) processInputEntry(entry ConversationEntry) error {
-	// Wait for segment to be received from muxer
-	segment, ok := <-c.muxerRecvChan
-	if !ok {
-		return nil
+	// Wait for segment to be received from muxer, unless there are messages left from the previous segment
+	if len(c.pendingPayload) == 0 {
+		segment, ok := <-c.muxerRecvChan
+		if !ok {
+			return nil
+		}
+		c.pendingSegment = segment
+		c.pendingPayload = segment.Payload
+	}
+	segment := c.pendingSegment
+	// The sender may batch several messages into a single segment or split a large message across segments
+	var msgLen int
+	for {
+		var err error
+		msgLen, err = cbor.ItemLength(c.pendingPayload)
+		if err == nil {
+			break
+		}
+		if err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("decode error: %s", err)
+		}
+		nextSegment, ok := <-c.muxerRecvChan
+		if !ok {
+			return nil
+		}
+		if nextSegment.ProtocolId != segment.ProtocolId {
+			return fmt.Errorf("received segment for protocol ID %d in the middle of a message for protocol ID %d", nextSegment.GetProtocolId(), segment.GetProtocolId())
+		}
+		tmpPayload := make([]byte, 0, len(c.pendingPayload)+len(nextSegment.Payload))
+		tmpPayload = append(tmpPayload, c.pendingPayload...)
+		c.pendingPayload = append(tmpPayload, nextSegment.Payload...)
 	}
+	payload := c.pendingPayload[:msgLen]
+	c.pendingPayload = c.pendingPayload[msgLen:]
 	if segment.GetProtocolId() != entry.ProtocolId {
 		return fmt.Errorf("input message protocol ID did not match expected value: expected %d, got %d", entry.ProtocolId, segment.GetProtocolId())
 	}
@@ -152,13 +187,13 @@ func (c *Connection) processInputEntry(entry ConversationEntry) error {
 		return fmt.Errorf("input message response flag did not match expected value: expected %v, got %v", entry.IsResponse, segment.IsResponse())
 	}
 	// Determine message type
-	msgType, err := cbor.DecodeIdFromList(segment.Payload)
+	msgType, err := cbor.DecodeIdFromList(payload)
 	if err != nil {
 		return fmt.Errorf("decode error: %s", err)
 	}
 	if entry.InputMessage != nil {
 		// Create Message object from CBOR
-		msg, err := entry.MsgFromCborFunc(uint(msgType), segment.Payload)
+		msg, err := entry.MsgFromCborFunc(uint(msgType), payload)
 		if err != nil {
 			return fmt.Errorf("message from CBOR error: %s", err)
 		}
@@ -22,6 +22,7 @@ import (
 const (
 	MockNetworkMagic       uint32 = 999999
 	MockProtocolVersionNtC uint16 = 14
+	MockProtocolVersionNtN uint16 = 10
 )
 
 type EntryType int
@@ -60,3 +61,28 @@ var ConversationEntryHandshakeResponse = ConversationEntry{
 		handshake.NewMsgAcceptVersion(MockProtocolVersionNtC, MockNetworkMagic),
 	},
 }
+
+// ConversationEntryHandshakeNtNProposeVersions is a pre-defined conversation entry for a client NtN handshake request
+var ConversationEntryHandshakeNtNProposeVersions = ConversationEntry{
+	Type:       EntryTypeOutput,
+	ProtocolId: handshake.ProtocolId,
+	OutputMessages: []protocol.Message{
+		handshake.NewMsgProposeVersions(
+			map[uint16]interface{}{
+				MockProtocolVersionNtN: handshake.VersionDataNtN7to10{
+					CborNetworkMagic:               MockNetworkMagic,
+					CborInitiatorOnlyDiffusionMode: handshake.DiffusionModeInitiatorOnly,
+				},
+			},
+		),
+	},
+}
+
+// ConversationEntryHandshakeAcceptVersionGeneric is a pre-defined conversation entry that matches a generic
+// handshake AcceptVersion response from a server
+var ConversationEntryHandshakeAcceptVersionGeneric = ConversationEntry{
+	Type:             EntryTypeInput,
+	ProtocolId:       handshake.ProtocolId,
+	IsResponse:       true,
+	InputMessageType: handshake.MessageTypeAcceptVersion,
+}
@@ -18,6 +18,10 @@ import (
 	"testing"
 
 	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/capture"
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/muxer"
+	"github.com/blinklabs-io/gouroboros/protocol/handshake"
 )
 
 // Basic test of conversation mock functionality
@@ -41,3 +45,96 @@ func TestBasic(t *testing.T) {
 		t.Fatalf("unexpected error when closing Ouroboros object: %s", err)
 	}
 }
+
+func TestConversationFromCapture(t *testing.T) {
+	proposeVersions, err := cbor.Encode(
+		handshake.NewMsgProposeVersions(
+			map[uint16]interface{}{
+				0x8000 + MockProtocolVersionNtC: MockNetworkMagic,
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	acceptVersion, err := cbor.Encode(
+		handshake.NewMsgAcceptVersion(MockProtocolVersionNtC, MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	records := []capture.Record{
+		{
+			Direction: muxer.TapDirectionOutbound,
+			Segment:   muxer.NewSegment(handshake.ProtocolId, proposeVersions, false),
+		},
+		{
+			Direction: muxer.TapDirectionInbound,
+			Segment:   muxer.NewSegment(handshake.ProtocolId, acceptVersion, true),
+		},
+	}
+	conversation, err := ConversationFromCapture(records)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conversation) != 2 ||
+		conversation[0].Type != EntryTypeInput ||
+		conversation[0].InputMessageType != handshake.MessageTypeProposeVersions ||
+		conversation[1].Type != EntryTypeOutput {
+		t.Fatalf("did not get expected conversation: %#v", conversation)
+	}
+	mockConn := NewConnection(ProtocolRoleClient, conversation)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Ouroboros object: %s", err)
+	}
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Ouroboros object: %s", err)
+	}
+	// A message that spans segments results in a single input entry
+	records = []capture.Record{
+		{
+			Direction: muxer.TapDirectionOutbound,
+			Segment:   muxer.NewSegment(handshake.ProtocolId, proposeVersions[:2], false),
+		},
+		{
+			Direction: muxer.TapDirectionOutbound,
+			Segment:   muxer.NewSegment(handshake.ProtocolId, proposeVersions[2:], false),
+		},
+	}
+	conversation, err = ConversationFromCapture(records)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conversation) != 1 || conversation[0].InputMessageType != handshake.MessageTypeProposeVersions {
+		t.Fatalf("did not get expected conversation: %#v", conversation)
+	}
+	// A segment with several messages results in an input entry for each message
+	batchedPayload := append(append([]byte{}, proposeVersions...), proposeVersions...)
+	records = []capture.Record{
+		{
+			Direction: muxer.TapDirectionOutbound,
+			Segment:   muxer.NewSegment(handshake.ProtocolId, batchedPayload, false),
+		},
+	}
+	conversation, err = ConversationFromCapture(records)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conversation) != 2 {
+		t.Fatalf("did not get expected conversation: %#v", conversation)
+	}
+	// A capture that ends partway through a message can't be replayed
+	records = []capture.Record{
+		{
+			Direction: muxer.TapDirectionOutbound,
+			Segment:   muxer.NewSegment(handshake.ProtocolId, proposeVersions[:2], false),
+		},
+	}
+	if _, err := ConversationFromCapture(records); err == nil {
+		t.Fatalf("did not get expected error for partial message")
+	}
+}
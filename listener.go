@@ -0,0 +1,292 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Default time allowed for connections to close gracefully when the Listener is closed
+	defaultListenerCloseTimeout = 5 * time.Second
+	// Limits for the delay between retries after a temporary Accept error
+	listenerAcceptMinDelay = 5 * time.Millisecond
+	listenerAcceptMaxDelay = 1 * time.Second
+)
+
+// Listener accepts inbound connections and performs the server side of the Ouroboros handshake for each of them
+type Listener struct {
+	listener        net.Listener
+	connOptions     []ConnectionOptionFunc
+	connOptionsFunc ListenerConnectionOptionsFunc
+	maxConnections  int
+	closeTimeout    time.Duration
+	connectionFunc  ListenerConnectionFunc
+	errorFunc       ListenerErrorFunc
+	connections     map[*Connection]struct{}
+	connSlotChan    chan struct{}
+	mutex           sync.Mutex
+	waitGroup       sync.WaitGroup
+	doneChan        chan interface{}
+	ctx             context.Context
+	cancelFunc      context.CancelFunc
+	onceClose       sync.Once
+}
+
+// Callback function types
+type ListenerConnectionOptionsFunc func(net.Conn) []ConnectionOptionFunc
+type ListenerConnectionFunc func(*Connection) error
+type ListenerErrorFunc func(*Connection, error)
+
+// ListenerOptionFunc is a type that represents functions that modify the Listener config
+type ListenerOptionFunc func(*Listener)
+
+// NewListener returns a new Listener that accepts connections from the provided [net.Listener]
+func NewListener(listener net.Listener, options ...ListenerOptionFunc) *Listener {
+	l := &Listener{
+		listener:     listener,
+		closeTimeout: defaultListenerCloseTimeout,
+		connections:  make(map[*Connection]struct{}),
+		doneChan:     make(chan interface{}),
+	}
+	l.ctx, l.cancelFunc = context.WithCancel(context.Background())
+	// Apply provided options functions
+	for _, option := range options {
+		option(l)
+	}
+	if l.maxConnections > 0 {
+		l.connSlotChan = make(chan struct{}, l.maxConnections)
+	}
+	return l
+}
+
+// Listen creates a Listener for the specified protocol ("tcp" or "unix") and address. These parameters are passed
+// to the [net.Listen] func
+func Listen(proto string, address string, options ...ListenerOptionFunc) (*Listener, error) {
+	listener, err := net.Listen(proto, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(listener, options...), nil
+}
+
+// WithListenerConnectionOptions specifies the options to use for every accepted Connection. WithServer(true) and
+// WithConnection() are always added. WithErrorChan should not be used, since the Listener consumes each
+// Connection's errors
+func WithListenerConnectionOptions(options ...ConnectionOptionFunc) ListenerOptionFunc {
+	return func(l *Listener) {
+		l.connOptions = options
+	}
+}
+
+// WithListenerConnectionOptionsFunc specifies a function that returns additional options for each accepted
+// connection. These are applied after any options from WithListenerConnectionOptions
+func WithListenerConnectionOptionsFunc(connOptionsFunc ListenerConnectionOptionsFunc) ListenerOptionFunc {
+	return func(l *Listener) {
+		l.connOptionsFunc = connOptionsFunc
+	}
+}
+
+// WithListenerMaxConnections specifies the maximum number of concurrent connections. New connections are not
+// accepted while at the limit. The default of 0 means no limit
+func WithListenerMaxConnections(maxConnections int) ListenerOptionFunc {
+	return func(l *Listener) {
+		l.maxConnections = maxConnections
+	}
+}
+
+// WithListenerCloseTimeout specifies how long Close waits for active connections to close gracefully before
+// closing them immediately. The default is 5 seconds
+func WithListenerCloseTimeout(timeout time.Duration) ListenerOptionFunc {
+	return func(l *Listener) {
+		l.closeTimeout = timeout
+	}
+}
+
+// WithListenerConnectionFunc specifies a callback function that is called for each Connection after a successful
+// handshake. Returning an error closes the Connection
+func WithListenerConnectionFunc(connectionFunc ListenerConnectionFunc) ListenerOptionFunc {
+	return func(l *Listener) {
+		l.connectionFunc = connectionFunc
+	}
+}
+
+// WithListenerErrorFunc specifies a callback function that is called for connection errors. The Connection
+// is nil if the error occurred during the handshake
+func WithListenerErrorFunc(errorFunc ListenerErrorFunc) ListenerOptionFunc {
+	return func(l *Listener) {
+		l.errorFunc = errorFunc
+	}
+}
+
+// Addr returns the address of the underlying [net.Listener]
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Connections returns the currently active connections
+func (l *Listener) Connections() []*Connection {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ret := make([]*Connection, 0, len(l.connections))
+	for conn := range l.connections {
+		ret = append(ret, conn)
+	}
+	return ret
+}
+
+// Serve accepts connections until the Listener is closed. Like [net/http.Server], it retries with an increasing
+// delay after temporary Accept errors. It always returns a non-nil error, which is [net.ErrClosed] after Close
+// is called
+func (l *Listener) Serve() error {
+	var retryDelay time.Duration
+	for {
+		// Wait for a free connection slot
+		if l.connSlotChan != nil {
+			select {
+			case <-l.doneChan:
+				return net.ErrClosed
+			case l.connSlotChan <- struct{}{}:
+			}
+		}
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.releaseSlot()
+			select {
+			case <-l.doneChan:
+				return net.ErrClosed
+			default:
+			}
+			var netErr net.Error
+			// Temporary() is deprecated, but it's still how Accept reports errors such as running out of file descriptors
+			if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+				if retryDelay == 0 {
+					retryDelay = listenerAcceptMinDelay
+				} else {
+					retryDelay *= 2
+				}
+				if retryDelay > listenerAcceptMaxDelay {
+					retryDelay = listenerAcceptMaxDelay
+				}
+				select {
+				case <-l.doneChan:
+					return net.ErrClosed
+				case <-time.After(retryDelay):
+				}
+				continue
+			}
+			return err
+		}
+		retryDelay = 0
+		l.waitGroup.Add(1)
+		go l.handleConnection(conn)
+	}
+}
+
+// Close stops accepting connections, closes all active connections gracefully, and waits for them to shut
+// down. Connections that don't close within the close timeout are closed immediately
+func (l *Listener) Close() error {
+	var err error
+	l.onceClose.Do(func() {
+		close(l.doneChan)
+		// Abort any handshakes in progress
+		l.cancelFunc()
+		err = l.listener.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), l.closeTimeout)
+		defer cancel()
+		var closeWaitGroup sync.WaitGroup
+		for _, conn := range l.Connections() {
+			closeWaitGroup.Add(1)
+			go func(conn *Connection) {
+				defer closeWaitGroup.Done()
+				_ = conn.CloseGracefully(ctx)
+			}(conn)
+		}
+		closeWaitGroup.Wait()
+		l.waitGroup.Wait()
+	})
+	return err
+}
+
+func (l *Listener) handleConnection(netConn net.Conn) {
+	defer l.waitGroup.Done()
+	defer l.releaseSlot()
+	options := append([]ConnectionOptionFunc{}, l.connOptions...)
+	if l.connOptionsFunc != nil {
+		options = append(options, l.connOptionsFunc(netConn)...)
+	}
+	options = append(
+		options,
+		WithConnection(netConn),
+		WithServer(true),
+	)
+	conn, err := NewConnectionContext(l.ctx, options...)
+	if err != nil {
+		_ = netConn.Close()
+		l.handleError(nil, fmt.Errorf("handshake failed: %w", err))
+		return
+	}
+	// Track the connection, unless we're shutting down
+	l.mutex.Lock()
+	select {
+	case <-l.doneChan:
+		l.mutex.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	l.connections[conn] = struct{}{}
+	l.mutex.Unlock()
+	defer func() {
+		l.mutex.Lock()
+		delete(l.connections, conn)
+		l.mutex.Unlock()
+	}()
+	if l.connectionFunc != nil {
+		if err := l.connectionFunc(conn); err != nil {
+			conn.Close()
+			l.handleError(conn, err)
+			return
+		}
+	}
+	// Wait for the connection to fail or be closed
+	if err, ok := <-conn.ErrorChan(); ok {
+		conn.Close()
+		l.handleError(conn, err)
+	}
+}
+
+func (l *Listener) handleError(conn *Connection, err error) {
+	// Don't report errors caused by shutting down
+	select {
+	case <-l.doneChan:
+		return
+	default:
+	}
+	if l.errorFunc != nil {
+		l.errorFunc(conn, err)
+	}
+}
+
+func (l *Listener) releaseSlot() {
+	if l.connSlotChan != nil {
+		<-l.connSlotChan
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+)
+
+func TestListener(t *testing.T) {
+	connectedChan := make(chan *ouroboros.Connection, 10)
+	listener, err := ouroboros.Listen(
+		"tcp",
+		"127.0.0.1:0",
+		ouroboros.WithListenerConnectionOptions(
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+		),
+		ouroboros.WithListenerMaxConnections(1),
+		ouroboros.WithListenerConnectionFunc(func(conn *ouroboros.Connection) error {
+			connectedChan <- conn
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating listener: %s", err)
+	}
+	serveResultChan := make(chan error)
+	go func() {
+		serveResultChan <- listener.Serve()
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithNodeToNode(true),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := oClient.Dial("tcp", listener.Addr().String()); err != nil {
+		t.Fatalf("unexpected error when connecting: %s", err)
+	}
+	select {
+	case <-connectedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not get expected server connection")
+	}
+	if len(listener.Connections()) != 1 {
+		t.Fatalf("did not get expected number of connections: %d", len(listener.Connections()))
+	}
+	// A second connection should not be handled while at the connection limit
+	secondConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error when connecting: %s", err)
+	}
+	defer secondConn.Close()
+	select {
+	case <-connectedChan:
+		t.Fatalf("got unexpected connection beyond limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Close the listener, which should close the active connection
+	if err := listener.Close(); err != nil {
+		t.Fatalf("unexpected error closing listener: %s", err)
+	}
+	select {
+	case err := <-serveResultChan:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("did not get expected error from Serve(): %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve() did not return after Close()")
+	}
+	if len(listener.Connections()) != 0 {
+		t.Fatalf("connections were not cleaned up after Close()")
+	}
+	select {
+	case <-oClient.ErrorChan():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client connection was not closed by server")
+	}
+	oClient.Close()
+}
+
+// temporaryError is a net.Error that reports itself as temporary
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener returns a temporary error from Accept a number of times before accepting connections
+type flakyListener struct {
+	net.Listener
+	mutex    sync.Mutex
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	l.mutex.Lock()
+	if l.failures > 0 {
+		l.failures--
+		l.mutex.Unlock()
+		return nil, temporaryError{}
+	}
+	l.mutex.Unlock()
+	return l.Listener.Accept()
+}
+
+func TestListenerTemporaryAcceptError(t *testing.T) {
+	netListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error creating listener: %s", err)
+	}
+	connectedChan := make(chan *ouroboros.Connection, 10)
+	listener := ouroboros.NewListener(
+		&flakyListener{Listener: netListener, failures: 3},
+		ouroboros.WithListenerConnectionOptions(
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+		),
+		ouroboros.WithListenerConnectionFunc(func(conn *ouroboros.Connection) error {
+			connectedChan <- conn
+			return nil
+		}),
+	)
+	serveResultChan := make(chan error)
+	go func() {
+		serveResultChan <- listener.Serve()
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithNodeToNode(true),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	defer oClient.Close()
+	if err := oClient.Dial("tcp", listener.Addr().String()); err != nil {
+		t.Fatalf("unexpected error when connecting: %s", err)
+	}
+	select {
+	case <-connectedChan:
+	case err := <-serveResultChan:
+		t.Fatalf("Serve() returned unexpectedly: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not get expected server connection")
+	}
+	if err := listener.Close(); err != nil {
+		t.Fatalf("unexpected error closing listener: %s", err)
+	}
+	if err := <-serveResultChan; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("did not get expected error from Serve(): %s", err)
+	}
+}
+
+// The error for a failed handshake wraps the underlying error
+func TestListenerHandshakeError(t *testing.T) {
+	errorChan := make(chan error, 10)
+	listener, err := ouroboros.Listen(
+		"tcp",
+		"127.0.0.1:0",
+		ouroboros.WithListenerConnectionOptions(
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+		),
+		ouroboros.WithListenerErrorFunc(func(conn *ouroboros.Connection, err error) {
+			errorChan <- err
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating listener: %s", err)
+	}
+	serveResultChan := make(chan error)
+	go func() {
+		serveResultChan <- listener.Serve()
+	}()
+	// Close the connection without sending a handshake request
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error when connecting: %s", err)
+	}
+	conn.Close()
+	select {
+	case err := <-errorChan:
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("did not get expected wrapped error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not get expected handshake error")
+	}
+	if err := listener.Close(); err != nil {
+		t.Fatalf("unexpected error closing listener: %s", err)
+	}
+	if err := <-serveResultChan; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("did not get expected error from Serve(): %s", err)
+	}
+}
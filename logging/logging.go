@@ -0,0 +1,209 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging provides the logger interface used throughout this library
+//
+// Log events consist of a message and a list of alternating keys and values. The Logger interface matches
+// the corresponding methods of log/slog's Logger, so a *slog.Logger can be used directly where available.
+// Adapters for other logging libraries only need to implement these four methods
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is the interface for structured, leveled logging
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Level is an enum of the log levels
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// LevelEnabler is implemented by Loggers that can report whether log events at a level are output. This lets
+// callers skip building log events that would be discarded
+type LevelEnabler interface {
+	Enabled(level Level) bool
+}
+
+// Enabled returns whether the Logger outputs log events at the provided level. Loggers that don't implement
+// LevelEnabler are assumed to output all levels
+func Enabled(logger Logger, level Level) bool {
+	switch l := logger.(type) {
+	case nil, nopLogger:
+		return false
+	case LevelEnabler:
+		return l.Enabled(level)
+	}
+	return true
+}
+
+// HasKey returns whether the key/value pairs added to the Logger with With include the provided key
+func HasKey(logger Logger, key string) bool {
+	for {
+		l, ok := logger.(*withLogger)
+		if !ok {
+			return false
+		}
+		for idx := 0; idx+1 < len(l.args); idx += 2 {
+			if l.args[idx] == key {
+				return true
+			}
+		}
+		logger = l.logger
+	}
+}
+
+// nopLogger discards all log events
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// NewNopLogger returns a Logger that discards all log events. This is the default when no Logger is provided
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+// withLogger adds a fixed set of key/value pairs to each log event
+type withLogger struct {
+	logger Logger
+	args   []interface{}
+}
+
+// With returns a Logger that adds the provided key/value pairs to each log event before passing it to the
+// provided Logger. A nil Logger results in a Logger that discards all log events
+func With(logger Logger, args ...interface{}) Logger {
+	if logger == nil {
+		return NewNopLogger()
+	}
+	if _, ok := logger.(nopLogger); ok {
+		return logger
+	}
+	return &withLogger{
+		logger: logger,
+		args:   args,
+	}
+}
+
+func (l *withLogger) Debug(msg string, args ...interface{}) {
+	l.logger.Debug(msg, l.combineArgs(args)...)
+}
+
+func (l *withLogger) Info(msg string, args ...interface{}) {
+	l.logger.Info(msg, l.combineArgs(args)...)
+}
+
+func (l *withLogger) Warn(msg string, args ...interface{}) {
+	l.logger.Warn(msg, l.combineArgs(args)...)
+}
+
+func (l *withLogger) Error(msg string, args ...interface{}) {
+	l.logger.Error(msg, l.combineArgs(args)...)
+}
+
+// Enabled returns whether the underlying Logger outputs log events at the provided level
+func (l *withLogger) Enabled(level Level) bool {
+	return Enabled(l.logger, level)
+}
+
+func (l *withLogger) combineArgs(args []interface{}) []interface{} {
+	ret := make([]interface{}, 0, len(l.args)+len(args))
+	ret = append(ret, l.args...)
+	ret = append(ret, args...)
+	return ret
+}
+
+// stdLogger writes log events at or above a minimum level to a log.Logger from the standard library
+type stdLogger struct {
+	logger   *log.Logger
+	minLevel Level
+}
+
+// NewStdLogger returns a Logger that writes log events at or above the specified level to the provided
+// log.Logger in a key=value format
+func NewStdLogger(logger *log.Logger, minLevel Level) Logger {
+	return &stdLogger{
+		logger:   logger,
+		minLevel: minLevel,
+	}
+}
+
+func (l *stdLogger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args)
+}
+
+func (l *stdLogger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args)
+}
+
+func (l *stdLogger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args)
+}
+
+func (l *stdLogger) Error(msg string, args ...interface{}) {
+	l.log(LevelError, msg, args)
+}
+
+// Enabled returns whether log events at the provided level are output
+func (l *stdLogger) Enabled(level Level) bool {
+	return level >= l.minLevel
+}
+
+func (l *stdLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.minLevel {
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "level=%s msg=%q", level, msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&sb, " %v=%v", args[i], args[i+1])
+		} else {
+			// Odd number of args
+			fmt.Fprintf(&sb, " !BADKEY=%v", args[i])
+		}
+	}
+	l.logger.Print(sb.String())
+}
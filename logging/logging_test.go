@@ -0,0 +1,69 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging_test
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/blinklabs-io/gouroboros/logging"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.With(
+		logging.NewStdLogger(log.New(&buf, "", 0), logging.LevelInfo),
+		"component", "test",
+	)
+	logger.Debug("this should be filtered")
+	logger.Info("state change", "from", "Idle", "to", "Busy")
+	expected := "level=INFO msg=\"state change\" component=test from=Idle to=Busy\n"
+	if buf.String() != expected {
+		t.Fatalf("did not get expected log output\n  got:    %q\n  wanted: %q", buf.String(), expected)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	logger := logging.With(
+		logging.NewStdLogger(log.New(&bytes.Buffer{}, "", 0), logging.LevelInfo),
+		"component", "test",
+	)
+	if logging.Enabled(logger, logging.LevelDebug) {
+		t.Fatalf("debug level should not be enabled")
+	}
+	if !logging.Enabled(logger, logging.LevelInfo) {
+		t.Fatalf("info level should be enabled")
+	}
+	if logging.Enabled(logging.NewNopLogger(), logging.LevelError) {
+		t.Fatalf("no levels should be enabled for nop logger")
+	}
+}
+
+func TestHasKey(t *testing.T) {
+	logger := logging.With(
+		logging.With(
+			logging.NewStdLogger(log.New(&bytes.Buffer{}, "", 0), logging.LevelInfo),
+			"peer", "127.0.0.1:3001",
+		),
+		"component", "muxer",
+	)
+	if !logging.HasKey(logger, "peer") || !logging.HasKey(logger, "component") {
+		t.Fatalf("did not find expected keys")
+	}
+	if logging.HasKey(logger, "127.0.0.1:3001") || logging.HasKey(logger, "protocol") {
+		t.Fatalf("found unexpected key")
+	}
+}
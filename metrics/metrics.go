@@ -0,0 +1,160 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics provides the metrics interface used by the muxer and mini-protocols
+//
+// A Provider creates named metrics with a fixed set of label names. Label values are provided in the same order
+// as the label names, either each time a metric is updated or up front with With. Setting the label values up
+// front is preferred for metrics that are updated often, since it lets the Provider find the underlying values
+// once rather than on each update. Providers may be asked for the same metric more than once, such as once per
+// connection, and must return a metric that updates the same underlying values each time
+package metrics
+
+// Provider is the interface for creating metrics
+type Provider interface {
+	Counter(name string, help string, labelNames ...string) Counter
+	Gauge(name string, help string, labelNames ...string) Gauge
+	Histogram(name string, help string, buckets []float64, labelNames ...string) Histogram
+}
+
+// Counter is a metric that only increases
+type Counter interface {
+	Add(delta float64, labelValues ...string)
+	// With returns the counter with the provided leading label values already set
+	With(labelValues ...string) Counter
+}
+
+// Gauge is a metric that can increase and decrease
+type Gauge interface {
+	Add(delta float64, labelValues ...string)
+	Set(value float64, labelValues ...string)
+	// With returns the gauge with the provided leading label values already set
+	With(labelValues ...string) Gauge
+}
+
+// Histogram is a metric that counts observed values in buckets
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+	// With returns the histogram with the provided leading label values already set
+	With(labelValues ...string) Histogram
+}
+
+// Deleter is implemented by Providers that can remove metric series that are no longer needed
+type Deleter interface {
+	// DeleteLabels removes the series of all metrics that have the provided labels, specified as name/value pairs
+	DeleteLabels(labels ...string)
+}
+
+// DeleteLabels removes the series of all metrics that have the provided labels, specified as name/value pairs,
+// if the Provider supports it. For a Provider returned by WithLabels, its own labels are included
+func DeleteLabels(provider Provider, labels ...string) {
+	if deleter, ok := provider.(Deleter); ok {
+		deleter.DeleteLabels(labels...)
+	}
+}
+
+// DefaultBuckets are the default histogram buckets, which are suitable for durations in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// nopProvider creates metrics that discard all updates
+type nopProvider struct{}
+
+func (nopProvider) Counter(string, string, ...string) Counter {
+	return nopCounter{}
+}
+
+func (nopProvider) Gauge(string, string, ...string) Gauge {
+	return nopGauge{}
+}
+
+func (nopProvider) Histogram(string, string, []float64, ...string) Histogram {
+	return nopHistogram{}
+}
+
+type nopCounter struct{}
+
+func (nopCounter) Add(float64, ...string) {}
+func (nopCounter) With(...string) Counter { return nopCounter{} }
+
+type nopGauge struct{}
+
+func (nopGauge) Add(float64, ...string) {}
+func (nopGauge) Set(float64, ...string) {}
+func (nopGauge) With(...string) Gauge   { return nopGauge{} }
+
+type nopHistogram struct{}
+
+func (nopHistogram) Observe(float64, ...string) {}
+func (nopHistogram) With(...string) Histogram   { return nopHistogram{} }
+
+// NewNopProvider returns a Provider that creates metrics that discard all updates. This is the default when no
+// Provider is specified
+func NewNopProvider() Provider {
+	return nopProvider{}
+}
+
+// OrNop returns the provided Provider, or a Provider that discards all updates if it's nil
+func OrNop(provider Provider) Provider {
+	if provider == nil {
+		return NewNopProvider()
+	}
+	return provider
+}
+
+// labeledProvider adds a fixed set of labels to the metrics of another Provider
+type labeledProvider struct {
+	provider    Provider
+	labelNames  []string
+	labelValues []string
+}
+
+// WithLabels returns a Provider that adds the provided labels, specified as name/value pairs, to all metrics
+// created through it. The labels come before any label names provided when creating a metric. This can be used
+// to give each connection its own set of metrics in a shared Provider
+func WithLabels(provider Provider, labels ...string) Provider {
+	ret := labeledProvider{
+		provider: OrNop(provider),
+	}
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		ret.labelNames = append(ret.labelNames, labels[idx])
+		ret.labelValues = append(ret.labelValues, labels[idx+1])
+	}
+	return ret
+}
+
+func (p labeledProvider) Counter(name string, help string, labelNames ...string) Counter {
+	return p.provider.Counter(name, help, p.allLabelNames(labelNames)...).With(p.labelValues...)
+}
+
+func (p labeledProvider) Gauge(name string, help string, labelNames ...string) Gauge {
+	return p.provider.Gauge(name, help, p.allLabelNames(labelNames)...).With(p.labelValues...)
+}
+
+func (p labeledProvider) Histogram(name string, help string, buckets []float64, labelNames ...string) Histogram {
+	return p.provider.Histogram(name, help, buckets, p.allLabelNames(labelNames)...).With(p.labelValues...)
+}
+
+// DeleteLabels removes the series with the labels of the Provider and the provided labels from the underlying
+// Provider
+func (p labeledProvider) DeleteLabels(labels ...string) {
+	allLabels := make([]string, 0, len(p.labelNames)*2+len(labels))
+	for idx, name := range p.labelNames {
+		allLabels = append(allLabels, name, p.labelValues[idx])
+	}
+	DeleteLabels(p.provider, append(allLabels, labels...)...)
+}
+
+func (p labeledProvider) allLabelNames(labelNames []string) []string {
+	return append(append([]string{}, p.labelNames...), labelNames...)
+}
@@ -0,0 +1,383 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	prometheusTypeCounter   = "counter"
+	prometheusTypeGauge     = "gauge"
+	prometheusTypeHistogram = "histogram"
+)
+
+// PrometheusRegistry is a Provider that keeps metrics in memory and exposes them in the Prometheus text
+// exposition format. It can be used directly as the HTTP handler for a scrape endpoint.
+//
+// Updates through a metric returned by With with all label values set don't take any locks or allocate memory
+type PrometheusRegistry struct {
+	mutex   sync.Mutex
+	metrics map[string]*prometheusMetric
+}
+
+type prometheusMetric struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+	mutex      sync.Mutex
+	series     map[string]*prometheusSeries
+}
+
+// prometheusSeries holds the values for one set of label values. The values are updated atomically, and the
+// float64 values are stored as their bits
+type prometheusSeries struct {
+	// These are accessed atomically, so they're kept at the start of the struct for 64-bit alignment
+	value        uint64
+	count        uint64
+	labelValues  []string
+	bucketCounts []uint64
+}
+
+// NewPrometheusRegistry returns a new, empty PrometheusRegistry
+func NewPrometheusRegistry() *PrometheusRegistry {
+	return &PrometheusRegistry{
+		metrics: make(map[string]*prometheusMetric),
+	}
+}
+
+// Counter returns the counter with the specified name, creating it if needed
+func (r *PrometheusRegistry) Counter(name string, help string, labelNames ...string) Counter {
+	return prometheusCounter{prometheusRef{metric: r.getMetric(name, help, prometheusTypeCounter, nil, labelNames)}}
+}
+
+// Gauge returns the gauge with the specified name, creating it if needed
+func (r *PrometheusRegistry) Gauge(name string, help string, labelNames ...string) Gauge {
+	return prometheusGauge{prometheusRef{metric: r.getMetric(name, help, prometheusTypeGauge, nil, labelNames)}}
+}
+
+// Histogram returns the histogram with the specified name, creating it if needed. DefaultBuckets are used if
+// no buckets are provided
+func (r *PrometheusRegistry) Histogram(name string, help string, buckets []float64, labelNames ...string) Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return prometheusHistogram{prometheusRef{metric: r.getMetric(name, help, prometheusTypeHistogram, buckets, labelNames)}}
+}
+
+// getMetric returns the existing metric with the specified name, or registers a new one. It panics if a metric
+// with the same name but a different type or label names was already registered, since this is always a
+// programming error
+func (r *PrometheusRegistry) getMetric(name string, help string, metricType string, buckets []float64, labelNames []string) *prometheusMetric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if metric, ok := r.metrics[name]; ok {
+		if metric.metricType != metricType || strings.Join(metric.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metric %s already registered with a different type or labels", name))
+		}
+		return metric
+	}
+	metric := &prometheusMetric{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: append([]string{}, labelNames...),
+		buckets:    buckets,
+		series:     make(map[string]*prometheusSeries),
+	}
+	r.metrics[name] = metric
+	return metric
+}
+
+// getSeries returns the series for the provided label values, creating it if needed
+func (m *prometheusMetric) getSeries(labelValues []string) *prometheusSeries {
+	// Missing label values are treated as empty, and extra label values are ignored
+	values := make([]string, len(m.labelNames))
+	copy(values, labelValues)
+	key := strings.Join(values, "\xff")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	series, ok := m.series[key]
+	if !ok {
+		series = &prometheusSeries{
+			labelValues: values,
+		}
+		if m.metricType == prometheusTypeHistogram {
+			series.bucketCounts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = series
+	}
+	return series
+}
+
+// DeleteLabels removes the series of all metrics that have the provided labels, specified as name/value pairs.
+// Metrics that don't have all of the label names are left alone
+func (r *PrometheusRegistry) DeleteLabels(labels ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, metric := range r.metrics {
+		metric.deleteSeries(labels)
+	}
+}
+
+// deleteSeries removes the series that match the provided label name/value pairs
+func (m *prometheusMetric) deleteSeries(labels []string) {
+	labelIdxs := make([]int, 0, len(labels)/2)
+	labelValues := make([]string, 0, len(labels)/2)
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		found := false
+		for nameIdx, name := range m.labelNames {
+			if name == labels[idx] {
+				labelIdxs = append(labelIdxs, nameIdx)
+				labelValues = append(labelValues, labels[idx+1])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for key, series := range m.series {
+		match := true
+		for idx, nameIdx := range labelIdxs {
+			if series.labelValues[nameIdx] != labelValues[idx] {
+				match = false
+				break
+			}
+		}
+		if match {
+			delete(m.series, key)
+		}
+	}
+}
+
+// sortedSeries returns the series of the metric, sorted by their label values
+func (m *prometheusMetric) sortedSeries() []*prometheusSeries {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ret := make([]*prometheusSeries, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, m.series[key])
+	}
+	return ret
+}
+
+func (s *prometheusSeries) add(delta float64) {
+	for {
+		oldBits := atomic.LoadUint64(&s.value)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + delta)
+		if atomic.CompareAndSwapUint64(&s.value, oldBits, newBits) {
+			return
+		}
+	}
+}
+
+func (s *prometheusSeries) set(value float64) {
+	atomic.StoreUint64(&s.value, math.Float64bits(value))
+}
+
+func (s *prometheusSeries) observe(buckets []float64, value float64) {
+	for idx, bucket := range buckets {
+		if value <= bucket {
+			atomic.AddUint64(&s.bucketCounts[idx], 1)
+		}
+	}
+	s.add(value)
+	atomic.AddUint64(&s.count, 1)
+}
+
+func (s *prometheusSeries) loadValue() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.value))
+}
+
+// prometheusRef refers to a metric with some or all of its label values already set
+type prometheusRef struct {
+	metric      *prometheusMetric
+	labelValues []string
+	// The series is looked up up front once all label values are known
+	series *prometheusSeries
+}
+
+func (r prometheusRef) with(labelValues []string) prometheusRef {
+	values := make([]string, 0, len(r.labelValues)+len(labelValues))
+	values = append(append(values, r.labelValues...), labelValues...)
+	ret := prometheusRef{
+		metric:      r.metric,
+		labelValues: values,
+	}
+	if len(values) >= len(r.metric.labelNames) {
+		ret.series = r.metric.getSeries(values)
+	}
+	return ret
+}
+
+func (r prometheusRef) getSeries(labelValues []string) *prometheusSeries {
+	if r.series != nil {
+		return r.series
+	}
+	if len(r.labelValues) > 0 {
+		labelValues = append(append([]string{}, r.labelValues...), labelValues...)
+	}
+	return r.metric.getSeries(labelValues)
+}
+
+type prometheusCounter struct {
+	prometheusRef
+}
+
+// Add adds the provided value to the counter
+func (c prometheusCounter) Add(delta float64, labelValues ...string) {
+	c.getSeries(labelValues).add(delta)
+}
+
+// With returns the counter with the provided label values set
+func (c prometheusCounter) With(labelValues ...string) Counter {
+	return prometheusCounter{c.with(labelValues)}
+}
+
+type prometheusGauge struct {
+	prometheusRef
+}
+
+// Add adds the provided value to the gauge
+func (g prometheusGauge) Add(delta float64, labelValues ...string) {
+	g.getSeries(labelValues).add(delta)
+}
+
+// Set sets the value of the gauge
+func (g prometheusGauge) Set(value float64, labelValues ...string) {
+	g.getSeries(labelValues).set(value)
+}
+
+// With returns the gauge with the provided label values set
+func (g prometheusGauge) With(labelValues ...string) Gauge {
+	return prometheusGauge{g.with(labelValues)}
+}
+
+type prometheusHistogram struct {
+	prometheusRef
+}
+
+// Observe records a value in the histogram
+func (h prometheusHistogram) Observe(value float64, labelValues ...string) {
+	h.getSeries(labelValues).observe(h.metric.buckets, value)
+}
+
+// With returns the histogram with the provided label values set
+func (h prometheusHistogram) With(labelValues ...string) Histogram {
+	return prometheusHistogram{h.with(labelValues)}
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (r *PrometheusRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+// Write writes all metrics in the Prometheus text exposition format to the provided io.Writer
+func (r *PrometheusRegistry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	buf := bufio.NewWriter(w)
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metric := r.metrics[name]
+		fmt.Fprintf(buf, "# HELP %s %s\n", metric.name, escapeHelp(metric.help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", metric.name, metric.metricType)
+		for _, series := range metric.sortedSeries() {
+			labels := formatLabels(metric.labelNames, series.labelValues)
+			if metric.metricType != prometheusTypeHistogram {
+				fmt.Fprintf(buf, "%s%s %s\n", metric.name, labels, formatValue(series.loadValue()))
+				continue
+			}
+			for idx, bucket := range metric.buckets {
+				bucketLabels := formatLabels(
+					append(append([]string{}, metric.labelNames...), "le"),
+					append(append([]string{}, series.labelValues...), formatValue(bucket)),
+				)
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", metric.name, bucketLabels, atomic.LoadUint64(&series.bucketCounts[idx]))
+			}
+			infLabels := formatLabels(
+				append(append([]string{}, metric.labelNames...), "le"),
+				append(append([]string{}, series.labelValues...), "+Inf"),
+			)
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", metric.name, infLabels, atomic.LoadUint64(&series.count))
+			fmt.Fprintf(buf, "%s_sum%s %s\n", metric.name, labels, formatValue(series.loadValue()))
+			fmt.Fprintf(buf, "%s_count%s %d\n", metric.name, labels, atomic.LoadUint64(&series.count))
+		}
+	}
+	return buf.Flush()
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names))
+	for idx, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[idx])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
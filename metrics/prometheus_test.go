@@ -0,0 +1,142 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/blinklabs-io/gouroboros/metrics"
+)
+
+func TestPrometheusRegistry(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	counter := registry.Counter("test_total", "Test counter", "direction")
+	counter.Add(1, "sent")
+	counter.Add(2, "sent")
+	// Asking for the same metric again should update the same values
+	registry.Counter("test_total", "Test counter", "direction").Add(1, "received")
+	gauge := registry.Gauge("test_depth", "Test gauge")
+	gauge.Add(5)
+	gauge.Add(-2)
+	histogram := registry.Histogram("test_seconds", "Test histogram", []float64{1, 0.1}, "state")
+	histogram.Observe(0.05, "Idle")
+	histogram.Observe(0.5, "Idle")
+	histogram.Observe(2, "Idle")
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `# HELP test_depth Test gauge
+# TYPE test_depth gauge
+test_depth 3
+# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{state="Idle",le="0.1"} 1
+test_seconds_bucket{state="Idle",le="1"} 2
+test_seconds_bucket{state="Idle",le="+Inf"} 3
+test_seconds_sum{state="Idle"} 2.55
+test_seconds_count{state="Idle"} 3
+# HELP test_total Test counter
+# TYPE test_total counter
+test_total{direction="received"} 1
+test_total{direction="sent"} 3
+`
+	if buf.String() != expected {
+		t.Fatalf("did not get expected output\n  got:\n%s\n  wanted:\n%s", buf.String(), expected)
+	}
+}
+
+func TestPrometheusRegistryWithLabels(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	for _, peer := range []string{"peer-a", "peer-b"} {
+		provider := metrics.WithLabels(registry, "peer", peer)
+		counter := provider.Counter("test_total", "Test counter", "direction").With("sent")
+		counter.Add(1)
+		provider.Gauge("test_depth", "Test gauge").Set(2)
+		provider.Histogram("test_seconds", "Test histogram", []float64{1}, "state").With("Idle").Observe(0.5)
+	}
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `# HELP test_depth Test gauge
+# TYPE test_depth gauge
+test_depth{peer="peer-a"} 2
+test_depth{peer="peer-b"} 2
+# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{peer="peer-a",state="Idle",le="1"} 1
+test_seconds_bucket{peer="peer-a",state="Idle",le="+Inf"} 1
+test_seconds_sum{peer="peer-a",state="Idle"} 0.5
+test_seconds_count{peer="peer-a",state="Idle"} 1
+test_seconds_bucket{peer="peer-b",state="Idle",le="1"} 1
+test_seconds_bucket{peer="peer-b",state="Idle",le="+Inf"} 1
+test_seconds_sum{peer="peer-b",state="Idle"} 0.5
+test_seconds_count{peer="peer-b",state="Idle"} 1
+# HELP test_total Test counter
+# TYPE test_total counter
+test_total{peer="peer-a",direction="sent"} 1
+test_total{peer="peer-b",direction="sent"} 1
+`
+	if buf.String() != expected {
+		t.Fatalf("did not get expected output\n  got:\n%s\n  wanted:\n%s", buf.String(), expected)
+	}
+}
+
+func TestPrometheusRegistryDeleteLabels(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	providers := map[string]metrics.Provider{}
+	for _, peer := range []string{"peer-a", "peer-b"} {
+		providers[peer] = metrics.WithLabels(registry, "peer", peer)
+		providers[peer].Counter("test_total", "Test counter", "direction").With("sent").Add(1)
+		providers[peer].Histogram("test_seconds", "Test histogram", []float64{1}).Observe(0.5)
+	}
+	registry.Gauge("test_depth", "Test gauge").Set(2)
+	metrics.DeleteLabels(providers["peer-a"])
+	var buf bytes.Buffer
+	if err := registry.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `# HELP test_depth Test gauge
+# TYPE test_depth gauge
+test_depth 2
+# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{peer="peer-b",le="1"} 1
+test_seconds_bucket{peer="peer-b",le="+Inf"} 1
+test_seconds_sum{peer="peer-b"} 0.5
+test_seconds_count{peer="peer-b"} 1
+# HELP test_total Test counter
+# TYPE test_total counter
+test_total{peer="peer-b",direction="sent"} 1
+`
+	if buf.String() != expected {
+		t.Fatalf("did not get expected output\n  got:\n%s\n  wanted:\n%s", buf.String(), expected)
+	}
+}
+
+func TestPrometheusRegistryBoundUpdatesDoNotAllocate(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	counter := registry.Counter("test_total", "Test counter", "peer", "direction").With("peer-a", "sent")
+	histogram := registry.Histogram("test_seconds", "Test histogram", nil, "state").With("Idle")
+	allocs := testing.AllocsPerRun(100, func() {
+		counter.Add(1)
+		histogram.Observe(0.1)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %f", allocs)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Bearer is the byte transport that the muxer runs over. Any net.Conn is a Bearer, and the bearer package
+// provides implementations for other transports
+type Bearer interface {
+	io.ReadWriteCloser
+	// RemoteAddr returns the address of the peer, which is used in log events. It may return nil if the
+	// transport has no meaningful address
+	RemoteAddr() net.Addr
+	// SetWriteDeadline sets the deadline for future Write calls. It's used to limit the time spent writing
+	// queued segments when draining the muxer. Implementations that can't support deadlines may return an error
+	SetWriteDeadline(time.Time) error
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+import (
+	"sync"
+
+	"github.com/blinklabs-io/gouroboros/metrics"
+)
+
+// Default maximum payload size for the segments that we send. This matches the SDU size used by the Haskell
+// node for socket bearers
+const DefaultSDUSize = 12288
+
+// Number of segments of the negotiated SDU size that can be queued for a protocol before the protocol has to
+// wait to queue more
+const egressQueueMaxSegments = 2
+
+// EgressQueueStats contains the statistics for the egress queue of a registered protocol
+type EgressQueueStats struct {
+	ProtocolId     uint16
+	ProtocolRole   ProtocolRole
+	QueuedSegments int
+	QueuedBytes    int
+	SentSegments   uint64
+	SentBytes      uint64
+}
+
+// egressQueue holds the segments waiting to be sent for a registered protocol
+type egressQueue struct {
+	protocolId   uint16
+	protocolRole ProtocolRole
+	segments     []*Segment
+	queuedBytes  int
+	sentSegments uint64
+	sentBytes    uint64
+	spaceChan    chan struct{}
+	// Metrics for the queue, with the label values already set
+	metrics          segmentMetrics
+	queuedBytesGauge metrics.Gauge
+}
+
+// egressScheduler interleaves the segments queued by the registered protocols. Queues are served round-robin,
+// one segment at a time, so that a protocol with a lot of data to send can't hold up the others
+type egressScheduler struct {
+	mutex     sync.Mutex
+	queues    []*egressQueue
+	position  int
+	sduSize   int
+	readyChan chan struct{}
+}
+
+func newEgressScheduler(sduSize int) *egressScheduler {
+	return &egressScheduler{
+		sduSize:   sduSize,
+		readyChan: make(chan struct{}, 1),
+	}
+}
+
+// addQueue creates an egress queue for a protocol
+func (s *egressScheduler) addQueue(protocolId uint16, protocolRole ProtocolRole, sentMetrics segmentMetrics, queuedBytesGauge metrics.Gauge) *egressQueue {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queue := &egressQueue{
+		protocolId:       protocolId,
+		protocolRole:     protocolRole,
+		spaceChan:        make(chan struct{}, 1),
+		metrics:          sentMetrics,
+		queuedBytesGauge: queuedBytesGauge,
+	}
+	s.queues = append(s.queues, queue)
+	return queue
+}
+
+// full returns whether the queue has reached its maximum size
+func (s *egressScheduler) full(queue *egressQueue) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return queue.queuedBytes >= egressQueueMaxSegments*s.sduSize
+}
+
+// enqueue adds a segment to a queue, splitting it into segments no larger than the SDU size
+func (s *egressScheduler) enqueue(queue *egressQueue, segment *Segment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(segment.Payload) <= s.sduSize {
+		queue.segments = append(queue.segments, segment)
+	} else {
+		protocolId := segment.GetProtocolId()
+		isResponse := segment.IsResponse()
+		for offset := 0; offset < len(segment.Payload); offset += s.sduSize {
+			end := offset + s.sduSize
+			if end > len(segment.Payload) {
+				end = len(segment.Payload)
+			}
+			queue.segments = append(queue.segments, NewSegment(protocolId, segment.Payload[offset:end], isResponse))
+		}
+	}
+	queue.queuedBytes += len(segment.Payload)
+	// Wake up the send loop without blocking
+	select {
+	case s.readyChan <- struct{}{}:
+	default:
+	}
+}
+
+// next removes and returns the next segment to send, or nil if all queues are empty. The queue that the segment
+// came from is also returned so that the caller can update its statistics
+func (s *egressScheduler) next() (*Segment, *egressQueue) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 0; i < len(s.queues); i++ {
+		idx := (s.position + i) % len(s.queues)
+		queue := s.queues[idx]
+		if len(queue.segments) == 0 {
+			continue
+		}
+		segment := queue.segments[0]
+		queue.segments[0] = nil
+		queue.segments = queue.segments[1:]
+		queue.queuedBytes -= len(segment.Payload)
+		// Start with the following queue next time
+		s.position = idx + 1
+		// Let the protocol know that there's space in the queue
+		select {
+		case queue.spaceChan <- struct{}{}:
+		default:
+		}
+		return segment, queue
+	}
+	return nil, nil
+}
+
+// recordSent updates the statistics for a queue after a segment from it was sent
+func (s *egressScheduler) recordSent(queue *egressQueue, segment *Segment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queue.sentSegments++
+	queue.sentBytes += uint64(len(segment.Payload))
+}
+
+// stats returns the statistics for all queues in the order that the protocols were registered
+func (s *egressScheduler) stats() []EgressQueueStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make([]EgressQueueStats, 0, len(s.queues))
+	for _, queue := range s.queues {
+		ret = append(
+			ret,
+			EgressQueueStats{
+				ProtocolId:     queue.protocolId,
+				ProtocolRole:   queue.protocolRole,
+				QueuedSegments: len(queue.segments),
+				QueuedBytes:    queue.queuedBytes,
+				SentSegments:   queue.sentSegments,
+				SentBytes:      queue.sentBytes,
+			},
+		)
+	}
+	return ret
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+import (
+	"fmt"
+)
+
+// IngressLimitExceededError indicates that the peer sent more data for a mini-protocol than is allowed to be
+// queued before it's processed
+type IngressLimitExceededError struct {
+	ProtocolId uint16
+	Limit      int
+	Size       int
+}
+
+func (e IngressLimitExceededError) Error() string {
+	return fmt.Sprintf(
+		"ingress limit exceeded for protocol ID %d: %d bytes queued, limit is %d bytes",
+		e.ProtocolId,
+		e.Size,
+		e.Limit,
+	)
+}
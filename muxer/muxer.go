@@ -24,13 +24,34 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"net"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/logging"
+	"github.com/blinklabs-io/gouroboros/metrics"
 )
 
 // Magic number chosen to represent unknown protocols
 const ProtocolUnknown uint16 = 0xabcd
 
+// Size of the segment header on the wire
+const segmentHeaderLength = 8
+
+// DefaultIngressQueueLimit is the maximum number of bytes that can be queued for a protocol ID that has no
+// ingress limit specified. Without it, a peer or a slow protocol could make the queue grow without bound
+const DefaultIngressQueueLimit = 8 * 1024 * 1024
+
+// Maximum time to wait for the segments already received to be passed to the protocols when the peer closes
+// the connection
+const remoteCloseDeliveryTimeout = 1 * time.Second
+
+// Values for the direction label of muxer metrics
+const (
+	metricsDirectionSent     = "sent"
+	metricsDirectionReceived = "received"
+)
+
 // DiffusionMode is an enum for the valid muxer difficusion modes
 type DiffusionMode int
 
@@ -55,30 +76,121 @@ const (
 // Muxer wraps a connection to allow running multiple mini-protocols over a single connection
 type Muxer struct {
 	errorChan         chan error
-	conn              net.Conn
+	bearer            Bearer
 	sendMutex         sync.Mutex
 	startChan         chan bool
 	doneChan          chan bool
 	waitGroup         sync.WaitGroup
+	senderWaitGroup   sync.WaitGroup
+	drainOnStop       bool
+	protocolMutex     sync.RWMutex
 	protocolSenders   map[uint16]map[ProtocolRole]chan *Segment
-	protocolReceivers map[uint16]map[ProtocolRole]chan *Segment
+	protocolReceivers map[uint16]map[ProtocolRole]*receiver
+	ingressLimits     map[uint16]int
+	sduSize           int
+	egress            *egressScheduler
+	timing            *timing
+	tapFunc           TapFunc
 	diffusionMode     DiffusionMode
 	onceStart         sync.Once
 	onceStop          sync.Once
+	logger            logging.Logger
+	metrics           metrics.Provider
+	segmentsCounter   metrics.Counter
+	bytesCounter      metrics.Counter
+	egressBytesGauge  metrics.Gauge
+}
+
+// MuxerOptionFunc is a type that represents functions that modify the Muxer config
+type MuxerOptionFunc func(*Muxer)
+
+// WithLogger specifies the logger to use. Log events include the remote address of the connection, when known
+func WithLogger(logger logging.Logger) MuxerOptionFunc {
+	return func(m *Muxer) {
+		m.logger = logger
+	}
+}
+
+// WithMetrics specifies the metrics provider to use
+func WithMetrics(provider metrics.Provider) MuxerOptionFunc {
+	return func(m *Muxer) {
+		m.metrics = provider
+	}
+}
+
+// WithIngressLimits specifies the maximum number of bytes that can be queued for each protocol ID before the
+// protocol processes them. The muxer is stopped with an IngressLimitExceededError if a peer sends more than
+// that. Protocol IDs that aren't specified are limited to DefaultIngressQueueLimit, and a limit of 0 disables
+// the limit
+func WithIngressLimits(limits map[uint16]int) MuxerOptionFunc {
+	return func(m *Muxer) {
+		m.ingressLimits = limits
+	}
+}
+
+// WithSDUSize specifies the maximum payload size for the segments that we send. Larger segments from a protocol
+// are split up, which lets the segments of other protocols be sent in between. The default is DefaultSDUSize
+func WithSDUSize(sduSize int) MuxerOptionFunc {
+	return func(m *Muxer) {
+		m.sduSize = sduSize
+	}
 }
 
-// New creates a new Muxer object and starts the read loop
-func New(conn net.Conn) *Muxer {
+// WithTap specifies a function to call with each segment that is sent or received, such as for capturing the
+// traffic on a connection
+func WithTap(tapFunc TapFunc) MuxerOptionFunc {
+	return func(m *Muxer) {
+		m.tapFunc = tapFunc
+	}
+}
+
+// New creates a new Muxer object for the provided bearer and starts the read loop
+func New(bearer Bearer, options ...MuxerOptionFunc) *Muxer {
 	m := &Muxer{
-		conn:              conn,
+		bearer:            bearer,
 		startChan:         make(chan bool, 1),
 		doneChan:          make(chan bool),
 		errorChan:         make(chan error, 10),
 		protocolSenders:   make(map[uint16]map[ProtocolRole]chan *Segment),
-		protocolReceivers: make(map[uint16]map[ProtocolRole]chan *Segment),
+		protocolReceivers: make(map[uint16]map[ProtocolRole]*receiver),
+	}
+	// Apply provided options functions
+	for _, option := range options {
+		option(m)
 	}
+	if m.sduSize <= 0 || m.sduSize > SegmentMaxPayloadLength {
+		m.sduSize = DefaultSDUSize
+	}
+	m.egress = newEgressScheduler(m.sduSize)
+	m.timing = newTiming()
+	m.logger = logging.With(m.logger, "component", "muxer")
+	// A Connection already adds the remote address to its logger
+	if remoteAddr := bearer.RemoteAddr(); remoteAddr != nil && !logging.HasKey(m.logger, "peer") {
+		m.logger = logging.With(m.logger, "peer", remoteAddr.String())
+	}
+	m.metrics = metrics.OrNop(m.metrics)
+	// The label values for these are set when each protocol is registered
+	m.segmentsCounter = m.metrics.Counter(
+		"ouroboros_muxer_segments_total",
+		"Number of muxer segments sent and received",
+		"protocol_id", "direction",
+	)
+	m.bytesCounter = m.metrics.Counter(
+		"ouroboros_muxer_bytes_total",
+		"Number of bytes sent and received by the muxer, including segment headers",
+		"protocol_id", "direction",
+	)
+	m.egressBytesGauge = m.metrics.Gauge(
+		"ouroboros_muxer_egress_queue_bytes",
+		"Number of payload bytes queued for sending by the muxer",
+		"protocol_id",
+	)
+	// Both wait groups are incremented before starting either goroutine, since an early read error stops the
+	// muxer and waits on them
 	m.waitGroup.Add(1)
+	m.senderWaitGroup.Add(1)
 	go m.readLoop()
+	go m.egressLoop()
 	return m
 }
 
@@ -86,6 +198,32 @@ func (m *Muxer) ErrorChan() chan error {
 	return m.errorChan
 }
 
+// IngressLimit returns the ingress limit specified for the provided protocol ID, or 0 if there is none. This
+// is also used by the protocol to limit the size of a message, so it doesn't include DefaultIngressQueueLimit
+func (m *Muxer) IngressLimit(protocolId uint16) int {
+	return m.ingressLimits[protocolId]
+}
+
+// ingressQueueLimit returns the maximum number of bytes that can be queued for the provided protocol ID, or 0
+// if there is no limit
+func (m *Muxer) ingressQueueLimit(protocolId uint16) int {
+	if limit, ok := m.ingressLimits[protocolId]; ok {
+		return limit
+	}
+	return DefaultIngressQueueLimit
+}
+
+// EgressQueueStats returns the statistics for the egress queue of each registered protocol, in the order that
+// the protocols were registered
+func (m *Muxer) EgressQueueStats() []EgressQueueStats {
+	return m.egress.stats()
+}
+
+// TimingStats returns the round-trip time and clock drift estimated from the segments exchanged with the peer
+func (m *Muxer) TimingStats() TimingStats {
+	return m.timing.stats()
+}
+
 // Start unblocks the read loop after the initial handshake to allow it to start processing messages
 func (m *Muxer) Start() {
 	m.onceStart.Do(func() {
@@ -95,19 +233,46 @@ func (m *Muxer) Start() {
 
 // Stop shuts down the muxer
 func (m *Muxer) Stop() {
+	m.stop(false, time.Time{})
+}
+
+// Drain shuts down the muxer after writing any segments that are still queued for sending. Writes that don't
+// complete before the deadline fail, and a zero deadline means that writes don't time out
+func (m *Muxer) Drain(deadline time.Time) {
+	m.stop(true, deadline)
+}
+
+func (m *Muxer) stop(drain bool, deadline time.Time) {
 	m.onceStop.Do(func() {
-		// Close doneChan to signify that we're shutting down
-		close(m.doneChan)
-		// Close underlying connection
-		// We must do this to break out of pending Read() calls to shut down cleanly
-		_ = m.conn.Close()
+		m.drainOnStop = drain
+		if drain {
+			if !deadline.IsZero() {
+				_ = m.bearer.SetWriteDeadline(deadline)
+			}
+			// Close doneChan to signify that we're shutting down
+			close(m.doneChan)
+			// Wait for the sender goroutines to queue any remaining segments, and then write out the queued
+			// segments before closing the connection
+			m.senderWaitGroup.Wait()
+			m.flushEgress()
+			_ = m.bearer.Close()
+		} else {
+			// Close doneChan to signify that we're shutting down
+			close(m.doneChan)
+			// Close underlying connection
+			// We must do this to break out of pending Read() calls to shut down cleanly
+			_ = m.bearer.Close()
+			m.senderWaitGroup.Wait()
+		}
 		// Wait for other goroutines to shutdown
 		m.waitGroup.Wait()
 		// Close protocol receive channels
 		// We rely on the individual mini-protocols to close the sender channel
+		m.protocolMutex.RLock()
+		defer m.protocolMutex.RUnlock()
 		for _, protocolRoles := range m.protocolReceivers {
-			for _, recvChan := range protocolRoles {
-				close(recvChan)
+			for _, protocolReceiver := range protocolRoles {
+				close(protocolReceiver.recvChan)
 			}
 		}
 		// Close ErrorChan to signify to consumer that we're shutting down
@@ -128,49 +293,156 @@ func (m *Muxer) sendError(err error) {
 		return
 	default:
 	}
+	m.logger.Debug("muxer error", "error", err)
 	// Send error to consumer
 	m.errorChan <- err
 	// Stop the muxer on any error
-	m.Stop()
+	// We do this in a goroutine, since Stop() waits on the goroutine that called us
+	go m.Stop()
 }
 
 // RegisterProtocol registers the provided protocol ID with the muxer. It returns a channel for sending,
-// a channel for receiving, and a channel to know when the muxer is shutting down
+// a channel for receiving, and a channel to know when the muxer is shutting down. Received segments are queued
+// by the muxer until they're read from the receive channel, subject to the ingress limit for the protocol ID.
+// The receiver can call Release on each received segment once it's done with the payload to reduce allocations
 func (m *Muxer) RegisterProtocol(protocolId uint16, protocolRole ProtocolRole) (chan *Segment, chan *Segment, chan bool) {
 	// Generate channels
 	senderChan := make(chan *Segment, 10)
-	receiverChan := make(chan *Segment, 10)
+	protocolReceiver := newReceiver(protocolId, m.ingressQueueLimit(protocolId))
+	protocolReceiver.metrics = m.newSegmentMetrics(protocolId, metricsDirectionReceived)
 	// Record channels in protocol sender/receiver maps
+	// The read loop may already be running, so we hold the lock while updating the maps
+	m.protocolMutex.Lock()
 	if _, ok := m.protocolSenders[protocolId]; !ok {
 		m.protocolSenders[protocolId] = make(map[ProtocolRole]chan *Segment)
-		m.protocolReceivers[protocolId] = make(map[ProtocolRole]chan *Segment)
+		m.protocolReceivers[protocolId] = make(map[ProtocolRole]*receiver)
 	}
 	m.protocolSenders[protocolId][protocolRole] = senderChan
-	m.protocolReceivers[protocolId][protocolRole] = receiverChan
-	// Start Goroutine to handle outbound messages
+	m.protocolReceivers[protocolId][protocolRole] = protocolReceiver
+	m.protocolMutex.Unlock()
+	// Start Goroutine to pass received segments to the protocol
 	m.waitGroup.Add(1)
 	go func() {
 		defer m.waitGroup.Done()
+		protocolReceiver.deliverLoop(m.doneChan)
+	}()
+	// Start Goroutine to move outbound segments to the egress queue
+	queue := m.egress.addQueue(
+		protocolId,
+		protocolRole,
+		m.newSegmentMetrics(protocolId, metricsDirectionSent),
+		m.egressBytesGauge.With(strconv.Itoa(int(protocolId))),
+	)
+	m.senderWaitGroup.Add(1)
+	go func() {
+		defer m.senderWaitGroup.Done()
 		for {
-			select {
-			case _, ok := <-m.doneChan:
-				// doneChan has been closed, which means we're shutting down
-				if !ok {
+			// Wait for space in the egress queue, so that the protocol can't queue an unlimited amount of data
+			for m.egress.full(queue) {
+				select {
+				case <-m.doneChan:
+					m.drainSender(queue, senderChan)
 					return
+				case <-queue.spaceChan:
 				}
-			case msg := <-senderChan:
-				if err := m.Send(msg); err != nil {
-					m.sendError(err)
+			}
+			select {
+			case <-m.doneChan:
+				m.drainSender(queue, senderChan)
+				return
+			case msg, ok := <-senderChan:
+				// The protocol closes the sender channel when it shuts down
+				if !ok {
 					return
 				}
+				m.queueSegment(queue, msg)
 			}
 		}
 	}()
-	return senderChan, receiverChan, m.doneChan
+	return senderChan, protocolReceiver.recvChan, m.doneChan
+}
+
+// queueSegment adds a segment from a protocol to its egress queue
+func (m *Muxer) queueSegment(queue *egressQueue, msg *Segment) {
+	m.egress.enqueue(queue, msg)
+	queue.queuedBytesGauge.Add(float64(len(msg.Payload)))
 }
 
-// Send takes a populated Segment and writes it to the connection. A mutex is used to prevent more than
-// one protocol from sending at once
+// drainSender moves any segments remaining in the provided sender channel to the egress queue when shutting
+// down, if we're draining
+func (m *Muxer) drainSender(queue *egressQueue, senderChan chan *Segment) {
+	// drainOnStop is always set before doneChan is closed
+	if !m.drainOnStop {
+		return
+	}
+	for {
+		select {
+		case msg, ok := <-senderChan:
+			if !ok {
+				return
+			}
+			m.queueSegment(queue, msg)
+		default:
+			return
+		}
+	}
+}
+
+// egressLoop writes the segments from the egress queues to the connection
+func (m *Muxer) egressLoop() {
+	defer m.senderWaitGroup.Done()
+	for {
+		select {
+		case <-m.doneChan:
+			return
+		default:
+		}
+		segment, queue := m.egress.next()
+		if segment == nil {
+			select {
+			case <-m.doneChan:
+				return
+			case <-m.egress.readyChan:
+			}
+			continue
+		}
+		if err := m.sendEgress(segment, queue); err != nil {
+			m.sendError(err)
+			return
+		}
+	}
+}
+
+// flushEgress writes out all segments remaining in the egress queues when draining
+func (m *Muxer) flushEgress() {
+	for {
+		segment, queue := m.egress.next()
+		if segment == nil {
+			return
+		}
+		if err := m.sendEgress(segment, queue); err != nil {
+			m.logger.Debug("failed to write queued segment", "error", err)
+			return
+		}
+	}
+}
+
+// sendEgress writes a segment taken from an egress queue
+func (m *Muxer) sendEgress(segment *Segment, queue *egressQueue) error {
+	queue.queuedBytesGauge.Add(-float64(len(segment.Payload)))
+	if err := m.writeSegment(segment); err != nil {
+		return err
+	}
+	queue.metrics.record(segment)
+	m.egress.recordSent(queue, segment)
+	return nil
+}
+
+// Send takes a populated Segment and writes it directly to the connection. A mutex is used to prevent more than
+// one protocol from sending at once. This bypasses the egress scheduler entirely, so the segment is neither
+// split to the SDU size nor fairly interleaved with other protocols' traffic. It's only intended for out-of-band
+// writes, such as a test harness injecting raw segments, and protocols should send via their registered
+// send channel instead
 func (m *Muxer) Send(msg *Segment) error {
 	// Immediately return if we're already shutting down
 	select {
@@ -178,19 +450,44 @@ func (m *Muxer) Send(msg *Segment) error {
 		return fmt.Errorf("shutting down")
 	default:
 	}
+	if err := m.writeSegment(msg); err != nil {
+		return err
+	}
+	m.newSegmentMetrics(msg.GetProtocolId(), metricsDirectionSent).record(msg)
+	return nil
+}
+
+func (m *Muxer) writeSegment(msg *Segment) error {
 	// We use a mutex to make sure only one protocol can send at a time
 	m.sendMutex.Lock()
 	defer m.sendMutex.Unlock()
+	// Timestamp the segment when it's written rather than when it was created, since it may have been queued
+	sendTime := time.Now()
+	msg.Timestamp = segmentTimestamp(sendTime)
 	buf := &bytes.Buffer{}
 	err := binary.Write(buf, binary.BigEndian, msg.SegmentHeader)
 	if err != nil {
 		return err
 	}
 	buf.Write(msg.Payload)
-	_, err = m.conn.Write(buf.Bytes())
+	// The peer can respond as soon as the segment is written, so we record it first to make sure that it's seen
+	// before the response
+	m.timing.recordSent(msg.SegmentHeader, sendTime)
+	if m.tapFunc != nil {
+		m.tapFunc(TapDirectionOutbound, sendTime, msg)
+	}
+	_, err = m.bearer.Write(buf.Bytes())
 	if err != nil {
 		return err
 	}
+	if logging.Enabled(m.logger, logging.LevelDebug) {
+		m.logger.Debug(
+			"sent segment",
+			"protocol_id", msg.GetProtocolId(),
+			"payload_length", msg.PayloadLength,
+			"is_response", msg.IsResponse(),
+		)
+	}
 	return nil
 }
 
@@ -199,6 +496,8 @@ func (m *Muxer) Send(msg *Segment) error {
 func (m *Muxer) readLoop() {
 	defer m.waitGroup.Done()
 	started := false
+	// The header is read into a buffer that is reused for each segment, since binary.Read allocates
+	headerBuf := make([]byte, segmentHeaderLength)
 	for {
 		// Break out of read loop if we're shutting down
 		select {
@@ -206,21 +505,45 @@ func (m *Muxer) readLoop() {
 			return
 		default:
 		}
-		header := SegmentHeader{}
-		if err := binary.Read(m.conn, binary.BigEndian, &header); err != nil {
+		if _, err := io.ReadFull(m.bearer, headerBuf); err != nil {
+			if err == io.EOF {
+				// The peer may close the connection right after sending a final message such as MsgDone, so
+				// we let the protocols see what's already been received before shutting down
+				m.waitForDelivery(time.Now().Add(remoteCloseDeliveryTimeout))
+			}
 			m.sendError(err)
 			return
 		}
+		header := SegmentHeader{
+			Timestamp:     binary.BigEndian.Uint32(headerBuf[0:4]),
+			ProtocolId:    binary.BigEndian.Uint16(headerBuf[4:6]),
+			PayloadLength: binary.BigEndian.Uint16(headerBuf[6:8]),
+		}
+		recvTime := time.Now()
+		m.timing.recordReceived(header, recvTime)
+		payloadBuf := getPayloadBuffer(int(header.PayloadLength))
 		msg := &Segment{
 			SegmentHeader: header,
-			Payload:       make([]byte, header.PayloadLength),
+			Payload:       *payloadBuf,
+			payloadBuf:    payloadBuf,
 		}
 		// We use ReadFull because it guarantees to read the expected number of bytes or
 		// return an error
-		if _, err := io.ReadFull(m.conn, msg.Payload); err != nil {
+		if _, err := io.ReadFull(m.bearer, msg.Payload); err != nil {
 			m.sendError(err)
 			return
 		}
+		if m.tapFunc != nil {
+			m.tapFunc(TapDirectionInbound, recvTime, msg)
+		}
+		if logging.Enabled(m.logger, logging.LevelDebug) {
+			m.logger.Debug(
+				"received segment",
+				"protocol_id", msg.GetProtocolId(),
+				"payload_length", msg.PayloadLength,
+				"is_response", msg.IsResponse(),
+			)
+		}
 		// Check for message from initiator when we're not configured as a responder
 		if m.diffusionMode == DiffusionModeInitiator && !msg.IsResponse() {
 			m.sendError(fmt.Errorf("received message from initiator when not configured as a responder"))
@@ -236,22 +559,15 @@ func (m *Muxer) readLoop() {
 		if msg.IsResponse() {
 			protocolRole = ProtocolRoleInitiator
 		}
-		protocolRoles, ok := m.protocolReceivers[msg.GetProtocolId()]
-		if !ok {
-			// Try the "unknown protocol" receiver if we didn't find an explicit one
-			protocolRoles, ok = m.protocolReceivers[ProtocolUnknown]
-			if !ok {
-				m.sendError(fmt.Errorf("received message for unknown protocol ID %d", msg.GetProtocolId()))
-				return
-			}
-		}
-		recvChan := protocolRoles[protocolRole]
-		if recvChan == nil {
+		protocolReceiver := m.lookupReceiver(msg.GetProtocolId(), protocolRole)
+		if protocolReceiver == nil {
 			m.sendError(fmt.Errorf("received message for unknown protocol ID %d", msg.GetProtocolId()))
 			return
 		}
-		if recvChan != nil {
-			recvChan <- msg
+		protocolReceiver.metrics.record(msg)
+		if err := protocolReceiver.enqueue(msg); err != nil {
+			m.sendError(err)
+			return
 		}
 		// Wait until the muxer is started to continue
 		// We don't want to read more than one segment until the handshake is complete
@@ -266,3 +582,51 @@ func (m *Muxer) readLoop() {
 		}
 	}
 }
+
+// waitForDelivery waits until all received segments have been passed to the protocols, or the deadline passes
+func (m *Muxer) waitForDelivery(deadline time.Time) {
+	m.protocolMutex.RLock()
+	receivers := []*receiver{}
+	for _, protocolRoles := range m.protocolReceivers {
+		for _, protocolReceiver := range protocolRoles {
+			receivers = append(receivers, protocolReceiver)
+		}
+	}
+	m.protocolMutex.RUnlock()
+	for _, protocolReceiver := range receivers {
+		protocolReceiver.waitEmpty(m.doneChan, deadline)
+	}
+}
+
+// lookupReceiver returns the receiver registered for the provided protocol ID and role, falling back to the
+// "unknown protocol" receiver if there isn't an explicit one for the protocol ID
+func (m *Muxer) lookupReceiver(protocolId uint16, protocolRole ProtocolRole) *receiver {
+	m.protocolMutex.RLock()
+	defer m.protocolMutex.RUnlock()
+	protocolRoles, ok := m.protocolReceivers[protocolId]
+	if !ok {
+		protocolRoles = m.protocolReceivers[ProtocolUnknown]
+	}
+	return protocolRoles[protocolRole]
+}
+
+// segmentMetrics holds the segment and byte counters for a protocol ID and direction, with the label values
+// already set so that updating them is cheap
+type segmentMetrics struct {
+	segments metrics.Counter
+	bytes    metrics.Counter
+}
+
+func (m *Muxer) newSegmentMetrics(protocolId uint16, direction string) segmentMetrics {
+	protocolIdLabel := strconv.Itoa(int(protocolId))
+	return segmentMetrics{
+		segments: m.segmentsCounter.With(protocolIdLabel, direction),
+		bytes:    m.bytesCounter.With(protocolIdLabel, direction),
+	}
+}
+
+// record updates the counters for a segment sent or received
+func (s segmentMetrics) record(msg *Segment) {
+	s.segments.Add(1)
+	s.bytes.Add(float64(segmentHeaderLength + int(msg.PayloadLength)))
+}
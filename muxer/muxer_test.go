@@ -0,0 +1,304 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/muxer"
+)
+
+// newMuxerPair returns a started muxer for receiving and a muxer connected to it for sending
+func newMuxerPair(options ...muxer.MuxerOptionFunc) (*muxer.Muxer, *muxer.Muxer) {
+	recvConn, sendConn := net.Pipe()
+	recvMuxer := muxer.New(recvConn, options...)
+	recvMuxer.Start()
+	sendMuxer := muxer.New(sendConn)
+	sendMuxer.Start()
+	return recvMuxer, sendMuxer
+}
+
+func TestSlowReceiverDoesNotBlockOthers(t *testing.T) {
+	recvMuxer, sendMuxer := newMuxerPair()
+	defer recvMuxer.Stop()
+	defer sendMuxer.Stop()
+	// Nothing reads from the first protocol's receive channel
+	_, _, _ = recvMuxer.RegisterProtocol(1, muxer.ProtocolRoleResponder)
+	_, recvChan, _ := recvMuxer.RegisterProtocol(2, muxer.ProtocolRoleResponder)
+	for i := 0; i < 50; i++ {
+		if err := sendMuxer.Send(muxer.NewSegment(1, []byte{0x80}, false)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := sendMuxer.Send(muxer.NewSegment(2, []byte{0x81}, false)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case segment := <-recvChan:
+		if !bytes.Equal(segment.Payload, []byte{0x81}) {
+			t.Fatalf("did not receive expected payload: %x", segment.Payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive segment for second protocol")
+	}
+}
+
+func TestIngressLimitExceeded(t *testing.T) {
+	recvMuxer, sendMuxer := newMuxerPair(
+		muxer.WithIngressLimits(map[uint16]int{1: 100}),
+	)
+	defer recvMuxer.Stop()
+	defer sendMuxer.Stop()
+	_, _, _ = recvMuxer.RegisterProtocol(1, muxer.ProtocolRoleResponder)
+	// Nothing reads from the receive channel, so the third segment puts us over the limit
+	for i := 0; i < 3; i++ {
+		if err := sendMuxer.Send(muxer.NewSegment(1, make([]byte, 40), false)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	select {
+	case err := <-recvMuxer.ErrorChan():
+		var limitErr muxer.IngressLimitExceededError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("did not get expected error: %v", err)
+		}
+		if limitErr.ProtocolId != 1 || limitErr.Limit != 100 {
+			t.Fatalf("did not get expected error: %#v", limitErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not get expected error")
+	}
+}
+
+func TestIngressDefaultQueueLimit(t *testing.T) {
+	recvMuxer, sendMuxer := newMuxerPair()
+	defer recvMuxer.Stop()
+	defer sendMuxer.Stop()
+	// Nothing reads from the receive channel of the protocol without a specified limit
+	_, _, _ = recvMuxer.RegisterProtocol(1, muxer.ProtocolRoleResponder)
+	payload := make([]byte, muxer.SegmentMaxPayloadLength)
+	for i := 0; i <= muxer.DefaultIngressQueueLimit/len(payload); i++ {
+		if err := sendMuxer.Send(muxer.NewSegment(1, payload, false)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	select {
+	case err := <-recvMuxer.ErrorChan():
+		var limitErr muxer.IngressLimitExceededError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("did not get expected error: %v", err)
+		}
+		if limitErr.ProtocolId != 1 || limitErr.Limit != muxer.DefaultIngressQueueLimit {
+			t.Fatalf("did not get expected error: %#v", limitErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not get expected error")
+	}
+}
+
+func TestEgressInterleaving(t *testing.T) {
+	recvConn, sendConn := net.Pipe()
+	recvMuxer := muxer.New(recvConn)
+	recvMuxer.Start()
+	defer recvMuxer.Stop()
+	sendMuxer := muxer.New(sendConn, muxer.WithSDUSize(1000))
+	sendMuxer.Start()
+	defer sendMuxer.Stop()
+	// Catch all segments in the order that they were sent
+	_, recvChan, _ := recvMuxer.RegisterProtocol(muxer.ProtocolUnknown, muxer.ProtocolRoleResponder)
+	bigSendChan, _, _ := sendMuxer.RegisterProtocol(1, muxer.ProtocolRoleInitiator)
+	smallSendChan, _, _ := sendMuxer.RegisterProtocol(2, muxer.ProtocolRoleInitiator)
+	bigSendChan <- muxer.NewSegment(1, make([]byte, 20000), false)
+	bigSendChan <- muxer.NewSegment(1, make([]byte, 20000), false)
+	smallSendChan <- muxer.NewSegment(2, []byte{0x80}, false)
+	bigSegments := 0
+	smallSegmentIdx := -1
+	for i := 0; bigSegments < 40 || smallSegmentIdx < 0; i++ {
+		select {
+		case segment := <-recvChan:
+			if len(segment.Payload) > 1000 {
+				t.Fatalf("segment payload is larger than SDU size: %d", len(segment.Payload))
+			}
+			if segment.GetProtocolId() == 2 {
+				smallSegmentIdx = i
+			} else {
+				bigSegments++
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("did not receive all segments")
+		}
+	}
+	// The small segment should not have to wait for both big segments to be sent
+	if smallSegmentIdx >= 40 {
+		t.Fatalf("small segment was not interleaved with big segments, received at index %d", smallSegmentIdx)
+	}
+	// The stats are updated after the write completes, which can be after the segment is received
+	var stats []muxer.EgressQueueStats
+	for i := 0; i < 100; i++ {
+		stats = sendMuxer.EgressQueueStats()
+		if len(stats) == 2 && stats[0].SentSegments == 40 && stats[1].SentSegments == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("did not get expected number of queue stats: %#v", stats)
+	}
+	if stats[0].ProtocolId != 1 || stats[0].SentSegments != 40 || stats[0].SentBytes != 40000 || stats[0].QueuedBytes != 0 {
+		t.Fatalf("did not get expected stats for first protocol: %#v", stats[0])
+	}
+	if stats[1].ProtocolId != 2 || stats[1].SentSegments != 1 || stats[1].SentBytes != 1 {
+		t.Fatalf("did not get expected stats for second protocol: %#v", stats[1])
+	}
+}
+
+// Protocol IDs from the Ouroboros network specification
+const (
+	testProtocolIdChainSync = 2
+	testProtocolIdKeepAlive = 8
+)
+
+func TestTimingStats(t *testing.T) {
+	conn, peerConn := net.Pipe()
+	m := muxer.New(conn)
+	m.Start()
+	defer m.Stop()
+	_, _, _ = m.RegisterProtocol(testProtocolIdKeepAlive, muxer.ProtocolRoleInitiator)
+	// Read the request on the peer side
+	readErrChan := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(peerConn, make([]byte, 9))
+		readErrChan <- err
+	}()
+	if err := m.Send(muxer.NewSegment(testProtocolIdKeepAlive, []byte{0x80}, false)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := <-readErrChan; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Send responses with timestamps from a clock that runs twice as fast as ours
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+		segment := muxer.NewSegment(testProtocolIdKeepAlive, []byte{0x81}, true)
+		segment.Timestamp = uint32(2 * time.Since(start).Microseconds())
+		if err := binary.Write(peerConn, binary.BigEndian, segment.SegmentHeader); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := peerConn.Write(segment.Payload); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	stats := m.TimingStats()
+	// Only the first response follows a request
+	if stats.RTTSamples != 1 {
+		t.Fatalf("did not get expected number of RTT samples: %d", stats.RTTSamples)
+	}
+	if stats.RTT < 10*time.Millisecond || stats.RTT > time.Second || stats.SmoothedRTT != stats.RTT {
+		t.Fatalf("did not get expected RTT: %#v", stats)
+	}
+	if stats.ClockSamples != 10 {
+		t.Fatalf("did not get expected number of clock samples: %d", stats.ClockSamples)
+	}
+	if stats.ClockDriftPPM < 800000 || stats.ClockDriftPPM > 1200000 {
+		t.Fatalf("did not get expected clock drift: %f", stats.ClockDriftPPM)
+	}
+}
+
+func TestEgressQueueBoundedBySDUSize(t *testing.T) {
+	// Nothing reads from the other end of the pipe, so the first write blocks and segments back up in the queue
+	recvConn, sendConn := net.Pipe()
+	defer recvConn.Close()
+	sendMuxer := muxer.New(sendConn, muxer.WithSDUSize(1000))
+	sendMuxer.Start()
+	defer sendMuxer.Stop()
+	sendChan, _, _ := sendMuxer.RegisterProtocol(1, muxer.ProtocolRoleInitiator)
+	go func() {
+		for i := 0; i < 20; i++ {
+			select {
+			case sendChan <- muxer.NewSegment(1, make([]byte, 500), false):
+			case <-time.After(2 * time.Second):
+				return
+			}
+		}
+	}()
+	var stats []muxer.EgressQueueStats
+	for i := 0; i < 100; i++ {
+		stats = sendMuxer.EgressQueueStats()
+		if len(stats) == 1 && stats[0].QueuedBytes >= 2000 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give the sender a chance to queue more than it should
+	time.Sleep(100 * time.Millisecond)
+	stats = sendMuxer.EgressQueueStats()
+	if len(stats) != 1 {
+		t.Fatalf("did not get expected number of queue stats: %#v", stats)
+	}
+	if stats[0].QueuedBytes != 2000 {
+		t.Fatalf("did not get expected queued bytes: got %d, expected 2000", stats[0].QueuedBytes)
+	}
+}
+
+func TestTimingStatsPipelined(t *testing.T) {
+	conn, peerConn := net.Pipe()
+	m := muxer.New(conn)
+	m.Start()
+	defer m.Stop()
+	_, _, _ = m.RegisterProtocol(testProtocolIdChainSync, muxer.ProtocolRoleInitiator)
+	// Discard everything that we send on the peer side
+	go func() {
+		_, _ = io.Copy(io.Discard, peerConn)
+	}()
+	writeResponse := func() {
+		segment := muxer.NewSegment(testProtocolIdChainSync, []byte{0x81}, true)
+		if err := binary.Write(peerConn, binary.BigEndian, segment.SegmentHeader); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := peerConn.Write(segment.Payload); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// Pipeline several requests, and send another each time that a response arrives, like the chain-sync client.
+	// Each response is for a request sent well before the one that was just sent
+	for i := 0; i < 3; i++ {
+		if err := m.Send(muxer.NewSegment(testProtocolIdChainSync, []byte{0x80}, false)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		writeResponse()
+		if err := m.Send(muxer.NewSegment(testProtocolIdChainSync, []byte{0x80}, false)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		writeResponse()
+	}
+	// The responses can't be matched to their requests, so no RTT samples should have been taken
+	stats := m.TimingStats()
+	if stats.RTTSamples != 0 || stats.RTT != 0 {
+		t.Fatalf("did not expect any RTT samples: %#v", stats)
+	}
+	if stats.ClockSamples != 10 {
+		t.Fatalf("did not get expected number of clock samples: %d", stats.ClockSamples)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+// Capacities of the pooled buffers for received segment payloads. Each payload uses the smallest buffer that
+// it fits in, so that small segments don't tie up large buffers
+var payloadPoolSizes = [...]int{1024, 4096, 16384, SegmentMaxPayloadLength}
+
+// Maximum number of idle buffers kept for each size. This bounds the memory held by the pools, which are
+// shared by all muxers
+const payloadPoolMaxIdle = 32
+
+// Idle payload buffers for each size. We use bounded free lists rather than sync.Pool, since sync.Pool is
+// emptied by each garbage collection, and decoding blocks triggers garbage collections often enough that
+// buffers would rarely be reused. Pointers to slices are pooled, so that returning a buffer doesn't allocate
+var payloadPools = func() [len(payloadPoolSizes)]chan *[]byte {
+	var pools [len(payloadPoolSizes)]chan *[]byte
+	for idx := range pools {
+		pools[idx] = make(chan *[]byte, payloadPoolMaxIdle)
+	}
+	return pools
+}()
+
+// getPayloadBuffer returns a pooled buffer with the provided length
+func getPayloadBuffer(length int) *[]byte {
+	for idx, size := range payloadPoolSizes {
+		if length > size {
+			continue
+		}
+		select {
+		case buf := <-payloadPools[idx]:
+			*buf = (*buf)[:length]
+			return buf
+		default:
+		}
+		buf := make([]byte, length, size)
+		return &buf
+	}
+	// This can't happen, since the largest size is the maximum payload length
+	buf := make([]byte, length)
+	return &buf
+}
+
+// putPayloadBuffer returns a buffer obtained from getPayloadBuffer to the pool. The buffer is dropped if the
+// pool is full
+func putPayloadBuffer(buf *[]byte) {
+	for idx, size := range payloadPoolSizes {
+		if cap(*buf) != size {
+			continue
+		}
+		select {
+		case payloadPools[idx] <- buf:
+		default:
+		}
+		return
+	}
+}
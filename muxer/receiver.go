@@ -0,0 +1,143 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+import (
+	"sync"
+	"time"
+)
+
+// receiver holds the ingress queue for a registered protocol. Received segments are queued without blocking
+// the read loop and are passed to the protocol as it's ready for them, so that a slow protocol doesn't hold up
+// the others. The protocol's ingress limit caps the number of bytes that can be queued
+type receiver struct {
+	protocolId  uint16
+	limit       int
+	recvChan    chan *Segment
+	readyChan   chan struct{}
+	emptyChan   chan struct{}
+	mutex       sync.Mutex
+	queue       []*Segment
+	queuedBytes int
+	metrics     segmentMetrics
+}
+
+func newReceiver(protocolId uint16, limit int) *receiver {
+	return &receiver{
+		protocolId: protocolId,
+		limit:      limit,
+		recvChan:   make(chan *Segment),
+		readyChan:  make(chan struct{}, 1),
+		emptyChan:  make(chan struct{}, 1),
+	}
+}
+
+// enqueue adds a segment to the queue. An error is returned if the queued data exceeds the ingress limit
+func (r *receiver) enqueue(segment *Segment) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queue = append(r.queue, segment)
+	r.queuedBytes += len(segment.Payload)
+	if r.limit > 0 && r.queuedBytes > r.limit {
+		return IngressLimitExceededError{
+			ProtocolId: r.protocolId,
+			Limit:      r.limit,
+			Size:       r.queuedBytes,
+		}
+	}
+	// Wake up the delivery loop without blocking
+	select {
+	case r.readyChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the segment at the front of the queue, or nil if the queue is empty
+func (r *receiver) next() *Segment {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.queue) == 0 {
+		return nil
+	}
+	return r.queue[0]
+}
+
+// remove removes the segment at the front of the queue after it has been passed to the protocol. The protocol
+// may have already released the segment, so the caller provides its payload length
+func (r *receiver) remove(payloadLength int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queuedBytes -= payloadLength
+	r.queue[0] = nil
+	r.queue = r.queue[1:]
+	if len(r.queue) == 0 {
+		// Wake up anything waiting for the queue to empty without blocking
+		select {
+		case r.emptyChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// empty returns whether all queued segments have been passed to the protocol
+func (r *receiver) empty() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.queue) == 0
+}
+
+// waitEmpty waits until all queued segments have been passed to the protocol, the provided channel is closed,
+// or the deadline passes
+func (r *receiver) waitEmpty(doneChan chan bool, deadline time.Time) {
+	if r.empty() {
+		return
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for !r.empty() {
+		select {
+		case <-doneChan:
+			return
+		case <-timer.C:
+			return
+		case <-r.emptyChan:
+		}
+	}
+}
+
+// deliverLoop passes queued segments to the protocol until the provided channel is closed
+func (r *receiver) deliverLoop(doneChan chan bool) {
+	for {
+		select {
+		case <-doneChan:
+			return
+		case <-r.readyChan:
+		}
+		for {
+			segment := r.next()
+			if segment == nil {
+				break
+			}
+			payloadLength := len(segment.Payload)
+			select {
+			case <-doneChan:
+				return
+			case r.recvChan <- segment:
+			}
+			r.remove(payloadLength)
+		}
+	}
+}
@@ -40,13 +40,15 @@ type SegmentHeader struct {
 type Segment struct {
 	SegmentHeader
 	Payload []byte
+	// Pooled buffer that holds the payload of a received segment
+	payloadBuf *[]byte
 }
 
 // NewSegment returns a new Segment given a protocol ID, payload bytes, and whether the segment
 // is a response
 func NewSegment(protocolId uint16, payload []byte, isResponse bool) *Segment {
 	header := SegmentHeader{
-		Timestamp:  uint32(time.Now().UnixNano() & 0xffffffff),
+		Timestamp:  segmentTimestamp(time.Now()),
 		ProtocolId: protocolId,
 	}
 	if isResponse {
@@ -60,6 +62,18 @@ func NewSegment(protocolId uint16, payload []byte, isResponse bool) *Segment {
 	return segment
 }
 
+// Release returns the payload buffer of a received segment to the muxer's pool, so that it can be reused for
+// another segment. The payload must not be used after calling Release. Calling Release is optional, and it does
+// nothing for segments that weren't received by a muxer
+func (s *Segment) Release() {
+	if s.payloadBuf == nil {
+		return
+	}
+	putPayloadBuffer(s.payloadBuf)
+	s.payloadBuf = nil
+	s.Payload = nil
+}
+
 // IsRequest returns true if the segment is not a response
 func (s *SegmentHeader) IsRequest() bool {
 	return (s.ProtocolId & segmentProtocolIdResponseFlag) == 0
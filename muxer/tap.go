@@ -0,0 +1,46 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+import (
+	"time"
+)
+
+// TapDirection is an enum of the directions of the segments passed to a TapFunc
+type TapDirection uint
+
+const (
+	TapDirectionNone     TapDirection = 0 // Default (invalid) direction
+	TapDirectionInbound  TapDirection = 1 // Segment received from the peer
+	TapDirectionOutbound TapDirection = 2 // Segment sent to the peer
+)
+
+func (d TapDirection) String() string {
+	switch d {
+	case TapDirectionInbound:
+		return "inbound"
+	case TapDirectionOutbound:
+		return "outbound"
+	default:
+		return "none"
+	}
+}
+
+// TapFunc is a function that is called with each segment that the muxer sends or receives, along with the time
+// that it was sent or received. Sent segments are passed to the function just before they're written, so that
+// they're always seen before the peer's response. It's called synchronously from the muxer's send and receive
+// paths, so it should return quickly. It must not modify the segment, and it must not keep a reference to the
+// payload after returning, since the payload buffer can be reused
+type TapFunc func(TapDirection, time.Time, *Segment)
@@ -0,0 +1,175 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxer
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of recent RTT samples that the minimum RTT is taken from
+const timingRTTWindow = 16
+
+// Weight of a new RTT sample in the smoothed RTT. This is the same weight that TCP uses
+const timingRTTSmoothing = 0.125
+
+// Protocol IDs of the mini-protocols that RTT samples are taken from. Each request for these gets a response
+// before the next request is sent, so a response always belongs to the oldest pending request. That's not the
+// case for mini-protocols that pipeline requests, such as chain-sync. These are the handshake and keep-alive
+// protocol IDs from the Ouroboros network specification
+var timingRTTProtocolIds = map[uint16]bool{
+	0: true,
+	8: true,
+}
+
+// TimingStats contains the round-trip time and clock drift estimated from the segments exchanged with the peer.
+// No extra traffic is sent to produce these.
+//
+// RTT samples are the time between sending a request segment for the handshake or keep-alive mini-protocol and
+// receiving the next response segment for it. Other mini-protocols aren't used, since they can have multiple
+// requests in flight. Samples include the time that the peer takes to respond, so RTT is the minimum of the
+// recent samples rather than an average.
+//
+// The clock drift is how fast the peer's clock runs compared to ours, in parts per million. It's estimated from
+// the timestamps in the headers of the segments that the peer sends, and becomes more accurate the longer the
+// connection is up
+type TimingStats struct {
+	RTT           time.Duration // Minimum of the recent RTT samples
+	SmoothedRTT   time.Duration // Exponentially weighted moving average of the RTT samples
+	RTTSamples    uint64        // Number of RTT samples taken
+	ClockDriftPPM float64       // Rate that the peer's clock runs faster (positive) or slower (negative) than ours
+	ClockSamples  uint64        // Number of segment timestamps received from the peer
+}
+
+// timestampEpoch is the reference point for the segment timestamps that we send. Like the Haskell node, we use
+// the lower 32 bits of a monotonic clock in microseconds
+var timestampEpoch = time.Now()
+
+// segmentTimestamp returns the timestamp to put in the header of a segment sent at the provided time
+func segmentTimestamp(now time.Time) uint32 {
+	return uint32(now.Sub(timestampEpoch).Microseconds())
+}
+
+// timing tracks the segments exchanged with the peer to estimate the RTT and clock drift
+type timing struct {
+	mutex sync.Mutex
+	// Send time of the oldest request that hasn't seen a response yet, by protocol ID
+	pendingRequests map[uint16]time.Time
+	rttSamples      [timingRTTWindow]time.Duration
+	rttSampleCount  uint64
+	smoothedRTT     time.Duration
+	// Local receive time of the first segment from the peer, and the time elapsed on the peer's clock since then
+	clockStart       time.Time
+	lastTimestamp    uint32
+	peerElapsed      int64
+	clockSamples     uint64
+	meanLocal        float64
+	meanOffset       float64
+	localVariance    float64
+	offsetCovariance float64
+}
+
+func newTiming() *timing {
+	return &timing{
+		pendingRequests: make(map[uint16]time.Time),
+	}
+}
+
+// recordSent records a segment sent to the peer. Only requests are tracked, since we can't tell how long the
+// peer has been waiting for a response that we send
+func (t *timing) recordSent(header SegmentHeader, now time.Time) {
+	if !header.IsRequest() || !timingRTTProtocolIds[header.GetProtocolId()] {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	protocolId := header.GetProtocolId()
+	if _, ok := t.pendingRequests[protocolId]; !ok {
+		t.pendingRequests[protocolId] = now
+	}
+}
+
+// recordReceived records a segment received from the peer. A response to a pending request provides an RTT
+// sample, and the timestamp from every segment is used for the clock drift
+func (t *timing) recordReceived(header SegmentHeader, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if header.IsResponse() {
+		protocolId := header.GetProtocolId()
+		if sentTime, ok := t.pendingRequests[protocolId]; ok {
+			delete(t.pendingRequests, protocolId)
+			t.addRTTSample(now.Sub(sentTime))
+		}
+	}
+	t.addClockSample(header.Timestamp, now)
+}
+
+func (t *timing) addRTTSample(rtt time.Duration) {
+	t.rttSamples[t.rttSampleCount%timingRTTWindow] = rtt
+	t.rttSampleCount++
+	if t.rttSampleCount == 1 {
+		t.smoothedRTT = rtt
+	} else {
+		t.smoothedRTT += time.Duration(timingRTTSmoothing * float64(rtt-t.smoothedRTT))
+	}
+}
+
+// addClockSample adds a peer timestamp to the linear regression of the offset between the peer's clock and ours
+// against our clock. The slope of the regression is the clock drift. Variation in network delay adds noise to
+// the offset, but it averages out over time
+func (t *timing) addClockSample(timestamp uint32, now time.Time) {
+	if t.clockSamples == 0 {
+		t.clockStart = now
+	} else {
+		// Segment timestamps wrap around, so we track the time elapsed on the peer's clock from the difference
+		// between successive timestamps
+		t.peerElapsed += int64(int32(timestamp - t.lastTimestamp))
+	}
+	t.lastTimestamp = timestamp
+	t.clockSamples++
+	// Update the regression using Welford's method, which avoids the loss of precision that comes with
+	// accumulating sums of large values
+	local := float64(now.Sub(t.clockStart).Microseconds())
+	offset := float64(t.peerElapsed) - local
+	n := float64(t.clockSamples)
+	localDelta := local - t.meanLocal
+	t.meanLocal += localDelta / n
+	t.meanOffset += (offset - t.meanOffset) / n
+	t.localVariance += localDelta * (local - t.meanLocal)
+	t.offsetCovariance += localDelta * (offset - t.meanOffset)
+}
+
+func (t *timing) stats() TimingStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	ret := TimingStats{
+		SmoothedRTT:  t.smoothedRTT,
+		RTTSamples:   t.rttSampleCount,
+		ClockSamples: t.clockSamples,
+	}
+	windowSize := t.rttSampleCount
+	if windowSize > timingRTTWindow {
+		windowSize = timingRTTWindow
+	}
+	for i := uint64(0); i < windowSize; i++ {
+		if i == 0 || t.rttSamples[i] < ret.RTT {
+			ret.RTT = t.rttSamples[i]
+		}
+	}
+	if t.localVariance > 0 {
+		ret.ClockDriftPPM = t.offsetCovariance / t.localVariance * 1e6
+	}
+	return ret
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Values for the networkId field in the Shelley genesis
+const (
+	genesisNetworkIdMainnet = "Mainnet"
+	genesisNetworkIdTestnet = "Testnet"
+)
+
+// nodeConfig contains the fields that we care about from a cardano-node config file
+type nodeConfig struct {
+	ByronGenesisFile   string
+	ShelleyGenesisFile string
+}
+
+// byronGenesis contains the fields that we care about from a Byron genesis file
+type byronGenesis struct {
+	StartTime      int64 `json:"startTime"`
+	ProtocolConsts struct {
+		K             uint   `json:"k"`
+		ProtocolMagic uint32 `json:"protocolMagic"`
+	} `json:"protocolConsts"`
+}
+
+// shelleyGenesis contains the fields that we care about from a Shelley genesis file
+type shelleyGenesis struct {
+	NetworkMagic  uint32    `json:"networkMagic"`
+	NetworkId     string    `json:"networkId"`
+	SystemStart   time.Time `json:"systemStart"`
+	EpochLength   uint      `json:"epochLength"`
+	SlotLength    float64   `json:"slotLength"`
+	SecurityParam uint      `json:"securityParam"`
+}
+
+// topology contains the fields that we care about from a cardano-node topology file. Both the P2P and legacy
+// formats are supported
+type topology struct {
+	BootstrapPeers []topologyAccessPoint `json:"bootstrapPeers"`
+	PublicRoots    []struct {
+		AccessPoints []topologyAccessPoint `json:"accessPoints"`
+	} `json:"publicRoots"`
+	Producers []struct {
+		Addr string `json:"addr"`
+		Port uint   `json:"port"`
+	} `json:"Producers"`
+}
+
+type topologyAccessPoint struct {
+	Address string `json:"address"`
+	Port    uint   `json:"port"`
+}
+
+// NetworkFromNodeConfig builds a Network from a cardano-node config file and the Byron and Shelley genesis files
+// that it references. Genesis file paths are relative to the directory containing the config file. Bootstrap
+// peers are read from the topology file, if one is provided. The returned network can be passed to
+// RegisterNetwork to make it available to the lookup functions
+func NetworkFromNodeConfig(name string, configFile string, topologyFile string) (Network, error) {
+	var config nodeConfig
+	if err := readJsonFile(configFile, &config); err != nil {
+		return NetworkInvalid, err
+	}
+	if config.ShelleyGenesisFile == "" {
+		return NetworkInvalid, fmt.Errorf("node config %s does not specify ShelleyGenesisFile", configFile)
+	}
+	configDir := filepath.Dir(configFile)
+	byronGenesisFile := config.ByronGenesisFile
+	if byronGenesisFile != "" && !filepath.IsAbs(byronGenesisFile) {
+		byronGenesisFile = filepath.Join(configDir, byronGenesisFile)
+	}
+	shelleyGenesisFile := config.ShelleyGenesisFile
+	if !filepath.IsAbs(shelleyGenesisFile) {
+		shelleyGenesisFile = filepath.Join(configDir, shelleyGenesisFile)
+	}
+	network, err := NetworkFromGenesisFiles(name, byronGenesisFile, shelleyGenesisFile)
+	if err != nil {
+		return NetworkInvalid, err
+	}
+	if topologyFile != "" {
+		bootstrapPeers, err := readTopologyBootstrapPeers(topologyFile)
+		if err != nil {
+			return NetworkInvalid, err
+		}
+		network = network.WithBootstrapPeers(bootstrapPeers)
+		if len(bootstrapPeers) > 0 {
+			network.PublicRootAddress = bootstrapPeers[0].Address
+			network.PublicRootPort = bootstrapPeers[0].Port
+		}
+	}
+	return network, nil
+}
+
+// NetworkFromGenesisFiles builds a Network from the Byron and Shelley genesis files. The Byron genesis file is
+// optional, but the network magic in it must match the Shelley genesis when it is provided
+func NetworkFromGenesisFiles(name string, byronGenesisFile string, shelleyGenesisFile string) (Network, error) {
+	var shelley shelleyGenesis
+	if err := readJsonFile(shelleyGenesisFile, &shelley); err != nil {
+		return NetworkInvalid, err
+	}
+	if shelley.NetworkMagic == 0 {
+		return NetworkInvalid, fmt.Errorf("invalid Shelley genesis %s: networkMagic is not specified", shelleyGenesisFile)
+	}
+	network := Network{
+		Name:          name,
+		NetworkMagic:  shelley.NetworkMagic,
+		SystemStart:   shelley.SystemStart,
+		EpochLength:   shelley.EpochLength,
+		SlotLength:    time.Duration(shelley.SlotLength * float64(time.Second)),
+		SecurityParam: shelley.SecurityParam,
+	}
+	switch shelley.NetworkId {
+	case genesisNetworkIdMainnet:
+		network.NetworkId = 1
+	case genesisNetworkIdTestnet:
+		network.NetworkId = 0
+	default:
+		return NetworkInvalid, fmt.Errorf("invalid Shelley genesis %s: unknown networkId %q", shelleyGenesisFile, shelley.NetworkId)
+	}
+	if byronGenesisFile != "" {
+		var byron byronGenesis
+		if err := readJsonFile(byronGenesisFile, &byron); err != nil {
+			return NetworkInvalid, err
+		}
+		if byron.ProtocolConsts.ProtocolMagic != shelley.NetworkMagic {
+			return NetworkInvalid, fmt.Errorf(
+				"protocol magic in Byron genesis (%d) does not match network magic in Shelley genesis (%d)",
+				byron.ProtocolConsts.ProtocolMagic,
+				shelley.NetworkMagic,
+			)
+		}
+		// The Shelley genesis system start is normally the same, but the Byron genesis is authoritative
+		if byron.StartTime > 0 {
+			network.SystemStart = time.Unix(byron.StartTime, 0).UTC()
+		}
+		if network.SecurityParam == 0 {
+			network.SecurityParam = byron.ProtocolConsts.K
+		}
+	}
+	return network, nil
+}
+
+// readTopologyBootstrapPeers returns the bootstrap peers from a topology file. For topology files without
+// bootstrap peers, the public roots or the producers from the legacy format are used instead
+func readTopologyBootstrapPeers(topologyFile string) ([]NetworkBootstrapPeer, error) {
+	var tmpTopology topology
+	if err := readJsonFile(topologyFile, &tmpTopology); err != nil {
+		return nil, err
+	}
+	var ret []NetworkBootstrapPeer
+	accessPoints := tmpTopology.BootstrapPeers
+	if len(accessPoints) == 0 {
+		for _, publicRoot := range tmpTopology.PublicRoots {
+			accessPoints = append(accessPoints, publicRoot.AccessPoints...)
+		}
+	}
+	for _, accessPoint := range accessPoints {
+		ret = append(ret, NetworkBootstrapPeer{Address: accessPoint.Address, Port: accessPoint.Port})
+	}
+	if len(ret) == 0 {
+		for _, producer := range tmpTopology.Producers {
+			ret = append(ret, NetworkBootstrapPeer{Address: producer.Addr, Port: producer.Port})
+		}
+	}
+	return ret, nil
+}
+
+func readJsonFile(path string, dest interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return nil
+}
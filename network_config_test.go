@@ -0,0 +1,166 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+)
+
+const testNodeConfig = `{
+  "Protocol": "Cardano",
+  "ByronGenesisFile": "byron-genesis.json",
+  "ShelleyGenesisFile": "shelley-genesis.json",
+  "RequiresNetworkMagic": "RequiresMagic"
+}`
+
+const testByronGenesis = `{
+  "startTime": 1700000000,
+  "protocolConsts": {
+    "k": 10,
+    "protocolMagic": 42
+  }
+}`
+
+const testShelleyGenesis = `{
+  "networkMagic": 42,
+  "networkId": "Testnet",
+  "systemStart": "2023-11-14T22:13:20Z",
+  "epochLength": 500,
+  "slotLength": 0.2,
+  "securityParam": 10,
+  "activeSlotsCoeff": 0.1
+}`
+
+const testTopology = `{
+  "bootstrapPeers": [
+    {"address": "relay1.devnet.example", "port": 3001},
+    {"address": "relay2.devnet.example", "port": 3002}
+  ],
+  "localRoots": [],
+  "publicRoots": []
+}`
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write test file: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestNetworkFromNodeConfig(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.json":          testNodeConfig,
+		"byron-genesis.json":   testByronGenesis,
+		"shelley-genesis.json": testShelleyGenesis,
+		"topology.json":        testTopology,
+	})
+	network, err := ouroboros.NetworkFromNodeConfig(
+		"devnet",
+		filepath.Join(dir, "config.json"),
+		filepath.Join(dir, "topology.json"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := ouroboros.Network{
+		Name:              "devnet",
+		NetworkId:         0,
+		NetworkMagic:      42,
+		PublicRootAddress: "relay1.devnet.example",
+		PublicRootPort:    3001,
+		SystemStart:       time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC),
+		EpochLength:       500,
+		SlotLength:        200 * time.Millisecond,
+		SecurityParam:     10,
+	}.WithBootstrapPeers(
+		[]ouroboros.NetworkBootstrapPeer{
+			{Address: "relay1.devnet.example", Port: 3001},
+			{Address: "relay2.devnet.example", Port: 3002},
+		},
+	)
+	if !reflect.DeepEqual(network, expected) {
+		t.Fatalf("did not get expected network\n  got:    %#v\n  wanted: %#v", network, expected)
+	}
+	// Register the network and make sure that we can look it up. The network registry is global, so the network
+	// will already be registered when the test is run more than once
+	registeredNetwork := ouroboros.NetworkByName("devnet")
+	if registeredNetwork.Name != "devnet" {
+		registeredNetwork, err = ouroboros.RegisterNetwork(network)
+		if err != nil {
+			t.Fatalf("unexpected error registering network: %s", err)
+		}
+	}
+	// Networks with bootstrap peers must remain comparable
+	if ouroboros.NetworkByName("devnet") != registeredNetwork {
+		t.Fatalf("did not find registered network by name")
+	}
+	if ouroboros.NetworkByNetworkMagic(42) != registeredNetwork {
+		t.Fatalf("did not find registered network by network magic")
+	}
+	if ouroboros.NetworkById(registeredNetwork.Id) != registeredNetwork {
+		t.Fatalf("did not find registered network by ID")
+	}
+	if ouroboros.NetworkByName("does-not-exist") != ouroboros.NetworkInvalid {
+		t.Fatalf("did not get invalid network for unknown name")
+	}
+	// Registering the same network again should fail
+	if _, err := ouroboros.RegisterNetwork(network); err == nil {
+		t.Fatalf("did not get expected error when registering duplicate network")
+	}
+}
+
+func TestNetworkFromGenesisFilesMagicMismatch(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"byron-genesis.json":   `{"startTime": 1700000000, "protocolConsts": {"k": 10, "protocolMagic": 43}}`,
+		"shelley-genesis.json": testShelleyGenesis,
+	})
+	_, err := ouroboros.NetworkFromGenesisFiles(
+		"devnet-mismatch",
+		filepath.Join(dir, "byron-genesis.json"),
+		filepath.Join(dir, "shelley-genesis.json"),
+	)
+	if err == nil {
+		t.Fatalf("did not get expected error")
+	}
+}
+
+func TestNetworkFromNodeConfigLegacyTopology(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"config.json":          `{"ShelleyGenesisFile": "shelley-genesis.json"}`,
+		"shelley-genesis.json": testShelleyGenesis,
+		"topology.json":        `{"Producers": [{"addr": "127.0.0.1", "port": 3001, "valency": 1}]}`,
+	})
+	network, err := ouroboros.NetworkFromNodeConfig(
+		"devnet-legacy",
+		filepath.Join(dir, "config.json"),
+		filepath.Join(dir, "topology.json"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedPeers := []ouroboros.NetworkBootstrapPeer{{Address: "127.0.0.1", Port: 3001}}
+	if !reflect.DeepEqual(network.BootstrapPeers(), expectedPeers) {
+		t.Fatalf("did not get expected bootstrap peers: %#v", network.BootstrapPeers())
+	}
+}
@@ -14,10 +14,16 @@
 
 package ouroboros
 
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
 // Network definitions
 var (
 	NetworkTestnet = Network{Id: 0, Name: "testnet", NetworkMagic: 1097911063}
-	NetworkMainnet = Network{Id: 1, Name: "mainnet", NetworkMagic: 764824073, PublicRootAddress: "relays-new.cardano-mainnet.iohk.io", PublicRootPort: 3001}
+	NetworkMainnet = Network{Id: 1, Name: "mainnet", NetworkId: 1, NetworkMagic: 764824073, PublicRootAddress: "relays-new.cardano-mainnet.iohk.io", PublicRootPort: 3001}
 	NetworkPreprod = Network{Id: 2, Name: "preprod", NetworkMagic: 1, PublicRootAddress: "preprod-node.world.dev.cardano.org", PublicRootPort: 30000}
 	NetworkPreview = Network{Id: 3, Name: "preview", NetworkMagic: 2, PublicRootAddress: "preview-node.world.dev.cardano.org", PublicRootPort: 30002}
 
@@ -26,9 +32,12 @@ var (
 
 // List of valid networks for use in lookup functions
 var networks = []Network{NetworkTestnet, NetworkMainnet, NetworkPreprod, NetworkPreview}
+var networksMutex sync.RWMutex
 
-// NetworkByName returns a predefined network by name
+// NetworkByName returns a predefined or registered network by name
 func NetworkByName(name string) Network {
+	networksMutex.RLock()
+	defer networksMutex.RUnlock()
 	for _, network := range networks {
 		if network.Name == name {
 			return network
@@ -37,8 +46,10 @@ func NetworkByName(name string) Network {
 	return NetworkInvalid
 }
 
-// NetworkById returns a predefined network by ID
+// NetworkById returns a predefined or registered network by ID
 func NetworkById(id uint8) Network {
+	networksMutex.RLock()
+	defer networksMutex.RUnlock()
 	for _, network := range networks {
 		if network.Id == id {
 			return network
@@ -47,8 +58,10 @@ func NetworkById(id uint8) Network {
 	return NetworkInvalid
 }
 
-// NetworkByNetworkMagic returns a predefined network by network magic
+// NetworkByNetworkMagic returns a predefined or registered network by network magic
 func NetworkByNetworkMagic(networkMagic uint32) Network {
+	networksMutex.RLock()
+	defer networksMutex.RUnlock()
 	for _, network := range networks {
 		if network.NetworkMagic == networkMagic {
 			return network
@@ -57,15 +70,80 @@ func NetworkByNetworkMagic(networkMagic uint32) Network {
 	return NetworkInvalid
 }
 
+// RegisterNetwork adds a custom network for use in the lookup functions. The network is assigned the next
+// available ID, and the registered network is returned. An error is returned if the name or network magic
+// conflicts with an existing network
+func RegisterNetwork(network Network) (Network, error) {
+	if network.Name == "" || network.Name == NetworkInvalid.Name {
+		return NetworkInvalid, fmt.Errorf("invalid network name: %q", network.Name)
+	}
+	if network.NetworkMagic == 0 {
+		return NetworkInvalid, fmt.Errorf("invalid network magic value: %d", network.NetworkMagic)
+	}
+	networksMutex.Lock()
+	defer networksMutex.Unlock()
+	var maxId uint8
+	for _, tmpNetwork := range networks {
+		if tmpNetwork.Name == network.Name {
+			return NetworkInvalid, fmt.Errorf("network with name %q already exists", network.Name)
+		}
+		if tmpNetwork.NetworkMagic == network.NetworkMagic {
+			return NetworkInvalid, fmt.Errorf("network with network magic %d already exists: %s", network.NetworkMagic, tmpNetwork.Name)
+		}
+		if tmpNetwork.Id > maxId {
+			maxId = tmpNetwork.Id
+		}
+	}
+	if maxId == 255 {
+		return NetworkInvalid, fmt.Errorf("no network IDs available")
+	}
+	network.Id = maxId + 1
+	networks = append(networks, network)
+	return network, nil
+}
+
 // Network represents a Cardano network
 type Network struct {
-	Id                uint8
+	Id                uint8 // Identifier used by this library, which is not the same as NetworkId
 	Name              string
+	NetworkId         uint8 // Network ID used in addresses and transactions (0 for testnets, 1 for mainnet)
 	NetworkMagic      uint32
 	PublicRootAddress string
 	PublicRootPort    uint
+	// These values are only populated for networks loaded from genesis files
+	SystemStart   time.Time
+	EpochLength   uint
+	SlotLength    time.Duration
+	SecurityParam uint
+	// This is a pointer so that Network values remain comparable
+	bootstrapPeers *[]NetworkBootstrapPeer
+}
+
+// NetworkBootstrapPeer represents a peer to use when first connecting to a network
+type NetworkBootstrapPeer struct {
+	Address string
+	Port    uint
 }
 
 func (n Network) String() string {
 	return n.Name
 }
+
+// BootstrapPeers returns the peers to use when first connecting to the network. This is only populated for
+// networks loaded from a node config with a topology file, or set with WithBootstrapPeers
+func (n Network) BootstrapPeers() []NetworkBootstrapPeer {
+	if n.bootstrapPeers == nil {
+		return nil
+	}
+	ret := make([]NetworkBootstrapPeer, len(*n.bootstrapPeers))
+	copy(ret, *n.bootstrapPeers)
+	return ret
+}
+
+// WithBootstrapPeers returns a copy of the network with the specified bootstrap peers
+func (n Network) WithBootstrapPeers(peers []NetworkBootstrapPeer) Network {
+	tmpPeers := make([]NetworkBootstrapPeer, len(peers))
+	copy(tmpPeers, peers)
+	n.bootstrapPeers = &tmpPeers
+	return n
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockfetch_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/ledger"
+	"github.com/blinklabs-io/gouroboros/protocol/blockfetch"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
+)
+
+// benchBlockProvider is a BlockProvider that returns the same block a configurable number of times for any range
+type benchBlockProvider struct {
+	block *blockfetch.RawBlock
+	count int
+}
+
+func (p *benchBlockProvider) GetBlockRange(start common.Point, end common.Point) (blockfetch.BlockIterator, error) {
+	iter := &testBlockIterator{}
+	for i := 0; i < p.count; i++ {
+		iter.blocks = append(iter.blocks, p.block)
+	}
+	return iter, nil
+}
+
+func BenchmarkClientGetBlockRange(b *testing.B) {
+	testDefs := []struct {
+		name      string
+		blockType uint
+		hexFile   string
+	}{
+		{
+			name:      "Shelley",
+			blockType: ledger.BLOCK_TYPE_SHELLEY,
+			hexFile:   "shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex",
+		},
+		{
+			// This block is several hundred KB, so it spans a lot of muxer segments
+			name:      "ByronEBB",
+			blockType: ledger.BLOCK_TYPE_BYRON_EBB,
+			hexFile:   "byron_ebb_testnet_8f8602837f7c6f8b8867dd1cbc1842cf51a27eaed2c70ef48325d00f8efb320f.hex",
+		},
+	}
+	for _, testDef := range testDefs {
+		b.Run(testDef.name, func(b *testing.B) {
+			blockData, err := os.ReadFile("../chainsync/testdata/" + testDef.hexFile)
+			if err != nil {
+				b.Fatalf("failed to read test block: %s", err)
+			}
+			provider := &benchBlockProvider{
+				block: &blockfetch.RawBlock{
+					Type: testDef.blockType,
+					Cbor: test.DecodeHexString(string(blockData)),
+				},
+				count: b.N,
+			}
+			blockChan := make(chan struct{}, 10)
+			oClient, oServer := newBenchmarkConnections(b, provider, blockChan)
+			defer oServer.Close()
+			defer oClient.Close()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(provider.block.Cbor)))
+			b.ResetTimer()
+			if err := oClient.BlockFetch().Client.GetBlockRange(common.Point{}, common.Point{}); err != nil {
+				b.Fatalf("unexpected error requesting blocks: %s", err)
+			}
+			for i := 0; i < b.N; i++ {
+				<-blockChan
+			}
+		})
+	}
+}
+
+// newBenchmarkConnections returns a client and server Connection for the provided block provider. The client
+// sends to the provided channel for each block that it receives
+func newBenchmarkConnections(b *testing.B, provider blockfetch.BlockProvider, blockChan chan struct{}) (*ouroboros.Connection, *ouroboros.Connection) {
+	clientConn, serverConn := net.Pipe()
+	serverResultChan := make(chan error)
+	var oServer *ouroboros.Connection
+	go func() {
+		var err error
+		oServer, err = ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+			ouroboros.WithServer(true),
+			ouroboros.WithBlockFetchConfig(
+				blockfetch.NewConfig(
+					blockfetch.WithBlockProvider(provider),
+				),
+			),
+		)
+		serverResultChan <- err
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithNodeToNode(true),
+		ouroboros.WithBlockFetchConfig(
+			blockfetch.NewConfig(
+				blockfetch.WithBlockFunc(func(block ledger.Block) error {
+					blockChan <- struct{}{}
+					return nil
+				}),
+			),
+		),
+	)
+	if err != nil {
+		b.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := <-serverResultChan; err != nil {
+		b.Fatalf("unexpected error when creating server Connection object: %s", err)
+	}
+	go func() {
+		if err, ok := <-oClient.ErrorChan(); ok {
+			panic(fmt.Sprintf("unexpected Ouroboros client connection error: %s", err))
+		}
+	}()
+	return oClient, oServer
+}
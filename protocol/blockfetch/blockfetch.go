@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
 
 	"github.com/blinklabs-io/gouroboros/ledger"
 )
@@ -86,6 +87,7 @@ type BlockFetch struct {
 
 type Config struct {
 	BlockFunc         BlockFunc
+	BlockProvider     BlockProvider
 	BatchStartTimeout time.Duration
 	BlockTimeout      time.Duration
 }
@@ -93,6 +95,29 @@ type Config struct {
 // Callback function types
 type BlockFunc func(ledger.Block) error
 
+// BlockProvider provides access to block data when acting as a server
+type BlockProvider interface {
+	// GetBlockRange returns a BlockIterator for the blocks between the start and end points (inclusive).
+	// A nil BlockIterator indicates that the range is not available
+	GetBlockRange(start common.Point, end common.Point) (BlockIterator, error)
+}
+
+// BlockIterator delivers the blocks for a requested range to the server
+type BlockIterator interface {
+	// Next returns the next block in the range. A nil block indicates the end of the range
+	Next() (*RawBlock, error)
+	// Close releases any resources held by the iterator
+	Close() error
+}
+
+// RawBlock represents a single block returned by a BlockIterator
+type RawBlock struct {
+	// Type is the block type (ledger.BLOCK_TYPE_*)
+	Type uint
+	// Cbor is the raw CBOR of the full block
+	Cbor []byte
+}
+
 func New(protoOptions protocol.ProtocolOptions, cfg *Config) *BlockFetch {
 	b := &BlockFetch{
 		Client: NewClient(protoOptions, cfg),
@@ -121,6 +146,12 @@ func WithBlockFunc(blockFunc BlockFunc) BlockFetchOptionFunc {
 	}
 }
 
+func WithBlockProvider(blockProvider BlockProvider) BlockFetchOptionFunc {
+	return func(c *Config) {
+		c.BlockProvider = blockProvider
+	}
+}
+
 func WithBatchStartTimeout(timeout time.Duration) BlockFetchOptionFunc {
 	return func(c *Config) {
 		c.BatchStartTimeout = timeout
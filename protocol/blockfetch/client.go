@@ -15,6 +15,7 @@
 package blockfetch
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -61,6 +62,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          PROTOCOL_ID,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.messageHandler,
@@ -88,6 +92,13 @@ func (c *Client) Stop() error {
 
 // GetBlockRange starts an async process to fetch all blocks in the specified range (inclusive)
 func (c *Client) GetBlockRange(start common.Point, end common.Point) error {
+	return c.GetBlockRangeContext(context.Background(), start, end)
+}
+
+// GetBlockRangeContext is like GetBlockRange, but the provided context can be used to abort waiting
+// for the batch to start. The server cannot be told to stop, so any blocks that it still sends are
+// delivered via the Block callback function as usual
+func (c *Client) GetBlockRangeContext(ctx context.Context, start common.Point, end common.Point) error {
 	c.busyMutex.Lock()
 	c.blockUseCallback = true
 	msg := NewMsgRequestRange(start, end)
@@ -95,16 +106,27 @@ func (c *Client) GetBlockRange(start common.Point, end common.Point) error {
 		c.busyMutex.Unlock()
 		return err
 	}
-	err := <-c.startBatchResultChan
-	if err != nil {
-		c.busyMutex.Unlock()
-		return err
+	select {
+	case <-ctx.Done():
+		go c.discardBatch(false)
+		return ctx.Err()
+	case err := <-c.startBatchResultChan:
+		if err != nil {
+			c.busyMutex.Unlock()
+			return err
+		}
 	}
 	return nil
 }
 
 // GetBlock requests and returns a single block specified by the provided point
 func (c *Client) GetBlock(point common.Point) (ledger.Block, error) {
+	return c.GetBlockContext(context.Background(), point)
+}
+
+// GetBlockContext is like GetBlock, but the provided context can be used to abort waiting for the
+// block. A block that arrives after the context is done is discarded
+func (c *Client) GetBlockContext(ctx context.Context, point common.Point) (ledger.Block, error) {
 	c.busyMutex.Lock()
 	c.blockUseCallback = false
 	msg := NewMsgRequestRange(point, point)
@@ -112,16 +134,51 @@ func (c *Client) GetBlock(point common.Point) (ledger.Block, error) {
 		c.busyMutex.Unlock()
 		return nil, err
 	}
-	err := <-c.startBatchResultChan
-	if err != nil {
-		c.busyMutex.Unlock()
-		return nil, err
+	select {
+	case <-ctx.Done():
+		go c.discardBatch(false)
+		return nil, ctx.Err()
+	case err := <-c.startBatchResultChan:
+		if err != nil {
+			c.busyMutex.Unlock()
+			return nil, err
+		}
+	}
+	select {
+	case <-ctx.Done():
+		go c.discardBatch(true)
+		return nil, ctx.Err()
+	case block, ok := <-c.blockChan:
+		if !ok {
+			return nil, protocol.ProtocolShuttingDownError
+		}
+		return block, nil
+	}
+}
+
+// discardBatch consumes the remaining responses for an abandoned request. The busy lock is held
+// until the server has finished responding, so that the next request gets its own responses
+func (c *Client) discardBatch(batchStarted bool) {
+	if !batchStarted {
+		select {
+		case <-c.DoneChan():
+			return
+		case err := <-c.startBatchResultChan:
+			if err != nil {
+				c.busyMutex.Unlock()
+				return
+			}
+		}
+	}
+	// Blocks are delivered via the callback when requesting a range
+	if c.blockUseCallback {
+		return
 	}
-	block, ok := <-c.blockChan
-	if !ok {
-		return nil, protocol.ProtocolShuttingDownError
+	// The busy lock is released when the batch is done
+	select {
+	case <-c.DoneChan():
+	case <-c.blockChan:
 	}
-	return block, nil
 }
 
 func (c *Client) messageHandler(msg protocol.Message, isResponse bool) error {
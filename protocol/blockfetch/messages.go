@@ -16,8 +16,10 @@ package blockfetch
 
 import (
 	"fmt"
+
 	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
 )
 
 const (
@@ -57,11 +59,11 @@ func NewMsgFromCbor(msgType uint, data []byte) (protocol.Message, error) {
 
 type MsgRequestRange struct {
 	protocol.MessageBase
-	Start interface{} //point
-	End   interface{} //point
+	Start common.Point
+	End   common.Point
 }
 
-func NewMsgRequestRange(start interface{}, end interface{}) *MsgRequestRange {
+func NewMsgRequestRange(start common.Point, end common.Point) *MsgRequestRange {
 	m := &MsgRequestRange{
 		MessageBase: protocol.MessageBase{
 			MessageType: MESSAGE_TYPE_REQUEST_RANGE,
@@ -126,6 +128,19 @@ func NewMsgBlock(wrappedBlock []byte) *MsgBlock {
 	return m
 }
 
+// MarshalCBOR encodes the message with the block wrapped in a CBOR tag, which is what other
+// implementations expect. It is not intended to be called directly.
+func (m *MsgBlock) MarshalCBOR() ([]byte, error) {
+	tmp := []interface{}{
+		m.MessageType,
+		cbor.Tag{
+			Number:  24,
+			Content: m.WrappedBlock,
+		},
+	}
+	return cbor.Encode(tmp)
+}
+
 type MsgBatchDone struct {
 	protocol.MessageBase
 }
@@ -139,14 +154,6 @@ func NewMsgBatchDone() *MsgBatchDone {
 	return m
 }
 
-// TODO: use this above and expose it, or just remove it
-/*
-type point struct {
-	Slot uint64
-	Hash []byte
-}
-*/
-
 type WrappedBlock struct {
 	// Tells the CBOR decoder to convert to/from a struct and a CBOR array
 	_        struct{} `cbor:",toarray"`
@@ -18,6 +18,7 @@ import (
 	"encoding/hex"
 	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
 	"reflect"
 	"testing"
 )
@@ -28,8 +29,35 @@ type testDefinition struct {
 	MessageType uint
 }
 
-// TODO: implement tests for more messages
 var tests = []testDefinition{
+	{
+		CborHex: "830082186441ab8218c841cd",
+		Message: NewMsgRequestRange(
+			common.NewPoint(100, []byte{0xab}),
+			common.NewPoint(200, []byte{0xcd}),
+		),
+		MessageType: MESSAGE_TYPE_REQUEST_RANGE,
+	},
+	{
+		CborHex:     "8101",
+		Message:     NewMsgClientDone(),
+		MessageType: MESSAGE_TYPE_CLIENT_DONE,
+	},
+	{
+		CborHex:     "8102",
+		Message:     NewMsgStartBatch(),
+		MessageType: MESSAGE_TYPE_START_BATCH,
+	},
+	{
+		CborHex:     "8103",
+		Message:     NewMsgNoBlocks(),
+		MessageType: MESSAGE_TYPE_NO_BLOCKS,
+	},
+	{
+		CborHex:     "8204d818448206410a",
+		Message:     NewMsgBlock([]byte{0x82, 0x06, 0x41, 0x0a}),
+		MessageType: MESSAGE_TYPE_BLOCK,
+	},
 	{
 		CborHex:     "8105",
 		Message:     NewMsgBatchDone(),
@@ -16,6 +16,8 @@ package blockfetch
 
 import (
 	"fmt"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/gouroboros/protocol"
 )
 
@@ -33,6 +35,9 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 		ProtocolId:          PROTOCOL_ID,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleServer,
 		MessageHandlerFunc:  s.messageHandler,
@@ -46,10 +51,68 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 
 func (s *Server) messageHandler(msg protocol.Message, isResponse bool) error {
 	var err error
-	// TODO: add cases for messages from client
 	switch msg.Type() {
+	case MESSAGE_TYPE_REQUEST_RANGE:
+		err = s.handleRequestRange(msg)
+	case MESSAGE_TYPE_CLIENT_DONE:
+		err = s.handleClientDone()
 	default:
 		err = fmt.Errorf("%s: received unexpected message type %d", PROTOCOL_NAME, msg.Type())
 	}
 	return err
 }
+
+func (s *Server) handleRequestRange(msgGeneric protocol.Message) error {
+	if s.config == nil || s.config.BlockProvider == nil {
+		return fmt.Errorf("received block-fetch RequestRange message but no block provider is defined")
+	}
+	msg := msgGeneric.(*MsgRequestRange)
+	iter, err := s.config.BlockProvider.GetBlockRange(msg.Start, msg.End)
+	if err != nil {
+		return err
+	}
+	if iter == nil {
+		msgNoBlocks := NewMsgNoBlocks()
+		return s.SendMessage(msgNoBlocks)
+	}
+	defer func() {
+		_ = iter.Close()
+	}()
+	// We need the first block before we can decide whether to start a batch
+	block, err := iter.Next()
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		msgNoBlocks := NewMsgNoBlocks()
+		return s.SendMessage(msgNoBlocks)
+	}
+	msgStartBatch := NewMsgStartBatch()
+	if err := s.SendMessage(msgStartBatch); err != nil {
+		return err
+	}
+	for block != nil {
+		wrappedBlock := WrappedBlock{
+			Type:     block.Type,
+			RawBlock: block.Cbor,
+		}
+		wrappedBlockCbor, err := cbor.Encode(&wrappedBlock)
+		if err != nil {
+			return fmt.Errorf("%s: encode error: %s", PROTOCOL_NAME, err)
+		}
+		msgBlock := NewMsgBlock(wrappedBlockCbor)
+		if err := s.SendMessage(msgBlock); err != nil {
+			return err
+		}
+		block, err = iter.Next()
+		if err != nil {
+			return err
+		}
+	}
+	msgBatchDone := NewMsgBatchDone()
+	return s.SendMessage(msgBatchDone)
+}
+
+func (s *Server) handleClientDone() error {
+	return nil
+}
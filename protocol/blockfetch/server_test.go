@@ -0,0 +1,157 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blockfetch_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/ledger"
+	"github.com/blinklabs-io/gouroboros/protocol/blockfetch"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
+)
+
+const testBlockHash = "02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f"
+
+// testBlockProvider is a simple in-memory BlockProvider with a single block
+type testBlockProvider struct {
+	point common.Point
+	block []byte
+}
+
+func (p *testBlockProvider) GetBlockRange(start common.Point, end common.Point) (blockfetch.BlockIterator, error) {
+	if start.Slot != p.point.Slot || end.Slot != p.point.Slot {
+		return nil, nil
+	}
+	return &testBlockIterator{
+		blocks: []*blockfetch.RawBlock{
+			{
+				Type: ledger.BLOCK_TYPE_SHELLEY,
+				Cbor: p.block,
+			},
+		},
+	}, nil
+}
+
+type testBlockIterator struct {
+	blocks []*blockfetch.RawBlock
+}
+
+func (i *testBlockIterator) Next() (*blockfetch.RawBlock, error) {
+	if len(i.blocks) == 0 {
+		return nil, nil
+	}
+	block := i.blocks[0]
+	i.blocks = i.blocks[1:]
+	return block, nil
+}
+
+func (i *testBlockIterator) Close() error {
+	return nil
+}
+
+func TestServerGetBlock(t *testing.T) {
+	blockData, err := os.ReadFile(fmt.Sprintf("../chainsync/testdata/shelley_block_testnet_%s.hex", testBlockHash))
+	if err != nil {
+		t.Fatalf("failed to read test block: %s", err)
+	}
+	provider := &testBlockProvider{
+		point: common.NewPoint(55770176, test.DecodeHexString(testBlockHash)),
+		block: test.DecodeHexString(string(blockData)),
+	}
+	clientConn, serverConn := net.Pipe()
+	// Start server side of the connection
+	serverResultChan := make(chan error)
+	var oServer *ouroboros.Connection
+	go func() {
+		var err error
+		oServer, err = ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+			ouroboros.WithServer(true),
+			ouroboros.WithBlockFetchConfig(
+				blockfetch.NewConfig(
+					blockfetch.WithBlockProvider(provider),
+				),
+			),
+		)
+		serverResultChan <- err
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithNodeToNode(true),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := <-serverResultChan; err != nil {
+		t.Fatalf("unexpected error when creating server Connection object: %s", err)
+	}
+	// Async error handler
+	go func() {
+		err, ok := <-oServer.ErrorChan()
+		if !ok {
+			return
+		}
+		// The server will see an EOF when we close the client connection
+		if err == io.EOF {
+			return
+		}
+		// We can't call t.Fatalf() from a different Goroutine, so we panic instead
+		panic(fmt.Sprintf("unexpected Ouroboros server connection error: %s", err))
+	}()
+	// Fetch block that the server has
+	block, err := oClient.BlockFetch().Client.GetBlock(provider.point)
+	if err != nil {
+		t.Fatalf("unexpected error fetching block: %s", err)
+	}
+	if block.Hash() != testBlockHash {
+		t.Fatalf("did not get expected block hash: got %s, wanted %s", block.Hash(), testBlockHash)
+	}
+	// Abandon a request, and make sure that the following request gets its own block
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := oClient.BlockFetch().Client.GetBlockContext(ctx, provider.point); !errors.Is(err, context.Canceled) {
+		t.Fatalf("did not get expected error: got %v, wanted %s", err, context.Canceled)
+	}
+	block, err = oClient.BlockFetch().Client.GetBlock(provider.point)
+	if err != nil {
+		t.Fatalf("unexpected error fetching block after cancelled request: %s", err)
+	}
+	if block.Hash() != testBlockHash {
+		t.Fatalf("did not get expected block hash: got %s, wanted %s", block.Hash(), testBlockHash)
+	}
+	// Fetch block that the server doesn't have
+	if _, err := oClient.BlockFetch().Client.GetBlock(common.NewPoint(1234, []byte{0xab})); err == nil {
+		t.Fatalf("did not get expected error fetching unknown block")
+	}
+	// Close Ouroboros connections
+	if err := oClient.Close(); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	if err := oServer.Close(); err != nil {
+		t.Fatalf("unexpected error when closing server Connection object: %s", err)
+	}
+}
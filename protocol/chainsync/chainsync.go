@@ -117,12 +117,48 @@ type Config struct {
 	IntersectTimeout time.Duration
 	BlockTimeout     time.Duration
 	PipelineLimit    int
+	ChainProvider    ChainProvider
 }
 
 // Callback function types
 type RollBackwardFunc func(common.Point, Tip) error
 type RollForwardFunc func(uint, interface{}, Tip) error
 
+// ChainProvider provides access to chain data when acting as a server
+type ChainProvider interface {
+	// Tip returns the current chain tip
+	Tip() (Tip, error)
+	// FindIntersect returns the first of the provided points that is present on the chain. The bool
+	// return value indicates whether an intersection was found
+	FindIntersect(points []common.Point) (common.Point, bool, error)
+	// NewIterator returns a ChainIterator that delivers chain updates following the provided point
+	NewIterator(start common.Point) (ChainIterator, error)
+}
+
+// ChainIterator delivers chain updates from a ChainProvider to the server
+type ChainIterator interface {
+	// Next returns the next chain update. When blocking is false, a nil update is returned if no update
+	// is immediately available. When blocking is true, the call waits until an update is available or
+	// the iterator is closed
+	Next(blocking bool) (*ChainUpdate, error)
+	// Close releases any resources held by the iterator and unblocks any pending calls to Next
+	Close() error
+}
+
+// ChainUpdate represents a single chain update returned by a ChainIterator
+type ChainUpdate struct {
+	// Rollback indicates that the chain was rolled back to Point
+	Rollback bool
+	// Point is the rollback point or the point of the provided block
+	Point common.Point
+	// BlockType is the block type (ledger.BLOCK_TYPE_*) of the provided block
+	BlockType uint
+	// BlockCbor is the raw CBOR of the full block
+	BlockCbor []byte
+	// Tip is the chain tip at the time of the update
+	Tip Tip
+}
+
 // New returns a new ChainSync object
 func New(protoOptions protocol.ProtocolOptions, cfg *Config) *ChainSync {
 	c := &ChainSync{
@@ -181,6 +217,13 @@ func WithBlockTimeout(timeout time.Duration) ChainSyncOptionFunc {
 	}
 }
 
+// WithChainProvider specifies the source of chain data when acting as a server
+func WithChainProvider(chainProvider ChainProvider) ChainSyncOptionFunc {
+	return func(c *Config) {
+		c.ChainProvider = chainProvider
+	}
+}
+
 // WithPipelineLimit specifies the maximum number of block requests to pipeline
 func WithPipelineLimit(limit int) ChainSyncOptionFunc {
 	return func(c *Config) {
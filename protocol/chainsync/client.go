@@ -15,6 +15,7 @@
 package chainsync
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"sync"
@@ -36,6 +37,10 @@ type Client struct {
 	wantFirstBlock        bool
 	firstBlockChan        chan common.Point
 	onceStop              sync.Once
+	syncDoneChan          chan struct{}
+	stopSync              bool
+	pendingMutex          sync.Mutex
+	pendingRequests       int
 }
 
 // NewClient returns a new ChainSync client object
@@ -77,6 +82,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.messageHandler,
@@ -115,11 +123,26 @@ func (c *Client) messageHandler(msg protocol.Message, isResponse bool) error {
 	return err
 }
 
-// Stop transitions the protocol to the Done state. No more protocol operations will be possible afterward
+// Stop transitions the protocol to the Done state. No more protocol operations will be possible afterward.
+// If a sync is active, it's stopped first. The Done message can't be sent until the server has replied to all
+// outstanding block requests, which may mean waiting for new blocks when at the chain tip
 func (c *Client) Stop() error {
 	var err error
 	c.onceStop.Do(func() {
 		c.busyMutex.Lock()
+		if c.syncDoneChan != nil {
+			// Stop requesting blocks and wait for the replies to the requests that are already in flight
+			c.stopSync = true
+			syncDoneChan := c.syncDoneChan
+			c.busyMutex.Unlock()
+			select {
+			case <-c.DoneChan():
+				err = fmt.Errorf("%s: protocol is shutting down", ProtocolName)
+				return
+			case <-syncDoneChan:
+			}
+			c.busyMutex.Lock()
+		}
 		defer c.busyMutex.Unlock()
 		msg := NewMsgDone()
 		if err = c.SendMessage(msg); err != nil {
@@ -131,17 +154,30 @@ func (c *Client) Stop() error {
 
 // GetCurrentTip returns the current chain tip
 func (c *Client) GetCurrentTip() (*Tip, error) {
+	return c.GetCurrentTipContext(context.Background())
+}
+
+// GetCurrentTipContext is like GetCurrentTip, but the provided context can be used to abort waiting for the response
+func (c *Client) GetCurrentTipContext(ctx context.Context) (*Tip, error) {
 	c.busyMutex.Lock()
-	defer c.busyMutex.Unlock()
 	c.wantCurrentTip = true
 	msg := NewMsgFindIntersect([]common.Point{})
 	if err := c.SendMessage(msg); err != nil {
+		c.wantCurrentTip = false
+		c.busyMutex.Unlock()
 		return nil, err
 	}
-	tip := <-c.currentTipChan
+	var tip Tip
+	select {
+	case <-ctx.Done():
+		go c.discardIntersectResult()
+		return nil, ctx.Err()
+	case tip = <-c.currentTipChan:
+	}
 	// Clear out intersect result channel to prevent blocking
 	<-c.intersectResultChan
 	c.wantCurrentTip = false
+	c.busyMutex.Unlock()
 	return &tip, nil
 }
 
@@ -167,8 +203,7 @@ func (c *Client) GetAvailableBlockRange(intersectPoints []common.Point) (common.
 	}
 	c.wantCurrentTip = false
 	// Request the next block. This should result in a rollback
-	msgRequestNext := NewMsgRequestNext()
-	if err := c.SendMessage(msgRequestNext); err != nil {
+	if err := c.requestNext(); err != nil {
 		return start, end, err
 	}
 	for {
@@ -178,8 +213,7 @@ func (c *Client) GetAvailableBlockRange(intersectPoints []common.Point) (common.
 			c.wantFirstBlock = false
 		case <-c.readyForNextBlockChan:
 			// Request the next block
-			msg := NewMsgRequestNext()
-			if err := c.SendMessage(msg); err != nil {
+			if err := c.requestNext(); err != nil {
 				return start, end, err
 			}
 		}
@@ -193,43 +227,114 @@ func (c *Client) GetAvailableBlockRange(intersectPoints []common.Point) (common.
 // Sync begins a chain-sync operation using the provided intersect point(s). Incoming blocks will be delivered
 // via the RollForward callback function specified in the protocol config
 func (c *Client) Sync(intersectPoints []common.Point) error {
+	return c.SyncContext(context.Background(), intersectPoints)
+}
+
+// SyncContext is like Sync, but the provided context can be used to abort waiting for the intersect result.
+// The context is not used after the sync has started. Use StopSyncProcessError from a callback function to stop
+// an active sync
+func (c *Client) SyncContext(ctx context.Context, intersectPoints []common.Point) error {
 	c.busyMutex.Lock()
-	defer c.busyMutex.Unlock()
 	msg := NewMsgFindIntersect(intersectPoints)
 	if err := c.SendMessage(msg); err != nil {
+		c.busyMutex.Unlock()
 		return err
 	}
-	if err := <-c.intersectResultChan; err != nil {
-		return err
+	select {
+	case <-ctx.Done():
+		go c.discardIntersectResult()
+		return ctx.Err()
+	case err := <-c.intersectResultChan:
+		if err != nil {
+			c.busyMutex.Unlock()
+			return err
+		}
 	}
+	defer c.busyMutex.Unlock()
 	// Pipeline the initial block requests to speed things up a bit
 	// Using a value higher than 10 seems to cause problems with NtN
 	for i := 0; i <= c.config.PipelineLimit; i++ {
-		msg := NewMsgRequestNext()
-		if err := c.SendMessage(msg); err != nil {
+		if err := c.requestNext(); err != nil {
 			return err
 		}
 	}
-	go c.syncLoop()
+	c.stopSync = false
+	c.syncDoneChan = make(chan struct{})
+	go c.syncLoop(c.syncDoneChan)
 	return nil
 }
 
-func (c *Client) syncLoop() {
+// requestNext sends a RequestNext message and records that a reply is outstanding
+func (c *Client) requestNext() error {
+	c.pendingMutex.Lock()
+	c.pendingRequests++
+	c.pendingMutex.Unlock()
+	msg := NewMsgRequestNext()
+	return c.SendMessage(msg)
+}
+
+// replyReceived records the reply to a RequestNext message
+func (c *Client) replyReceived() {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	if c.pendingRequests > 0 {
+		c.pendingRequests--
+	}
+}
+
+// hasPendingRequests returns whether there are RequestNext messages that haven't been replied to
+func (c *Client) hasPendingRequests() bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	return c.pendingRequests > 0
+}
+
+// discardIntersectResult consumes the response for an abandoned FindIntersect request. The busy lock is held
+// until the response arrives, so that the next request gets its own response
+func (c *Client) discardIntersectResult() {
+	defer c.busyMutex.Unlock()
+	if c.wantCurrentTip {
+		select {
+		case <-c.DoneChan():
+			return
+		case <-c.currentTipChan:
+		}
+		c.wantCurrentTip = false
+	}
+	select {
+	case <-c.DoneChan():
+	case <-c.intersectResultChan:
+	}
+}
+
+func (c *Client) syncLoop(syncDoneChan chan struct{}) {
+	defer close(syncDoneChan)
 	for {
 		// Wait for a block to be received
-		if ready, ok := <-c.readyForNextBlockChan; !ok {
+		ready, ok := <-c.readyForNextBlockChan
+		if !ok {
 			// Channel is closed, which means we're shutting down
 			return
-		} else if !ready {
-			// Sync was cancelled
-			return
 		}
 		c.busyMutex.Lock()
+		if !ready {
+			// Sync was cancelled
+			c.stopSync = true
+		}
+		if c.stopSync {
+			// We keep consuming the replies to any pipelined requests, but don't request any more blocks
+			pending := c.hasPendingRequests()
+			c.busyMutex.Unlock()
+			if !pending {
+				return
+			}
+			continue
+		}
 		// Request the next block
 		// In practice we already have multiple block requests pipelined
 		// and this just adds another one to the pile
-		msg := NewMsgRequestNext()
-		if err := c.SendMessage(msg); err != nil {
+		if err := c.requestNext(); err != nil {
+			c.busyMutex.Unlock()
 			c.SendError(err)
 			return
 		}
@@ -242,6 +347,7 @@ func (c *Client) handleAwaitReply() error {
 }
 
 func (c *Client) handleRollForward(msgGeneric protocol.Message) error {
+	c.replyReceived()
 	if c.config.RollForwardFunc == nil && !c.wantFirstBlock {
 		return fmt.Errorf("received chain-sync RollForward message but no callback function is defined")
 	}
@@ -319,6 +425,7 @@ func (c *Client) handleRollForward(msgGeneric protocol.Message) error {
 }
 
 func (c *Client) handleRollBackward(msgGeneric protocol.Message) error {
+	c.replyReceived()
 	if !c.wantFirstBlock {
 		if c.config.RollBackwardFunc == nil {
 			return fmt.Errorf("received chain-sync RollBackward message but no callback function is defined")
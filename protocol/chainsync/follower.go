@@ -0,0 +1,229 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chainsync
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/blinklabs-io/gouroboros/protocol/common"
+)
+
+// Follower wraps the RollForward and RollBackward callback functions and records the points of the blocks that
+// it has delivered. This allows a sync to be resumed on a new connection without duplicate or missing blocks.
+//
+// The Follower is shared between connections. Use Config() to build the chain-sync config for each connection,
+// and call Sync() with the chain-sync client once the connection is established. When used with
+// ouroboros.ConnectionManager, this is generally done from the connected callback function
+type Follower struct {
+	mutex            sync.Mutex
+	points           []common.Point
+	maxPoints        int
+	fallbackPoint    common.Point
+	resuming         bool
+	rollForwardFunc  RollForwardFunc
+	rollBackwardFunc RollBackwardFunc
+}
+
+// FollowerOptionFunc represents a function used to modify the Follower config
+type FollowerOptionFunc func(*Follower)
+
+// NewFollower returns a new Follower with the provided options
+func NewFollower(options ...FollowerOptionFunc) *Follower {
+	f := &Follower{
+		maxPoints:     10,
+		fallbackPoint: common.NewPointOrigin(),
+	}
+	// Apply provided options functions
+	for _, option := range options {
+		option(f)
+	}
+	return f
+}
+
+// WithFollowerStartPoints specifies the points to start syncing from, oldest first. This is generally the
+// result of Points() from a previous run
+func WithFollowerStartPoints(points []common.Point) FollowerOptionFunc {
+	return func(f *Follower) {
+		f.points = append([]common.Point{}, points...)
+	}
+}
+
+// WithFollowerMaxPoints specifies how many of the most recently delivered points to keep for finding an
+// intersection on resume
+func WithFollowerMaxPoints(maxPoints int) FollowerOptionFunc {
+	return func(f *Follower) {
+		f.maxPoints = maxPoints
+	}
+}
+
+// WithFollowerFallbackPoint specifies the point to sync from when no points have been recorded, or when none of
+// the recorded points are still on the chain. This is generally a checkpoint that is known to be stable. The
+// default is the origin
+func WithFollowerFallbackPoint(point common.Point) FollowerOptionFunc {
+	return func(f *Follower) {
+		f.fallbackPoint = point
+	}
+}
+
+// WithFollowerRollForwardFunc specifies the RollForward callback function
+func WithFollowerRollForwardFunc(rollForwardFunc RollForwardFunc) FollowerOptionFunc {
+	return func(f *Follower) {
+		f.rollForwardFunc = rollForwardFunc
+	}
+}
+
+// WithFollowerRollBackwardFunc specifies the RollBackward callback function
+func WithFollowerRollBackwardFunc(rollBackwardFunc RollBackwardFunc) FollowerOptionFunc {
+	return func(f *Follower) {
+		f.rollBackwardFunc = rollBackwardFunc
+	}
+}
+
+// Config returns a new ChainSync config that uses the Follower callback functions, with the provided options
+// applied
+func (f *Follower) Config(options ...ChainSyncOptionFunc) Config {
+	options = append(
+		options,
+		WithRollForwardFunc(f.handleRollForward),
+		WithRollBackwardFunc(f.handleRollBackward),
+	)
+	return NewConfig(options...)
+}
+
+// Sync starts a chain-sync operation using the provided client, picking up after the last delivered point.
+// If no points have been recorded, the sync starts at the fallback point. If none of the recorded points are
+// still on the chain, they are discarded and the sync starts over at the fallback point, which results in a
+// call to the RollBackward callback function
+func (f *Follower) Sync(client *Client) error {
+	f.mutex.Lock()
+	f.resuming = true
+	intersectPoints := f.intersectPoints()
+	hasPoints := len(f.points) > 0
+	f.mutex.Unlock()
+	err := client.Sync(intersectPoints)
+	var notFoundErr IntersectNotFoundError
+	if !hasPoints || !errors.As(err, &notFoundErr) {
+		return err
+	}
+	f.mutex.Lock()
+	f.points = nil
+	intersectPoints = f.intersectPoints()
+	f.mutex.Unlock()
+	return client.Sync(intersectPoints)
+}
+
+// Points returns the recorded points, oldest first. These can be persisted and provided to a new Follower with
+// WithFollowerStartPoints. Points can be called from the callback functions, but a point is only recorded once
+// the callback for it returns, so the result doesn't include the block or rollback currently being delivered
+func (f *Follower) Points() []common.Point {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]common.Point{}, f.points...)
+}
+
+// intersectPoints returns the recorded points, newest first, for use with FindIntersect
+func (f *Follower) intersectPoints() []common.Point {
+	if len(f.points) == 0 {
+		return []common.Point{f.fallbackPoint}
+	}
+	ret := make([]common.Point, 0, len(f.points))
+	for i := len(f.points) - 1; i >= 0; i-- {
+		ret = append(ret, f.points[i])
+	}
+	return ret
+}
+
+func (f *Follower) handleRollForward(blockType uint, blockData interface{}, tip Tip) error {
+	f.mutex.Lock()
+	f.resuming = false
+	f.mutex.Unlock()
+	block, ok := blockData.(interface {
+		Hash() string
+		SlotNumber() uint64
+	})
+	if !ok {
+		return fmt.Errorf("%s: unexpected block data type: %T", ProtocolName, blockData)
+	}
+	blockHash, err := hex.DecodeString(block.Hash())
+	if err != nil {
+		return err
+	}
+	if f.rollForwardFunc != nil {
+		// Call the user callback function. We don't hold the lock, so that the callback can call Points()
+		err = f.rollForwardFunc(blockType, blockData, tip)
+		if err != nil && err != StopSyncProcessError {
+			// The block was not delivered, so we don't record it
+			return err
+		}
+	}
+	f.mutex.Lock()
+	f.addPoint(common.NewPoint(block.SlotNumber(), blockHash))
+	f.mutex.Unlock()
+	return err
+}
+
+func (f *Follower) handleRollBackward(point common.Point, tip Tip) error {
+	f.mutex.Lock()
+	resuming := f.resuming
+	f.resuming = false
+	// The server rolls back to the intersect point when starting a sync. We don't pass this along if it's
+	// the last point that we delivered, since nothing has changed from the caller's point of view
+	if resuming && len(f.points) > 0 {
+		lastPoint := f.points[len(f.points)-1]
+		if lastPoint.Slot == point.Slot && bytes.Equal(lastPoint.Hash, point.Hash) {
+			f.mutex.Unlock()
+			return nil
+		}
+	}
+	f.mutex.Unlock()
+	var err error
+	if f.rollBackwardFunc != nil {
+		// Call the user callback function. We don't hold the lock, so that the callback can call Points()
+		err = f.rollBackwardFunc(point, tip)
+		if err != nil && err != StopSyncProcessError {
+			return err
+		}
+	}
+	f.mutex.Lock()
+	f.rollBackTo(point)
+	f.mutex.Unlock()
+	return err
+}
+
+// addPoint records a delivered point, discarding the oldest points beyond the configured maximum
+func (f *Follower) addPoint(point common.Point) {
+	f.points = append(f.points, point)
+	if f.maxPoints > 0 && len(f.points) > f.maxPoints {
+		f.points = append([]common.Point{}, f.points[len(f.points)-f.maxPoints:]...)
+	}
+}
+
+// rollBackTo discards any recorded points after the provided point
+func (f *Follower) rollBackTo(point common.Point) {
+	idx := len(f.points)
+	for idx > 0 && f.points[idx-1].Slot >= point.Slot {
+		idx--
+	}
+	f.points = f.points[:idx]
+	// The origin doesn't need to be recorded, since it's where we start when there are no points
+	if point.Slot == 0 && point.Hash == nil {
+		return
+	}
+	f.points = append(f.points, point)
+}
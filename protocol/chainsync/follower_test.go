@@ -0,0 +1,384 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chainsync
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/ledger"
+	"github.com/blinklabs-io/gouroboros/muxer"
+	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
+)
+
+// testFollowerBlock provides the minimum needed by the Follower to record a point
+type testFollowerBlock struct {
+	slot uint64
+	hash []byte
+}
+
+func (b testFollowerBlock) Hash() string {
+	return hex.EncodeToString(b.hash)
+}
+
+func (b testFollowerBlock) SlotNumber() uint64 {
+	return b.slot
+}
+
+func (b testFollowerBlock) point() common.Point {
+	return common.NewPoint(b.slot, b.hash)
+}
+
+var testFollowerBlocks = []testFollowerBlock{
+	{slot: 100, hash: []byte{0x01}},
+	{slot: 200, hash: []byte{0x02}},
+	{slot: 300, hash: []byte{0x03}},
+}
+
+func TestFollowerResume(t *testing.T) {
+	var delivered []uint64
+	var rollbacks []common.Point
+	f := NewFollower(
+		WithFollowerRollForwardFunc(func(blockType uint, blockData interface{}, tip Tip) error {
+			delivered = append(delivered, blockData.(testFollowerBlock).slot)
+			return nil
+		}),
+		WithFollowerRollBackwardFunc(func(point common.Point, tip Tip) error {
+			rollbacks = append(rollbacks, point)
+			return nil
+		}),
+	)
+	// Initial sync from origin
+	f.resuming = true
+	if !reflect.DeepEqual(f.intersectPoints(), []common.Point{common.NewPointOrigin()}) {
+		t.Fatalf("did not get expected initial intersect points: %#v", f.intersectPoints())
+	}
+	if err := f.handleRollBackward(common.NewPointOrigin(), Tip{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, block := range testFollowerBlocks[:2] {
+		if err := f.handleRollForward(0, block, Tip{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// Resume after a disconnect. The server rolls back to the intersect point before sending the next block
+	f.resuming = true
+	expectedIntersectPoints := []common.Point{testFollowerBlocks[1].point(), testFollowerBlocks[0].point()}
+	if !reflect.DeepEqual(f.intersectPoints(), expectedIntersectPoints) {
+		t.Fatalf("did not get expected intersect points\n  got:    %#v\n  wanted: %#v", f.intersectPoints(), expectedIntersectPoints)
+	}
+	if err := f.handleRollBackward(testFollowerBlocks[1].point(), Tip{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := f.handleRollForward(0, testFollowerBlocks[2], Tip{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(delivered, []uint64{100, 200, 300}) {
+		t.Fatalf("did not get expected blocks: %v", delivered)
+	}
+	// Only the initial rollback to origin should have been passed along
+	if len(rollbacks) != 1 {
+		t.Fatalf("did not get expected rollbacks: %#v", rollbacks)
+	}
+}
+
+func TestFollowerRollback(t *testing.T) {
+	var rollbacks []common.Point
+	f := NewFollower(
+		WithFollowerStartPoints([]common.Point{testFollowerBlocks[0].point(), testFollowerBlocks[1].point(), testFollowerBlocks[2].point()}),
+		WithFollowerRollBackwardFunc(func(point common.Point, tip Tip) error {
+			rollbacks = append(rollbacks, point)
+			return nil
+		}),
+	)
+	// Resume with the last point no longer on the chain, which results in a rollback to an earlier point
+	f.resuming = true
+	if err := f.handleRollBackward(testFollowerBlocks[1].point(), Tip{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rollbacks, []common.Point{testFollowerBlocks[1].point()}) {
+		t.Fatalf("did not get expected rollbacks: %#v", rollbacks)
+	}
+	expectedPoints := []common.Point{testFollowerBlocks[0].point(), testFollowerBlocks[1].point()}
+	if !reflect.DeepEqual(f.Points(), expectedPoints) {
+		t.Fatalf("did not get expected points\n  got:    %#v\n  wanted: %#v", f.Points(), expectedPoints)
+	}
+}
+
+func TestFollowerCallbackError(t *testing.T) {
+	f := NewFollower(
+		WithFollowerRollForwardFunc(func(blockType uint, blockData interface{}, tip Tip) error {
+			return fmt.Errorf("failed")
+		}),
+	)
+	if err := f.handleRollForward(0, testFollowerBlocks[0], Tip{}); err == nil {
+		t.Fatalf("did not get expected error")
+	}
+	// A block that wasn't successfully delivered should not be recorded
+	if len(f.Points()) != 0 {
+		t.Fatalf("did not expect any points to be recorded: %#v", f.Points())
+	}
+}
+
+func TestFollowerMaxPoints(t *testing.T) {
+	f := NewFollower(
+		WithFollowerMaxPoints(2),
+	)
+	for _, block := range testFollowerBlocks {
+		if err := f.handleRollForward(0, block, Tip{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	expectedPoints := []common.Point{testFollowerBlocks[1].point(), testFollowerBlocks[2].point()}
+	if !reflect.DeepEqual(f.Points(), expectedPoints) {
+		t.Fatalf("did not get expected points\n  got:    %#v\n  wanted: %#v", f.Points(), expectedPoints)
+	}
+}
+
+func TestFollowerFallbackPoint(t *testing.T) {
+	checkpoint := testFollowerBlocks[1].point()
+	f := NewFollower(
+		WithFollowerFallbackPoint(checkpoint),
+	)
+	// With no recorded points, the sync starts at the fallback point
+	if !reflect.DeepEqual(f.intersectPoints(), []common.Point{checkpoint}) {
+		t.Fatalf("did not get expected intersect points: %#v", f.intersectPoints())
+	}
+}
+
+func TestFollowerCallbackPoints(t *testing.T) {
+	var callbackPoints [][]common.Point
+	var f *Follower
+	f = NewFollower(
+		WithFollowerRollForwardFunc(func(blockType uint, blockData interface{}, tip Tip) error {
+			callbackPoints = append(callbackPoints, f.Points())
+			return nil
+		}),
+	)
+	// Calling Points() from a callback should not deadlock, and the block being delivered is not yet recorded
+	for _, block := range testFollowerBlocks[:2] {
+		if err := f.handleRollForward(0, block, Tip{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	expectedPoints := [][]common.Point{
+		{},
+		{testFollowerBlocks[0].point()},
+	}
+	if !reflect.DeepEqual(callbackPoints, expectedPoints) {
+		t.Fatalf("did not get expected points\n  got:    %#v\n  wanted: %#v", callbackPoints, expectedPoints)
+	}
+}
+
+// testFollowerChainProvider is an in-memory ChainProvider that serves the provided updates and then blocks
+// until closed
+type testFollowerChainProvider struct {
+	updates []*ChainUpdate
+}
+
+func (p *testFollowerChainProvider) Tip() (Tip, error) {
+	return p.updates[len(p.updates)-1].Tip, nil
+}
+
+func (p *testFollowerChainProvider) FindIntersect(points []common.Point) (common.Point, bool, error) {
+	for _, point := range points {
+		if point.Slot == 0 && len(point.Hash) == 0 {
+			return point, true, nil
+		}
+		for _, update := range p.updates {
+			if reflect.DeepEqual(update.Point, point) {
+				return point, true, nil
+			}
+		}
+	}
+	return common.Point{}, false, nil
+}
+
+func (p *testFollowerChainProvider) NewIterator(start common.Point) (ChainIterator, error) {
+	iter := &testFollowerChainIterator{
+		updates:   p.updates,
+		closeChan: make(chan struct{}),
+	}
+	for idx, update := range p.updates {
+		if reflect.DeepEqual(update.Point, start) {
+			iter.next = idx + 1
+			break
+		}
+	}
+	return iter, nil
+}
+
+type testFollowerChainIterator struct {
+	updates   []*ChainUpdate
+	next      int
+	closeChan chan struct{}
+}
+
+func (i *testFollowerChainIterator) Next(blocking bool) (*ChainUpdate, error) {
+	if i.next < len(i.updates) {
+		update := i.updates[i.next]
+		i.next++
+		return update, nil
+	}
+	if !blocking {
+		return nil, nil
+	}
+	<-i.closeChan
+	return nil, fmt.Errorf("iterator closed")
+}
+
+func (i *testFollowerChainIterator) Close() error {
+	close(i.closeChan)
+	return nil
+}
+
+// newTestFollowerClient returns a NtC chain-sync client connected to a server for the provided chain
+func newTestFollowerClient(t *testing.T, provider ChainProvider, cfg Config) *Client {
+	clientConn, serverConn := net.Pipe()
+	clientMuxer := muxer.New(clientConn)
+	serverMuxer := muxer.New(serverConn)
+	t.Cleanup(func() {
+		clientMuxer.Stop()
+		serverMuxer.Stop()
+	})
+	errorChan := make(chan error, 10)
+	serverCfg := NewConfig(WithChainProvider(provider))
+	server := NewServer(
+		protocol.ProtocolOptions{
+			Muxer:     serverMuxer,
+			ErrorChan: errorChan,
+			Mode:      protocol.ProtocolModeNodeToClient,
+			Role:      protocol.ProtocolRoleServer,
+		},
+		&serverCfg,
+	)
+	client := NewClient(
+		protocol.ProtocolOptions{
+			Muxer:     clientMuxer,
+			ErrorChan: errorChan,
+			Mode:      protocol.ProtocolModeNodeToClient,
+			Role:      protocol.ProtocolRoleClient,
+		},
+		&cfg,
+	)
+	server.Start()
+	client.Start()
+	serverMuxer.Start()
+	clientMuxer.Start()
+	return client
+}
+
+func TestFollowerSyncFallback(t *testing.T) {
+	blockCbor := hexDecode(string(readFile("testdata/shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex")))
+	tip := Tip{
+		Point:       common.NewPoint(200, []byte{0xbe, 0xef}),
+		BlockNumber: 2,
+	}
+	provider := &testFollowerChainProvider{
+		updates: []*ChainUpdate{
+			{
+				Point:     common.NewPoint(100, []byte{0xab, 0xcd}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+			{
+				Point:     common.NewPoint(200, []byte{0xbe, 0xef}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+		},
+	}
+	// None of these points are on the provider's chain
+	orphanedPoints := []common.Point{
+		common.NewPoint(50, []byte{0x01}),
+		common.NewPoint(150, []byte{0x02}),
+	}
+	testDefs := []struct {
+		name             string
+		options          []FollowerOptionFunc
+		expectedRollback common.Point
+		expectedBlocks   int
+	}{
+		{
+			name:             "origin",
+			expectedRollback: common.NewPointOrigin(),
+			expectedBlocks:   2,
+		},
+		{
+			name: "checkpoint",
+			options: []FollowerOptionFunc{
+				WithFollowerFallbackPoint(common.NewPoint(100, []byte{0xab, 0xcd})),
+			},
+			expectedRollback: common.NewPoint(100, []byte{0xab, 0xcd}),
+			expectedBlocks:   1,
+		},
+	}
+	for _, testDef := range testDefs {
+		t.Run(testDef.name, func(t *testing.T) {
+			rollbackChan := make(chan common.Point, 10)
+			rollForwardChan := make(chan bool, 10)
+			options := append(
+				[]FollowerOptionFunc{
+					WithFollowerStartPoints(orphanedPoints),
+					WithFollowerRollBackwardFunc(func(point common.Point, tip Tip) error {
+						rollbackChan <- point
+						return nil
+					}),
+					WithFollowerRollForwardFunc(func(blockType uint, blockData interface{}, tip Tip) error {
+						rollForwardChan <- true
+						return nil
+					}),
+				},
+				testDef.options...,
+			)
+			follower := NewFollower(options...)
+			client := newTestFollowerClient(t, provider, follower.Config())
+			if err := follower.Sync(client); err != nil {
+				t.Fatalf("unexpected error calling Sync: %s", err)
+			}
+			// The server rolls back to the fallback point, which is passed along since the recorded points
+			// were discarded
+			select {
+			case point := <-rollbackChan:
+				if !reflect.DeepEqual(point, testDef.expectedRollback) {
+					t.Fatalf("did not get expected rollback point\n  got:    %#v\n  wanted: %#v", point, testDef.expectedRollback)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("did not receive expected rollback")
+			}
+			for i := 0; i < testDef.expectedBlocks; i++ {
+				select {
+				case <-rollForwardChan:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("did not receive expected block")
+				}
+			}
+			for _, point := range follower.Points() {
+				for _, orphanedPoint := range orphanedPoints {
+					if reflect.DeepEqual(point, orphanedPoint) {
+						t.Fatalf("orphaned point was not discarded: %#v", point)
+					}
+				}
+			}
+		})
+	}
+}
@@ -164,17 +164,21 @@ type MsgRollForwardNtN struct {
 	Tip           Tip
 }
 
-// NewMsgRollForwardNtN returns a MsgRollForwardNtN with the provided parameters
-func NewMsgRollForwardNtN(era uint, byronType uint, blockCbor []byte, tip Tip) *MsgRollForwardNtN {
+// NewMsgRollForwardNtN returns a MsgRollForwardNtN with the provided parameters. The header is extracted from the
+// provided block, and an error is returned if that fails
+func NewMsgRollForwardNtN(era uint, byronType uint, blockCbor []byte, tip Tip) (*MsgRollForwardNtN, error) {
 	m := &MsgRollForwardNtN{
 		MessageBase: protocol.MessageBase{
 			MessageType: MessageTypeRollForward,
 		},
 		Tip: tip,
 	}
-	wrappedHeader := NewWrappedHeader(era, byronType, blockCbor)
+	wrappedHeader, err := NewWrappedHeader(era, byronType, blockCbor)
+	if err != nil {
+		return nil, err
+	}
 	m.WrappedHeader = *wrappedHeader
-	return m
+	return m, nil
 }
 
 type MsgRollBackward struct {
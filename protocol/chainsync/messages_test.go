@@ -54,6 +54,15 @@ func readFile(path string) []byte {
 	return data
 }
 
+// Helper function to allow inline creation of a MsgRollForwardNtN without capturing the error
+func newMsgRollForwardNtN(era uint, byronType uint, blockCbor []byte, tip Tip) *MsgRollForwardNtN {
+	msg, err := NewMsgRollForwardNtN(era, byronType, blockCbor, tip)
+	if err != nil {
+		panic(fmt.Sprintf("error creating RollForward message: %s", err))
+	}
+	return msg
+}
+
 // Decode from CBOR and compare to object
 func testDecode(test testDefinition, t *testing.T) {
 	cborData, err := hex.DecodeString(test.CborHex)
@@ -122,7 +131,7 @@ func TestMsgRollForwardNodeToNode(t *testing.T) {
 		// Byron EBB (NtN)
 		{
 			CborHex: string(readFile("testdata/rollforward_ntn_byron_ebb_testnet_8f8602837f7c6f8b8867dd1cbc1842cf51a27eaed2c70ef48325d00f8efb320f.hex")),
-			Message: NewMsgRollForwardNtN(
+			Message: newMsgRollForwardNtN(
 				ledger.BLOCK_HEADER_TYPE_BYRON,
 				0,
 				hexDecode(string(readFile("testdata/byron_ebb_testnet_8f8602837f7c6f8b8867dd1cbc1842cf51a27eaed2c70ef48325d00f8efb320f.hex"))),
@@ -142,7 +151,7 @@ func TestMsgRollForwardNodeToNode(t *testing.T) {
 			// Byron main block (NtN)
 			{
 				CborHex: string(readFile("testdata/rollforward_ntn_byron_main_block_testnet_388a82f053603f3552717d61644a353188f2d5500f4c6354cc1ad27a36a7ea91.hex")),
-				Message: NewMsgRollForwardNtN(
+				Message: newMsgRollForwardNtN(
 					ledger.BLOCK_HEADER_TYPE_BYRON,
 					1,
 					hexDecode(string(readFile("testdata/byron_main_block_testnet_xxxx.hex"))),
@@ -161,7 +170,7 @@ func TestMsgRollForwardNodeToNode(t *testing.T) {
 		// Shelley block (NtN)
 		{
 			CborHex: string(readFile("testdata/rollforward_ntn_shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex")),
-			Message: NewMsgRollForwardNtN(
+			Message: newMsgRollForwardNtN(
 				ledger.BLOCK_HEADER_TYPE_SHELLEY,
 				0,
 				hexDecode(string(readFile("testdata/shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex"))),
@@ -180,6 +189,15 @@ func TestMsgRollForwardNodeToNode(t *testing.T) {
 	runTests(tests, t)
 }
 
+func TestMsgRollForwardNodeToNodeInvalidBlock(t *testing.T) {
+	for _, blockCbor := range [][]byte{{0xff}, {0x80}} {
+		msg, err := NewMsgRollForwardNtN(ledger.BLOCK_HEADER_TYPE_SHELLEY, 0, blockCbor, Tip{})
+		if err == nil {
+			t.Fatalf("did not get expected error for block CBOR %x, got message: %#v", blockCbor, msg)
+		}
+	}
+}
+
 func TestMsgRollForwardNodeToClient(t *testing.T) {
 	tests := []testDefinition{
 		// Byron EBB (NtC)
@@ -16,13 +16,20 @@ package chainsync
 
 import (
 	"fmt"
+	"sync"
+
+	"github.com/blinklabs-io/gouroboros/ledger"
 	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
 )
 
 // Server implements the ChainSync server
 type Server struct {
 	*protocol.Protocol
-	config *Config
+	config        *Config
+	iteratorMutex sync.Mutex
+	iterator      ChainIterator
+	rollbackPoint *common.Point
 }
 
 // NewServer returns a new ChainSync server object
@@ -43,6 +50,9 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleServer,
 		MessageHandlerFunc:  s.messageHandler,
@@ -51,6 +61,11 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 		InitialState:        stateIdle,
 	}
 	s.Protocol = protocol.New(protoConfig)
+	// Start goroutine to cleanup resources on protocol shutdown
+	go func() {
+		<-s.Protocol.DoneChan()
+		s.closeIterator()
+	}()
 	return s
 }
 
@@ -70,15 +85,163 @@ func (s *Server) messageHandler(msg protocol.Message, isResponse bool) error {
 }
 
 func (s *Server) handleRequestNext(msg protocol.Message) error {
-	// TODO
+	if s.config == nil || s.config.ChainProvider == nil {
+		return fmt.Errorf("received chain-sync RequestNext message but no chain provider is defined")
+	}
+	s.iteratorMutex.Lock()
+	// Start from the origin if the client didn't find an intersection first
+	if s.iterator == nil {
+		if err := s.newIteratorLocked(common.NewPointOrigin()); err != nil {
+			s.iteratorMutex.Unlock()
+			return err
+		}
+	}
+	iterator := s.iterator
+	rollbackPoint := s.rollbackPoint
+	s.rollbackPoint = nil
+	s.iteratorMutex.Unlock()
+	// The first response after an intersection is always a rollback to the intersect point
+	if rollbackPoint != nil {
+		tip, err := s.config.ChainProvider.Tip()
+		if err != nil {
+			return err
+		}
+		msgRollBackward := NewMsgRollBackward(*rollbackPoint, tip)
+		return s.SendMessage(msgRollBackward)
+	}
+	update, err := iterator.Next(false)
+	if err != nil {
+		return err
+	}
+	if update != nil {
+		return s.sendUpdate(update)
+	}
+	// Let the client know that it needs to wait and then wait for the next update in the background
+	msgAwaitReply := NewMsgAwaitReply()
+	if err := s.SendMessage(msgAwaitReply); err != nil {
+		return err
+	}
+	go func() {
+		update, err := iterator.Next(true)
+		if err != nil {
+			// Don't report an error if the iterator was closed due to protocol shutdown
+			select {
+			case <-s.Protocol.DoneChan():
+				return
+			default:
+			}
+			s.SendError(fmt.Errorf("%s: %s", ProtocolName, err))
+			return
+		}
+		if err := s.sendUpdate(update); err != nil {
+			s.SendError(err)
+		}
+	}()
 	return nil
 }
 
 func (s *Server) handleFindIntersect(msg protocol.Message) error {
-	// TODO
-	return nil
+	if s.config == nil || s.config.ChainProvider == nil {
+		return fmt.Errorf("received chain-sync FindIntersect message but no chain provider is defined")
+	}
+	msgFindIntersect := msg.(*MsgFindIntersect)
+	tip, err := s.config.ChainProvider.Tip()
+	if err != nil {
+		return err
+	}
+	point, found, err := s.config.ChainProvider.FindIntersect(msgFindIntersect.Points)
+	if err != nil {
+		return err
+	}
+	if !found {
+		msgResp := NewMsgIntersectNotFound(tip)
+		return s.SendMessage(msgResp)
+	}
+	if err := s.newIterator(point); err != nil {
+		return err
+	}
+	msgResp := NewMsgIntersectFound(point, tip)
+	return s.SendMessage(msgResp)
 }
 
 func (s *Server) handleDone() error {
+	s.closeIterator()
+	return nil
+}
+
+// newIterator replaces the current chain iterator with a new one starting at the specified point
+func (s *Server) newIterator(point common.Point) error {
+	s.iteratorMutex.Lock()
+	defer s.iteratorMutex.Unlock()
+	return s.newIteratorLocked(point)
+}
+
+func (s *Server) newIteratorLocked(point common.Point) error {
+	s.closeIteratorLocked()
+	iterator, err := s.config.ChainProvider.NewIterator(point)
+	if err != nil {
+		return err
+	}
+	s.iterator = iterator
+	s.rollbackPoint = &point
 	return nil
 }
+
+// closeIterator closes the current chain iterator. It's called both from the message handler and on protocol
+// shutdown, so the iterator is only closed once
+func (s *Server) closeIterator() {
+	s.iteratorMutex.Lock()
+	defer s.iteratorMutex.Unlock()
+	s.closeIteratorLocked()
+}
+
+func (s *Server) closeIteratorLocked() {
+	if s.iterator != nil {
+		_ = s.iterator.Close()
+		s.iterator = nil
+	}
+	s.rollbackPoint = nil
+}
+
+// sendUpdate sends the appropriate RollForward or RollBackward message for a chain update
+func (s *Server) sendUpdate(update *ChainUpdate) error {
+	if update.Rollback {
+		msg := NewMsgRollBackward(update.Point, update.Tip)
+		return s.SendMessage(msg)
+	}
+	var msg protocol.Message
+	if s.Mode() == protocol.ProtocolModeNodeToNode {
+		// Map block types to block header types
+		var era, byronType uint
+		switch update.BlockType {
+		case ledger.BLOCK_TYPE_BYRON_EBB, ledger.BLOCK_TYPE_BYRON_MAIN:
+			era = ledger.BLOCK_HEADER_TYPE_BYRON
+			byronType = update.BlockType
+		default:
+			blockHeaderTypeMap := map[uint]uint{
+				ledger.BLOCK_TYPE_SHELLEY: ledger.BLOCK_HEADER_TYPE_SHELLEY,
+				ledger.BLOCK_TYPE_ALLEGRA: ledger.BLOCK_HEADER_TYPE_ALLEGRA,
+				ledger.BLOCK_TYPE_MARY:    ledger.BLOCK_HEADER_TYPE_MARY,
+				ledger.BLOCK_TYPE_ALONZO:  ledger.BLOCK_HEADER_TYPE_ALONZO,
+				ledger.BLOCK_TYPE_BABBAGE: ledger.BLOCK_HEADER_TYPE_BABBAGE,
+			}
+			var ok bool
+			era, ok = blockHeaderTypeMap[update.BlockType]
+			if !ok {
+				return fmt.Errorf("%s: unknown block type: %d", ProtocolName, update.BlockType)
+			}
+		}
+		msgRollForward, err := NewMsgRollForwardNtN(era, byronType, update.BlockCbor, update.Tip)
+		if err != nil {
+			return fmt.Errorf("%s: failed to build RollForward message for block at slot %d: %w", ProtocolName, update.Point.Slot, err)
+		}
+		msg = msgRollForward
+	} else {
+		msgRollForward := NewMsgRollForwardNtC(update.BlockType, update.BlockCbor, update.Tip)
+		if msgRollForward == nil {
+			return fmt.Errorf("%s: failed to build RollForward message for block at slot %d", ProtocolName, update.Point.Slot)
+		}
+		msg = msgRollForward
+	}
+	return s.SendMessage(msg)
+}
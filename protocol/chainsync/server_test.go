@@ -0,0 +1,458 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chainsync_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/ledger"
+	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/chainsync"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
+)
+
+// testChainProvider is a simple in-memory ChainProvider
+type testChainProvider struct {
+	blocks     []*chainsync.ChainUpdate
+	tip        chainsync.Tip
+	newBlock   chan *chainsync.ChainUpdate
+	waiting    chan bool
+	closedChan chan bool
+}
+
+func (p *testChainProvider) Tip() (chainsync.Tip, error) {
+	return p.tip, nil
+}
+
+func (p *testChainProvider) FindIntersect(points []common.Point) (common.Point, bool, error) {
+	for _, point := range points {
+		for _, block := range p.blocks {
+			if block.Point.Slot == point.Slot {
+				return point, true, nil
+			}
+		}
+	}
+	return common.Point{}, false, nil
+}
+
+func (p *testChainProvider) NewIterator(start common.Point) (chainsync.ChainIterator, error) {
+	iter := &testChainIterator{provider: p}
+	for idx, block := range p.blocks {
+		if block.Point.Slot == start.Slot {
+			iter.next = idx + 1
+			break
+		}
+	}
+	return iter, nil
+}
+
+type testChainIterator struct {
+	provider *testChainProvider
+	next     int
+}
+
+func (i *testChainIterator) Next(blocking bool) (*chainsync.ChainUpdate, error) {
+	if i.next < len(i.provider.blocks) {
+		update := i.provider.blocks[i.next]
+		i.next++
+		return update, nil
+	}
+	if !blocking {
+		return nil, nil
+	}
+	i.provider.waiting <- true
+	update, ok := <-i.provider.newBlock
+	if !ok {
+		return nil, fmt.Errorf("iterator closed")
+	}
+	return update, nil
+}
+
+func (i *testChainIterator) Close() error {
+	select {
+	case i.provider.closedChan <- true:
+	default:
+	}
+	return nil
+}
+
+func TestServerNtN(t *testing.T) {
+	blockCbor := test.DecodeHexString(
+		readTestFile("testdata/shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex"),
+	)
+	tip := chainsync.Tip{
+		Point:       common.NewPoint(200, []byte{0xbe, 0xef}),
+		BlockNumber: 2,
+	}
+	provider := &testChainProvider{
+		blocks: []*chainsync.ChainUpdate{
+			{
+				Point:     common.NewPoint(100, []byte{0xab, 0xcd}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+			{
+				Point:     common.NewPoint(200, []byte{0xbe, 0xef}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+		},
+		tip:        tip,
+		newBlock:   make(chan *chainsync.ChainUpdate),
+		waiting:    make(chan bool),
+		closedChan: make(chan bool, 1),
+	}
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleServer,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeNtNProposeVersions,
+			ouroboros_mock.ConversationEntryHandshakeAcceptVersionGeneric,
+			// Find intersect at first block
+			newClientOutputEntry(chainsync.NewMsgFindIntersect([]common.Point{common.NewPoint(100, []byte{0xab, 0xcd})})),
+			newServerInputEntry(chainsync.MessageTypeIntersectFound),
+			// Initial rollback to intersect point
+			newClientOutputEntry(chainsync.NewMsgRequestNext()),
+			newServerInputEntry(chainsync.MessageTypeRollBackward),
+			// Second block
+			newClientOutputEntry(chainsync.NewMsgRequestNext()),
+			newServerInputEntry(chainsync.MessageTypeRollForward),
+			// Wait for new block
+			newClientOutputEntry(chainsync.NewMsgRequestNext()),
+			newServerInputEntry(chainsync.MessageTypeAwaitReply),
+			newServerInputEntry(chainsync.MessageTypeRollForward),
+			newClientOutputEntry(chainsync.NewMsgDone()),
+		},
+	)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithNodeToNode(true),
+		ouroboros.WithServer(true),
+		ouroboros.WithChainSyncConfig(
+			chainsync.NewConfig(
+				chainsync.WithChainProvider(provider),
+			),
+		),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	// Async error handler
+	go func() {
+		err, ok := <-oConn.ErrorChan()
+		if !ok {
+			return
+		}
+		// We can't call t.Fatalf() from a different Goroutine, so we panic instead
+		panic(fmt.Sprintf("unexpected Ouroboros connection error: %s", err))
+	}()
+	// Deliver a new block once the server is waiting on one. The server sends AwaitReply before it waits
+	select {
+	case <-provider.waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive expected blocking call to iterator")
+	}
+	provider.newBlock <- &chainsync.ChainUpdate{
+		Point:     common.NewPoint(300, []byte{0xca, 0xfe}),
+		BlockType: ledger.BLOCK_TYPE_SHELLEY,
+		BlockCbor: blockCbor,
+		Tip:       tip,
+	}
+	// Wait for the Done message to close the iterator
+	select {
+	case <-provider.closedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive expected Done message")
+	}
+	// Close Ouroboros connection
+	if err := oConn.Close(); err != nil {
+		t.Fatalf("unexpected error when closing Connection object: %s", err)
+	}
+}
+
+func TestServerNtCFullBlock(t *testing.T) {
+	blockCbor := test.DecodeHexString(
+		readTestFile("testdata/shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex"),
+	)
+	tip := chainsync.Tip{
+		Point:       common.NewPoint(200, []byte{0xbe, 0xef}),
+		BlockNumber: 2,
+	}
+	provider := &testChainProvider{
+		blocks: []*chainsync.ChainUpdate{
+			{
+				Point:     common.NewPoint(100, []byte{0xab, 0xcd}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+			{
+				Point:     common.NewPoint(200, []byte{0xbe, 0xef}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+		},
+		tip:        tip,
+		newBlock:   make(chan *chainsync.ChainUpdate),
+		waiting:    make(chan bool, 1),
+		closedChan: make(chan bool, 1),
+	}
+	// Unblock the iterator on test exit
+	defer close(provider.newBlock)
+	clientConn, serverConn := net.Pipe()
+	serverResultChan := make(chan error)
+	var oServer *ouroboros.Connection
+	go func() {
+		var err error
+		oServer, err = ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+			ouroboros.WithChainSyncConfig(
+				chainsync.NewConfig(
+					chainsync.WithChainProvider(provider),
+				),
+			),
+		)
+		serverResultChan <- err
+	}()
+	type rollForward struct {
+		blockType uint
+		block     ledger.Block
+	}
+	rollForwardChan := make(chan rollForward, 10)
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithChainSyncConfig(
+			chainsync.NewConfig(
+				chainsync.WithRollBackwardFunc(func(point common.Point, tip chainsync.Tip) error {
+					return nil
+				}),
+				chainsync.WithRollForwardFunc(func(blockType uint, blockData interface{}, tip chainsync.Tip) error {
+					rollForwardChan <- rollForward{blockType: blockType, block: blockData.(ledger.Block)}
+					return nil
+				}),
+			),
+		),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := <-serverResultChan; err != nil {
+		t.Fatalf("unexpected error when creating server Connection object: %s", err)
+	}
+	if err := oClient.ChainSync().Client.Sync([]common.Point{common.NewPoint(100, []byte{0xab, 0xcd})}); err != nil {
+		t.Fatalf("unexpected error calling Sync: %s", err)
+	}
+	// The client receives the full block after the intersect point
+	select {
+	case update := <-rollForwardChan:
+		if update.blockType != ledger.BLOCK_TYPE_SHELLEY {
+			t.Fatalf("did not get expected block type: got %d, wanted %d", update.blockType, ledger.BLOCK_TYPE_SHELLEY)
+		}
+		expectedHash := "02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f"
+		if update.block.Hash() != expectedHash {
+			t.Fatalf("did not get expected block hash: got %s, wanted %s", update.block.Hash(), expectedHash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive expected block")
+	}
+	// Wait for the server to reach the end of the chain before closing
+	select {
+	case <-provider.waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive expected blocking call to iterator")
+	}
+	if err := oClient.Close(); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	if err := oServer.Close(); err != nil {
+		t.Fatalf("unexpected error when closing server Connection object: %s", err)
+	}
+}
+
+func TestCloseGracefullyDuringSync(t *testing.T) {
+	blockCbor := test.DecodeHexString(
+		readTestFile("testdata/shelley_block_testnet_02b1c561715da9e540411123a6135ee319b02f60b9a11a603d3305556c04329f.hex"),
+	)
+	tip := chainsync.Tip{
+		Point:       common.NewPoint(200, []byte{0xbe, 0xef}),
+		BlockNumber: 2,
+	}
+	provider := &testChainProvider{
+		blocks: []*chainsync.ChainUpdate{
+			{
+				Point:     common.NewPoint(100, []byte{0xab, 0xcd}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+			{
+				Point:     common.NewPoint(200, []byte{0xbe, 0xef}),
+				BlockType: ledger.BLOCK_TYPE_SHELLEY,
+				BlockCbor: blockCbor,
+				Tip:       tip,
+			},
+		},
+		tip:        tip,
+		newBlock:   make(chan *chainsync.ChainUpdate),
+		waiting:    make(chan bool, 1),
+		closedChan: make(chan bool, 1),
+	}
+	clientConn, serverConn := net.Pipe()
+	serverResultChan := make(chan error)
+	var oServer *ouroboros.Connection
+	go func() {
+		var err error
+		oServer, err = ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+			ouroboros.WithChainSyncConfig(
+				chainsync.NewConfig(
+					chainsync.WithChainProvider(provider),
+				),
+			),
+		)
+		serverResultChan <- err
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		ouroboros.WithChainSyncConfig(
+			chainsync.NewConfig(
+				chainsync.WithPipelineLimit(2),
+				chainsync.WithRollBackwardFunc(func(point common.Point, tip chainsync.Tip) error {
+					return nil
+				}),
+				chainsync.WithRollForwardFunc(func(blockType uint, blockData interface{}, tip chainsync.Tip) error {
+					return nil
+				}),
+			),
+		),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := <-serverResultChan; err != nil {
+		t.Fatalf("unexpected error when creating server Connection object: %s", err)
+	}
+	defer oServer.Close()
+	// Any message from the client after Done would be a protocol violation on either side
+	serverErrorChan := make(chan error, 1)
+	go func() {
+		err, ok := <-oServer.ErrorChan()
+		if ok {
+			serverErrorChan <- err
+		}
+	}()
+	clientErrorChan := make(chan error, 1)
+	go func() {
+		err, ok := <-oClient.ErrorChan()
+		if ok {
+			clientErrorChan <- err
+		}
+	}()
+	if err := oClient.ChainSync().Client.Sync([]common.Point{common.NewPoint(100, []byte{0xab, 0xcd})}); err != nil {
+		t.Fatalf("unexpected error calling Sync: %s", err)
+	}
+	// Wait for the client to catch up to the tip, where the server waits for a new block
+	select {
+	case <-provider.waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive expected blocking call to iterator")
+	}
+	closeResultChan := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		closeResultChan <- oClient.CloseGracefully(ctx)
+	}()
+	// The Done message can't be sent until the outstanding block request is answered, so we keep producing
+	// blocks until the close completes
+	for done := false; !done; {
+		select {
+		case err := <-closeResultChan:
+			if err != nil {
+				t.Fatalf("unexpected error when closing client Connection object: %s", err)
+			}
+			done = true
+		case provider.newBlock <- &chainsync.ChainUpdate{
+			Point:     common.NewPoint(300, []byte{0xca, 0xfe}),
+			BlockType: ledger.BLOCK_TYPE_SHELLEY,
+			BlockCbor: blockCbor,
+			Tip:       tip,
+		}:
+		case <-provider.waiting:
+		case err := <-clientErrorChan:
+			t.Fatalf("unexpected client error: %s", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not close connection")
+		}
+	}
+	// The server closes the iterator when it receives the Done message
+	select {
+	case <-provider.closedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not receive expected Done message")
+	}
+	// The server sees an EOF once the client connection is closed, but nothing else
+	select {
+	case err := <-serverErrorChan:
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("unexpected server error: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newClientOutputEntry(msg protocol.Message) ouroboros_mock.ConversationEntry {
+	return ouroboros_mock.ConversationEntry{
+		Type:           ouroboros_mock.EntryTypeOutput,
+		ProtocolId:     chainsync.ProtocolIdNtN,
+		OutputMessages: []protocol.Message{msg},
+	}
+}
+
+func newServerInputEntry(msgType uint) ouroboros_mock.ConversationEntry {
+	return ouroboros_mock.ConversationEntry{
+		Type:             ouroboros_mock.EntryTypeInput,
+		ProtocolId:       chainsync.ProtocolIdNtN,
+		IsResponse:       true,
+		InputMessageType: msgType,
+	}
+}
+
+func readTestFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("error reading file: %s", err))
+	}
+	return string(data)
+}
@@ -15,6 +15,8 @@
 package chainsync
 
 import (
+	"fmt"
+
 	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/gouroboros/ledger"
 )
@@ -46,8 +48,8 @@ type WrappedHeader struct {
 	headerCbor []byte
 }
 
-// NewWrappedHeader returns a new WrappedHeader
-func NewWrappedHeader(era uint, byronType uint, blockCbor []byte) *WrappedHeader {
+// NewWrappedHeader returns a new WrappedHeader for the header of the provided block
+func NewWrappedHeader(era uint, byronType uint, blockCbor []byte) (*WrappedHeader, error) {
 	w := &WrappedHeader{
 		Era:       era,
 		byronType: byronType,
@@ -59,12 +61,14 @@ func NewWrappedHeader(era uint, byronType uint, blockCbor []byte) *WrappedHeader
 	}
 	// Parse block and extract header
 	tmp := []cbor.RawMessage{}
-	// TODO: figure out a better way to handle an error
 	if _, err := cbor.Decode(blockCbor, &tmp); err != nil {
-		return nil
+		return nil, err
+	}
+	if len(tmp) == 0 {
+		return nil, fmt.Errorf("%s: block has no header", ProtocolName)
 	}
 	w.headerCbor = tmp[0]
-	return w
+	return w, nil
 }
 
 func (w *WrappedHeader) UnmarshalCBOR(data []byte) error {
@@ -19,3 +19,74 @@ import (
 )
 
 var ProtocolShuttingDownError = fmt.Errorf("protocol is shutting down")
+
+// FeatureNotSupportedError indicates that a feature is not available with the negotiated protocol version
+type FeatureNotSupportedError struct {
+	Protocol string
+	Feature  string
+	Version  uint16
+}
+
+func (e FeatureNotSupportedError) Error() string {
+	return fmt.Sprintf("%s: %s is not supported with protocol version %d", e.Protocol, e.Feature, e.Version)
+}
+
+// ProtocolError wraps an error from a mini-protocol with the name and role of the mini-protocol that it came
+// from. The error message is that of the wrapped error
+type ProtocolError struct {
+	Protocol string
+	Role     ProtocolRole
+	Err      error
+}
+
+func (e ProtocolError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// StateTimeoutError indicates that no message was received before the timeout for a protocol state
+type StateTimeoutError struct {
+	Protocol string
+	State    State
+}
+
+func (e StateTimeoutError) Error() string {
+	return fmt.Sprintf("%s: timeout waiting on transition from protocol state %s", e.Protocol, e.State)
+}
+
+// InvalidMessageError indicates that a message is not allowed in the current protocol state
+type InvalidMessageError struct {
+	MessageType string
+	State       State
+}
+
+func (e InvalidMessageError) Error() string {
+	return fmt.Sprintf("message %s not allowed in current protocol state %s", e.MessageType, e.State)
+}
+
+// DecodeError indicates that a message could not be decoded
+type DecodeError struct {
+	Protocol string
+	Err      error
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("%s: decode error: %s", e.Protocol, e.Err)
+}
+
+func (e DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownMessageError indicates that a message of an unknown type was received
+type UnknownMessageError struct {
+	Protocol    string
+	MessageType uint
+}
+
+func (e UnknownMessageError) Error() string {
+	return fmt.Sprintf("%s: received unknown message type %d", e.Protocol, e.MessageType)
+}
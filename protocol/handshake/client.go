@@ -49,6 +49,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.handleMessage,
@@ -66,23 +69,8 @@ func (c *Client) Start() {
 		c.Protocol.Start()
 		// Send our ProposeVersions message
 		versionMap := make(map[uint16]interface{})
-		diffusionMode := DiffusionModeInitiatorOnly
-		if c.config.ClientFullDuplex {
-			diffusionMode = DiffusionModeInitiatorAndResponder
-		}
 		for _, version := range c.config.ProtocolVersions {
-			if c.Mode() == protocol.ProtocolModeNodeToNode {
-				// NOTE: it seems that protocol version 11 is still in flux, so we disable for now
-				/*
-					if version >= 11 {
-						// TODO: make peer sharing mode configurable
-						versionMap[version] = []interface{}{c.config.NetworkMagic, diffusionMode, PeerSharingModePeerSharingPrivate}
-					} else {
-				*/
-				versionMap[version] = []interface{}{c.config.NetworkMagic, diffusionMode}
-			} else {
-				versionMap[version] = c.config.NetworkMagic
-			}
+			versionMap[version] = c.config.newVersionData(c.Mode(), version, c.config.Query)
 		}
 		msg := NewMsgProposeVersions(versionMap)
 		_ = c.SendMessage(msg)
@@ -96,6 +84,8 @@ func (c *Client) handleMessage(msg protocol.Message, isResponse bool) error {
 		err = c.handleAcceptVersion(msg)
 	case MessageTypeRefuse:
 		err = c.handleRefuse(msg)
+	case MessageTypeQueryReply:
+		err = c.handleQueryReply(msg)
 	default:
 		err = fmt.Errorf("%s: received unexpected message type %d", ProtocolName, msg.Type())
 	}
@@ -103,31 +93,42 @@ func (c *Client) handleMessage(msg protocol.Message, isResponse bool) error {
 }
 
 func (c *Client) handleAcceptVersion(msgGeneric protocol.Message) error {
-	if c.config.FinishedFunc == nil {
-		return fmt.Errorf("received handshake AcceptVersion message but no callback function is defined")
-	}
 	msg := msgGeneric.(*MsgAcceptVersion)
-	fullDuplex := false
-	if c.Mode() == protocol.ProtocolModeNodeToNode {
-		versionData := msg.VersionData.([]interface{})
-		//nolint:gosimple
-		if versionData[1].(bool) == DiffusionModeInitiatorAndResponder {
-			fullDuplex = true
+	versionData, err := DecodeVersionData(c.Mode(), msg.Version, msg.VersionData)
+	if err != nil {
+		return err
+	}
+	if c.config.Query {
+		// The server doesn't support the query flag for any version that we have in common
+		if c.config.QueryReplyFunc == nil {
+			return fmt.Errorf("received handshake AcceptVersion message but no callback function is defined")
 		}
+		return c.config.QueryReplyFunc(map[uint16]VersionData{msg.Version: versionData})
+	}
+	if c.config.FinishedFunc == nil {
+		return fmt.Errorf("received handshake AcceptVersion message but no callback function is defined")
 	}
-	return c.config.FinishedFunc(msg.Version, fullDuplex)
+	return c.config.FinishedFunc(msg.Version, versionData)
 }
 
 func (c *Client) handleRefuse(msgGeneric protocol.Message) error {
 	msg := msgGeneric.(*MsgRefuse)
-	var err error
-	switch msg.Reason[0].(uint64) {
-	case RefuseReasonVersionMismatch:
-		err = fmt.Errorf("%s: version mismatch", ProtocolName)
-	case RefuseReasonDecodeError:
-		err = fmt.Errorf("%s: decode error: %s", ProtocolName, msg.Reason[2].(string))
-	case RefuseReasonRefused:
-		err = fmt.Errorf("%s: refused: %s", ProtocolName, msg.Reason[2].(string))
+	return msg.ReasonError()
+}
+
+func (c *Client) handleQueryReply(msgGeneric protocol.Message) error {
+	if c.config.QueryReplyFunc == nil {
+		return fmt.Errorf("received handshake QueryReply message but no callback function is defined")
 	}
-	return err
+	msg := msgGeneric.(*MsgQueryReply)
+	versions := make(map[uint16]VersionData)
+	for version, versionData := range msg.VersionMap {
+		tmpVersionData, err := DecodeVersionData(c.Mode(), version, versionData)
+		if err != nil {
+			// Skip versions that we don't know how to decode
+			continue
+		}
+		versions[version] = tmpVersionData
+	}
+	return c.config.QueryReplyFunc(versions)
 }
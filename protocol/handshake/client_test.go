@@ -15,11 +15,18 @@
 package handshake_test
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 
 	ouroboros "github.com/blinklabs-io/gouroboros"
 	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/muxer"
+	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/handshake"
 )
 
 func TestBasicHandshake(t *testing.T) {
@@ -37,6 +44,9 @@ func TestBasicHandshake(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error when creating Ouroboros object: %s", err)
 	}
+	if versionData := oConn.VersionData(); versionData == nil || versionData.NetworkMagic() != ouroboros_mock.MockNetworkMagic {
+		t.Fatalf("did not get expected version data: %#v", versionData)
+	}
 	// Async error handler
 	go func() {
 		err, ok := <-oConn.ErrorChan()
@@ -83,3 +93,145 @@ func TestDoubleStart(t *testing.T) {
 		t.Fatalf("unexpected error when closing Ouroboros object: %s", err)
 	}
 }
+
+// runRefusedHandshake performs a handshake between a client and server with the provided options and returns
+// the resulting errors
+func runRefusedHandshake(clientOptions []ouroboros.ConnectionOptionFunc, serverOptions []ouroboros.ConnectionOptionFunc) (error, error) {
+	clientConn, serverConn := net.Pipe()
+	serverResultChan := make(chan error)
+	go func() {
+		_, err := ouroboros.New(
+			append(
+				serverOptions,
+				ouroboros.WithConnection(serverConn),
+				ouroboros.WithServer(true),
+			)...,
+		)
+		serverResultChan <- err
+	}()
+	_, clientErr := ouroboros.New(
+		append(
+			clientOptions,
+			ouroboros.WithConnection(clientConn),
+		)...,
+	)
+	serverErr := <-serverResultChan
+	return clientErr, serverErr
+}
+
+func TestRefuseNetworkMagicMismatch(t *testing.T) {
+	clientErr, serverErr := runRefusedHandshake(
+		[]ouroboros.ConnectionOptionFunc{
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic + 1),
+		},
+		[]ouroboros.ConnectionOptionFunc{
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		},
+	)
+	var clientRefusedErr handshake.RefusedError
+	if !errors.As(clientErr, &clientRefusedErr) {
+		t.Fatalf("did not get expected client error: got %#v", clientErr)
+	}
+	var serverRefusedErr handshake.RefusedError
+	if !errors.As(serverErr, &serverRefusedErr) {
+		t.Fatalf("did not get expected server error: got %#v", serverErr)
+	}
+	if clientRefusedErr.Version != serverRefusedErr.Version || clientRefusedErr.Message != serverRefusedErr.Message {
+		t.Fatalf("client and server errors do not match: client %#v, server %#v", clientRefusedErr, serverRefusedErr)
+	}
+}
+
+func TestRefuseVersionMismatch(t *testing.T) {
+	// NtN and NtC have no versions in common
+	clientErr, serverErr := runRefusedHandshake(
+		[]ouroboros.ConnectionOptionFunc{
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithNodeToNode(true),
+		},
+		[]ouroboros.ConnectionOptionFunc{
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		},
+	)
+	var clientMismatchErr handshake.VersionMismatchError
+	if !errors.As(clientErr, &clientMismatchErr) {
+		t.Fatalf("did not get expected client error: got %#v", clientErr)
+	}
+	// The client should get the list of NtC versions supported by the server
+	if len(clientMismatchErr.PeerVersions) != len(ouroboros.GetProtocolVersionsNtC()) {
+		t.Fatalf("did not get expected server versions: got %v", clientMismatchErr.PeerVersions)
+	}
+	var serverMismatchErr handshake.VersionMismatchError
+	if !errors.As(serverErr, &serverMismatchErr) {
+		t.Fatalf("did not get expected server error: got %#v", serverErr)
+	}
+	if len(serverMismatchErr.PeerVersions) != len(ouroboros.GetProtocolVersionsNtN()) {
+		t.Fatalf("did not get expected client versions: got %v", serverMismatchErr.PeerVersions)
+	}
+}
+
+func TestQueryReply(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	clientMuxer := muxer.New(clientConn)
+	serverMuxer := muxer.New(serverConn)
+	defer clientMuxer.Stop()
+	defer serverMuxer.Stop()
+	serverErrorChan := make(chan error, 10)
+	serverConfig := handshake.NewConfig(
+		handshake.WithProtocolVersions([]uint16{0x8000 + 15, 0x8000 + 16}),
+		handshake.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		handshake.WithFinishedFunc(func(uint16, handshake.VersionData) error {
+			return fmt.Errorf("server should not accept a version in query mode")
+		}),
+	)
+	server := handshake.NewServer(
+		protocol.ProtocolOptions{
+			Muxer:     serverMuxer,
+			ErrorChan: serverErrorChan,
+			Mode:      protocol.ProtocolModeNodeToClient,
+		},
+		&serverConfig,
+	)
+	server.Start()
+	clientErrorChan := make(chan error, 10)
+	queryReplyChan := make(chan map[uint16]handshake.VersionData, 1)
+	clientConfig := handshake.NewConfig(
+		handshake.WithProtocolVersions([]uint16{0x8000 + 14, 0x8000 + 15, 0x8000 + 16}),
+		handshake.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+		handshake.WithQuery(true),
+		handshake.WithQueryReplyFunc(func(versions map[uint16]handshake.VersionData) error {
+			queryReplyChan <- versions
+			return nil
+		}),
+	)
+	client := handshake.NewClient(
+		protocol.ProtocolOptions{
+			Muxer:     clientMuxer,
+			ErrorChan: clientErrorChan,
+			Mode:      protocol.ProtocolModeNodeToClient,
+		},
+		&clientConfig,
+	)
+	client.Start()
+	select {
+	case versions := <-queryReplyChan:
+		expectedVersions := map[uint16]handshake.VersionData{
+			0x8000 + 15: handshake.VersionDataNtC15andUp{CborNetworkMagic: ouroboros_mock.MockNetworkMagic},
+			0x8000 + 16: handshake.VersionDataNtC15andUp{CborNetworkMagic: ouroboros_mock.MockNetworkMagic},
+		}
+		if !reflect.DeepEqual(versions, expectedVersions) {
+			t.Fatalf("did not get expected versions\n  got:    %#v\n  wanted: %#v", versions, expectedVersions)
+		}
+	case err := <-clientErrorChan:
+		t.Fatalf("unexpected client error: %s", err)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive query reply")
+	}
+	select {
+	case err := <-serverErrorChan:
+		if !errors.Is(err, handshake.QueryReplySentError) {
+			t.Fatalf("did not get expected server error: got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("did not receive server error")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handshake
+
+import (
+	"fmt"
+)
+
+// VersionMismatchError indicates that the handshake was refused because there is no common protocol version.
+// PeerVersions contains the versions supported by the peer, as sent on the wire
+type VersionMismatchError struct {
+	PeerVersions []uint16
+}
+
+func (e VersionMismatchError) Error() string {
+	return fmt.Sprintf("%s: refused: version mismatch: peer supports versions %v", ProtocolName, e.PeerVersions)
+}
+
+// DecodeError indicates that the handshake was refused because the version data for the specified version
+// could not be decoded
+type DecodeError struct {
+	Version uint16
+	Message string
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("%s: refused: decode error for version %d: %s", ProtocolName, e.Version, e.Message)
+}
+
+// RefusedError indicates that the handshake was refused for the specified version, such as when the network
+// magic does not match
+type RefusedError struct {
+	Version uint16
+	Message string
+}
+
+func (e RefusedError) Error() string {
+	return fmt.Sprintf("%s: refused: version %d: %s", ProtocolName, e.Version, e.Message)
+}
+
+// QueryReplySentError is returned by the server after replying to a client that set the query flag, since the
+// handshake does not result in a usable connection
+var QueryReplySentError = fmt.Errorf("%s: replied to version query", ProtocolName)
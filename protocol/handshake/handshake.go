@@ -27,17 +27,21 @@ const (
 	ProtocolId   = 0
 )
 
-// Diffusion modes
+// Diffusion modes, as represented by the initiator-only flag in the NtN version data. Earlier releases had
+// these values reversed, which didn't match the wire format, so code that passed them around as plain bools
+// should be checked
 const (
-	DiffusionModeInitiatorOnly         = false
-	DiffusionModeInitiatorAndResponder = true
+	DiffusionModeInitiatorOnly         = true
+	DiffusionModeInitiatorAndResponder = false
 )
 
-// Peer sharing modes
+// Peer sharing modes, as represented in the NtN v11-12 version data. Versions 13 and up only distinguish
+// between disabled (PeerSharingModeNoPeerSharing) and enabled (PeerSharingModeV13PeerSharingEnabled)
 const (
-	PeerSharingModeNoPeerSharing      = 0
-	PeerSharingModePeerSharingPublic  = 1
-	PeerSharingModePeerSharingPrivate = 2
+	PeerSharingModeNoPeerSharing         = 0
+	PeerSharingModePeerSharingPrivate    = 1
+	PeerSharingModePeerSharingPublic     = 2
+	PeerSharingModeV13PeerSharingEnabled = 1
 )
 
 var (
@@ -68,6 +72,10 @@ var StateMap = protocol.StateMap{
 				MsgType:  MessageTypeRefuse,
 				NewState: stateDone,
 			},
+			{
+				MsgType:  MessageTypeQueryReply,
+				NewState: stateDone,
+			},
 		},
 	},
 	stateDone: protocol.StateMapEntry{
@@ -86,12 +94,16 @@ type Config struct {
 	ProtocolVersions []uint16
 	NetworkMagic     uint32
 	ClientFullDuplex bool
+	PeerSharing      uint
 	FinishedFunc     FinishedFunc
+	Query            bool
+	QueryReplyFunc   QueryReplyFunc
 	Timeout          time.Duration
 }
 
 // Callback function types
-type FinishedFunc func(uint16, bool) error
+type FinishedFunc func(uint16, VersionData) error
+type QueryReplyFunc func(map[uint16]VersionData) error
 
 // New returns a new Handshake object
 func New(protoOptions protocol.ProtocolOptions, cfg *Config) *Handshake {
@@ -138,13 +150,40 @@ func WithClientFullDuplex(fullDuplex bool) HandshakeOptionFunc {
 	}
 }
 
-// WithFinishedFunc specifies the Finished callback function
+// WithPeerSharing specifies the peer sharing value to send in the version data for NtN protocol versions that
+// support it
+func WithPeerSharing(peerSharing uint) HandshakeOptionFunc {
+	return func(c *Config) {
+		c.PeerSharing = peerSharing
+	}
+}
+
+// WithFinishedFunc specifies the Finished callback function. It's called with the negotiated version and the
+// accepted version data
 func WithFinishedFunc(finishedFunc FinishedFunc) HandshakeOptionFunc {
 	return func(c *Config) {
 		c.FinishedFunc = finishedFunc
 	}
 }
 
+// WithQuery specifies whether to set the query flag when acting as a client. A server that supports the query flag
+// replies with its supported versions instead of accepting a version, and the QueryReply callback function is
+// called instead of the Finished callback function
+func WithQuery(query bool) HandshakeOptionFunc {
+	return func(c *Config) {
+		c.Query = query
+	}
+}
+
+// WithQueryReplyFunc specifies the QueryReply callback function. It's called with the versions supported by the
+// server and their version data. If the server does not support the query flag and accepts a version instead,
+// it's called with only the accepted version. Versions with version data that cannot be decoded are omitted
+func WithQueryReplyFunc(queryReplyFunc QueryReplyFunc) HandshakeOptionFunc {
+	return func(c *Config) {
+		c.QueryReplyFunc = queryReplyFunc
+	}
+}
+
 // WithTimeout specifies the timeout for the handshake operation
 func WithTimeout(timeout time.Duration) HandshakeOptionFunc {
 	return func(c *Config) {
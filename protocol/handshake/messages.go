@@ -26,6 +26,7 @@ const (
 	MessageTypeProposeVersions = 0
 	MessageTypeAcceptVersion   = 1
 	MessageTypeRefuse          = 2
+	MessageTypeQueryReply      = 3
 )
 
 // Refusal reasons
@@ -45,6 +46,8 @@ func NewMsgFromCbor(msgType uint, data []byte) (protocol.Message, error) {
 		ret = &MsgAcceptVersion{}
 	case MessageTypeRefuse:
 		ret = &MsgRefuse{}
+	case MessageTypeQueryReply:
+		ret = &MsgQueryReply{}
 	}
 	if _, err := cbor.Decode(data, ret); err != nil {
 		return nil, fmt.Errorf("%s: decode error: %s", ProtocolName, err)
@@ -102,3 +105,82 @@ func NewMsgRefuse(reason []interface{}) *MsgRefuse {
 	}
 	return m
 }
+
+type MsgQueryReply struct {
+	protocol.MessageBase
+	VersionMap map[uint16]interface{}
+}
+
+func NewMsgQueryReply(versionMap map[uint16]interface{}) *MsgQueryReply {
+	m := &MsgQueryReply{
+		MessageBase: protocol.MessageBase{
+			MessageType: MessageTypeQueryReply,
+		},
+		VersionMap: versionMap,
+	}
+	return m
+}
+
+// NewMsgRefuseVersionMismatch returns a Refuse message indicating that there is no common version. The
+// provided versions are the ones that we support
+func NewMsgRefuseVersionMismatch(versions []uint16) *MsgRefuse {
+	tmpVersions := make([]interface{}, 0, len(versions))
+	for _, version := range versions {
+		tmpVersions = append(tmpVersions, uint64(version))
+	}
+	return NewMsgRefuse([]interface{}{uint64(RefuseReasonVersionMismatch), tmpVersions})
+}
+
+// NewMsgRefuseDecodeError returns a Refuse message indicating that the version data for the specified version
+// could not be decoded
+func NewMsgRefuseDecodeError(version uint16, message string) *MsgRefuse {
+	return NewMsgRefuse([]interface{}{uint64(RefuseReasonDecodeError), uint64(version), message})
+}
+
+// NewMsgRefuseRefused returns a Refuse message indicating that the specified version was refused
+func NewMsgRefuseRefused(version uint16, message string) *MsgRefuse {
+	return NewMsgRefuse([]interface{}{uint64(RefuseReasonRefused), uint64(version), message})
+}
+
+// ReasonError returns the refusal reason as one of VersionMismatchError, DecodeError, or RefusedError. A
+// generic error is returned if the reason cannot be parsed
+func (m *MsgRefuse) ReasonError() error {
+	if len(m.Reason) < 2 {
+		return fmt.Errorf("%s: refused: invalid refusal reason: %v", ProtocolName, m.Reason)
+	}
+	reasonType, ok := m.Reason[0].(uint64)
+	if !ok {
+		return fmt.Errorf("%s: refused: invalid refusal reason: %v", ProtocolName, m.Reason)
+	}
+	switch reasonType {
+	case RefuseReasonVersionMismatch:
+		tmpVersions, ok := m.Reason[1].([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: refused: invalid version list: %v", ProtocolName, m.Reason[1])
+		}
+		versions := make([]uint16, 0, len(tmpVersions))
+		for _, tmpVersion := range tmpVersions {
+			version, ok := tmpVersion.(uint64)
+			if !ok {
+				return fmt.Errorf("%s: refused: invalid version list: %v", ProtocolName, m.Reason[1])
+			}
+			versions = append(versions, uint16(version))
+		}
+		return VersionMismatchError{PeerVersions: versions}
+	case RefuseReasonDecodeError, RefuseReasonRefused:
+		if len(m.Reason) < 3 {
+			return fmt.Errorf("%s: refused: invalid refusal reason: %v", ProtocolName, m.Reason)
+		}
+		version, ok := m.Reason[1].(uint64)
+		if !ok {
+			return fmt.Errorf("%s: refused: invalid version: %v", ProtocolName, m.Reason[1])
+		}
+		message, _ := m.Reason[2].(string)
+		if reasonType == RefuseReasonDecodeError {
+			return DecodeError{Version: uint16(version), Message: message}
+		}
+		return RefusedError{Version: uint16(version), Message: message}
+	default:
+		return fmt.Errorf("%s: refused: unknown refusal reason: %d", ProtocolName, reasonType)
+	}
+}
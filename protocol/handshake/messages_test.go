@@ -47,22 +47,48 @@ var tests = []testDefinition{
 		MessageType: MessageTypeAcceptVersion,
 		Message:     NewMsgAcceptVersion(10, []interface{}{uint64(2), false}),
 	},
+	// Hand-built NtN v11-v13 proposal, with the version data encoded as [networkMagic, initiatorOnly, peerSharing,
+	// query] and a peer sharing value of 1, which is PeerSharingPrivate for v11-v12 and enabled for v13
+	{
+		CborHex:     "8200a30b841a2d964a09f401f40c841a2d964a09f401f40d841a2d964a09f401f4",
+		MessageType: MessageTypeProposeVersions,
+		Message: NewMsgProposeVersions(
+			map[uint16]interface{}{
+				11: []interface{}{uint64(764824073), false, uint64(1), false},
+				12: []interface{}{uint64(764824073), false, uint64(1), false},
+				13: []interface{}{uint64(764824073), false, uint64(1), false},
+			},
+		),
+	},
+	{
+		CborHex:     "83010d841a2d964a09f401f4",
+		MessageType: MessageTypeAcceptVersion,
+		Message:     NewMsgAcceptVersion(13, []interface{}{uint64(764824073), false, uint64(1), false}),
+	},
 	{
 		CborHex:     "82028200840708090a",
 		MessageType: MessageTypeRefuse,
-		Message: NewMsgRefuse(
-			[]interface{}{
-				uint64(RefuseReasonVersionMismatch),
-				[]interface{}{
-					uint64(7),
-					uint64(8),
-					uint64(9),
-					uint64(10),
-				},
+		Message:     NewMsgRefuseVersionMismatch([]uint16{7, 8, 9, 10}),
+	},
+	{
+		CborHex:     "820283010a63666f6f",
+		MessageType: MessageTypeRefuse,
+		Message:     NewMsgRefuseDecodeError(10, "foo"),
+	},
+	{
+		CborHex:     "820283020a63626172",
+		MessageType: MessageTypeRefuse,
+		Message:     NewMsgRefuseRefused(10, "bar"),
+	},
+	{
+		CborHex:     "8203a119800f8202f4",
+		MessageType: MessageTypeQueryReply,
+		Message: NewMsgQueryReply(
+			map[uint16]interface{}{
+				0x800f: []interface{}{uint64(2), false},
 			},
 		),
 	},
-	// TODO: add more tests for other refusal types
 }
 
 func TestDecode(t *testing.T) {
@@ -95,3 +121,32 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestRefuseReasonError(t *testing.T) {
+	testDefs := []struct {
+		msg      *MsgRefuse
+		expected error
+	}{
+		{
+			msg:      NewMsgRefuseVersionMismatch([]uint16{7, 8, 9, 10}),
+			expected: VersionMismatchError{PeerVersions: []uint16{7, 8, 9, 10}},
+		},
+		{
+			msg:      NewMsgRefuseDecodeError(10, "foo"),
+			expected: DecodeError{Version: 10, Message: "foo"},
+		},
+		{
+			msg:      NewMsgRefuseRefused(10, "bar"),
+			expected: RefusedError{Version: 10, Message: "bar"},
+		},
+	}
+	for _, test := range testDefs {
+		err := test.msg.ReasonError()
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Fatalf("did not get expected error\n  got:    %#v\n  wanted: %#v", err, test.expected)
+		}
+	}
+	if err := NewMsgRefuse([]interface{}{uint64(99), "invalid"}).ReasonError(); err == nil {
+		t.Fatalf("did not get expected error for unknown refusal reason")
+	}
+}
@@ -16,6 +16,7 @@ package handshake
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/blinklabs-io/gouroboros/protocol"
 )
@@ -36,6 +37,9 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleServer,
 		MessageHandlerFunc:  s.handleMessage,
@@ -63,35 +67,87 @@ func (s *Server) handleProposeVersions(msgGeneric protocol.Message) error {
 		return fmt.Errorf("received handshake ProposeVersions message but no callback function is defined")
 	}
 	msg := msgGeneric.(*MsgProposeVersions)
+	// Find the highest version that we have in common
 	var highestVersion uint16
-	var fullDuplex bool
-	var versionData []interface{}
 	for proposedVersion := range msg.VersionMap {
-		if proposedVersion > highestVersion {
-			for _, allowedVersion := range s.config.ProtocolVersions {
-				if allowedVersion == proposedVersion {
-					highestVersion = proposedVersion
-					versionData = msg.VersionMap[proposedVersion].([]interface{})
-					//nolint:gosimple
-					if versionData[1].(bool) == DiffusionModeInitiatorAndResponder {
-						fullDuplex = true
-					} else {
-						fullDuplex = false
-					}
-					break
-				}
+		if proposedVersion <= highestVersion {
+			continue
+		}
+		for _, allowedVersion := range s.config.ProtocolVersions {
+			if allowedVersion == proposedVersion {
+				highestVersion = proposedVersion
+				break
 			}
 		}
 	}
-	if highestVersion > 0 {
-		resp := NewMsgAcceptVersion(highestVersion, versionData)
-		if err := s.SendMessage(resp); err != nil {
-			return err
+	if highestVersion == 0 {
+		proposedVersions := make([]uint16, 0, len(msg.VersionMap))
+		for proposedVersion := range msg.VersionMap {
+			proposedVersions = append(proposedVersions, proposedVersion)
+		}
+		sort.Slice(proposedVersions, func(i, j int) bool { return proposedVersions[i] < proposedVersions[j] })
+		return s.refuse(
+			NewMsgRefuseVersionMismatch(s.config.ProtocolVersions),
+			VersionMismatchError{PeerVersions: proposedVersions},
+		)
+	}
+	// Check the version data
+	versionData, err := DecodeVersionData(s.Mode(), highestVersion, msg.VersionMap[highestVersion])
+	if err != nil {
+		return s.refuseDecodeError(highestVersion, err.Error())
+	}
+	// Reply with our supported versions if the client set the query flag
+	if versionData.Query() {
+		versionMap := make(map[uint16]interface{})
+		for _, version := range s.config.ProtocolVersions {
+			versionMap[version] = s.config.newVersionData(s.Mode(), version, false)
 		}
-		return s.config.FinishedFunc(highestVersion, fullDuplex)
-	} else {
-		// TODO: handle failures
-		// https://github.com/blinklabs-io/gouroboros/issues/32
-		return fmt.Errorf("handshake failed, but we don't yet support this")
+		return s.sendFinal(NewMsgQueryReply(versionMap), QueryReplySentError)
+	}
+	if versionData.NetworkMagic() != s.config.NetworkMagic {
+		message := fmt.Sprintf("network magic mismatch: expected %d, got %d", s.config.NetworkMagic, versionData.NetworkMagic())
+		return s.refuse(
+			NewMsgRefuseRefused(highestVersion, message),
+			RefusedError{Version: highestVersion, Message: message},
+		)
+	}
+	// We only use duplex mode if both sides want it, and we only share peers if both sides are willing
+	initiatorOnly := versionData.InitiatorOnlyDiffusionMode() || !s.config.ClientFullDuplex
+	peerSharing := versionData.PeerSharing()
+	if s.config.PeerSharing < peerSharing {
+		peerSharing = s.config.PeerSharing
+	}
+	acceptVersionData := NewVersionData(s.Mode(), highestVersion, s.config.NetworkMagic, initiatorOnly, peerSharing, false)
+	resp := NewMsgAcceptVersion(highestVersion, acceptVersionData)
+	if err := s.SendMessage(resp); err != nil {
+		return err
+	}
+	return s.config.FinishedFunc(highestVersion, acceptVersionData)
+}
+
+func (s *Server) refuseDecodeError(version uint16, message string) error {
+	return s.refuse(
+		NewMsgRefuseDecodeError(version, message),
+		DecodeError{Version: version, Message: message},
+	)
+}
+
+// refuse sends the provided Refuse message and returns the provided error
+func (s *Server) refuse(msg *MsgRefuse, err error) error {
+	return s.sendFinal(msg, err)
+}
+
+// sendFinal sends the provided message, which ends the handshake with an error, and returns the provided error
+// once the message has been handed off to the muxer. The connection is shut down as soon as we return the
+// error, so we wait for the protocol to finish to avoid returning before the message leaves the send queue
+func (s *Server) sendFinal(msg protocol.Message, err error) error {
+	if sendErr := s.SendMessage(msg); sendErr != nil {
+		return sendErr
 	}
+	select {
+	case <-s.FinishedChan():
+	case <-s.DoneChan():
+		return protocol.ProtocolShuttingDownError
+	}
+	return err
 }
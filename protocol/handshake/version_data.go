@@ -0,0 +1,252 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handshake
+
+import (
+	"fmt"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/protocol"
+)
+
+// The NtC protocol versions have the 15th bit set in the handshake
+const protocolVersionNtCFlag = 0x8000
+
+// First protocol versions with the peer sharing and query flags in the version data
+const (
+	versionNtNPeerSharingQuery = 11
+	versionNtNPeerSharingV13   = 13
+	versionNtCQuery            = 15
+)
+
+// VersionData is the interface implemented by the version data types for all protocol versions. Fields that are
+// not present in the version data for a particular version return their default value
+type VersionData interface {
+	NetworkMagic() uint32
+	InitiatorOnlyDiffusionMode() bool
+	PeerSharing() uint
+	Query() bool
+}
+
+// VersionDataNtC9to14 is the version data for NtC protocol versions 9-14, which is just the network magic
+type VersionDataNtC9to14 uint32
+
+func (v VersionDataNtC9to14) NetworkMagic() uint32 {
+	return uint32(v)
+}
+
+func (v VersionDataNtC9to14) InitiatorOnlyDiffusionMode() bool {
+	return DiffusionModeInitiatorOnly
+}
+
+func (v VersionDataNtC9to14) PeerSharing() uint {
+	return PeerSharingModeNoPeerSharing
+}
+
+func (v VersionDataNtC9to14) Query() bool {
+	return false
+}
+
+// VersionDataNtC15andUp is the version data for NtC protocol versions 15 and up
+type VersionDataNtC15andUp struct {
+	cbor.StructAsArray
+	CborNetworkMagic uint32
+	CborQuery        bool
+}
+
+func (v VersionDataNtC15andUp) NetworkMagic() uint32 {
+	return v.CborNetworkMagic
+}
+
+func (v VersionDataNtC15andUp) InitiatorOnlyDiffusionMode() bool {
+	return DiffusionModeInitiatorOnly
+}
+
+func (v VersionDataNtC15andUp) PeerSharing() uint {
+	return PeerSharingModeNoPeerSharing
+}
+
+func (v VersionDataNtC15andUp) Query() bool {
+	return v.CborQuery
+}
+
+// VersionDataNtN7to10 is the version data for NtN protocol versions 7-10
+type VersionDataNtN7to10 struct {
+	cbor.StructAsArray
+	CborNetworkMagic               uint32
+	CborInitiatorOnlyDiffusionMode bool
+}
+
+func (v VersionDataNtN7to10) NetworkMagic() uint32 {
+	return v.CborNetworkMagic
+}
+
+func (v VersionDataNtN7to10) InitiatorOnlyDiffusionMode() bool {
+	return v.CborInitiatorOnlyDiffusionMode
+}
+
+func (v VersionDataNtN7to10) PeerSharing() uint {
+	return PeerSharingModeNoPeerSharing
+}
+
+func (v VersionDataNtN7to10) Query() bool {
+	return false
+}
+
+// VersionDataNtN11to12 is the version data for NtN protocol versions 11-12. The peer sharing value is one of the
+// PeerSharingMode* constants
+type VersionDataNtN11to12 struct {
+	cbor.StructAsArray
+	CborNetworkMagic               uint32
+	CborInitiatorOnlyDiffusionMode bool
+	CborPeerSharing                uint
+	CborQuery                      bool
+}
+
+func (v VersionDataNtN11to12) NetworkMagic() uint32 {
+	return v.CborNetworkMagic
+}
+
+func (v VersionDataNtN11to12) InitiatorOnlyDiffusionMode() bool {
+	return v.CborInitiatorOnlyDiffusionMode
+}
+
+func (v VersionDataNtN11to12) PeerSharing() uint {
+	return v.CborPeerSharing
+}
+
+func (v VersionDataNtN11to12) Query() bool {
+	return v.CborQuery
+}
+
+// VersionDataNtN13andUp is the version data for NtN protocol versions 13 and up. The peer sharing value is
+// either PeerSharingModeNoPeerSharing (disabled) or PeerSharingModeV13PeerSharingEnabled (enabled)
+type VersionDataNtN13andUp struct {
+	cbor.StructAsArray
+	CborNetworkMagic               uint32
+	CborInitiatorOnlyDiffusionMode bool
+	CborPeerSharing                uint
+	CborQuery                      bool
+}
+
+func (v VersionDataNtN13andUp) NetworkMagic() uint32 {
+	return v.CborNetworkMagic
+}
+
+func (v VersionDataNtN13andUp) InitiatorOnlyDiffusionMode() bool {
+	return v.CborInitiatorOnlyDiffusionMode
+}
+
+func (v VersionDataNtN13andUp) PeerSharing() uint {
+	return v.CborPeerSharing
+}
+
+func (v VersionDataNtN13andUp) Query() bool {
+	return v.CborQuery
+}
+
+// NewVersionData returns the version data type appropriate for the specified mode and version with the provided
+// values. The peer sharing value is one of the PeerSharingMode* constants for NtN v11-12, and any other peer
+// sharing mode is mapped to PeerSharingModeV13PeerSharingEnabled for later versions. Values that don't apply to
+// the version are ignored
+func NewVersionData(mode protocol.ProtocolMode, version uint16, networkMagic uint32, initiatorOnly bool, peerSharing uint, query bool) VersionData {
+	if mode == protocol.ProtocolModeNodeToNode {
+		switch {
+		case version >= versionNtNPeerSharingV13:
+			if peerSharing != PeerSharingModeNoPeerSharing {
+				peerSharing = PeerSharingModeV13PeerSharingEnabled
+			}
+			return VersionDataNtN13andUp{
+				CborNetworkMagic:               networkMagic,
+				CborInitiatorOnlyDiffusionMode: initiatorOnly,
+				CborPeerSharing:                peerSharing,
+				CborQuery:                      query,
+			}
+		case version >= versionNtNPeerSharingQuery:
+			return VersionDataNtN11to12{
+				CborNetworkMagic:               networkMagic,
+				CborInitiatorOnlyDiffusionMode: initiatorOnly,
+				CborPeerSharing:                peerSharing,
+				CborQuery:                      query,
+			}
+		default:
+			return VersionDataNtN7to10{
+				CborNetworkMagic:               networkMagic,
+				CborInitiatorOnlyDiffusionMode: initiatorOnly,
+			}
+		}
+	}
+	if version&^protocolVersionNtCFlag >= versionNtCQuery {
+		return VersionDataNtC15andUp{
+			CborNetworkMagic: networkMagic,
+			CborQuery:        query,
+		}
+	}
+	return VersionDataNtC9to14(networkMagic)
+}
+
+// DecodeVersionData converts the generic version data from a handshake message into the version data type
+// appropriate for the specified mode and version
+func DecodeVersionData(mode protocol.ProtocolMode, version uint16, versionData interface{}) (VersionData, error) {
+	// Typed version data doesn't need to be converted
+	if tmpVersionData, ok := versionData.(VersionData); ok {
+		return tmpVersionData, nil
+	}
+	// Re-encode the generic version data so that it can be decoded into the appropriate type
+	data, err := cbor.Encode(versionData)
+	if err != nil {
+		return nil, err
+	}
+	var ret VersionData
+	if mode == protocol.ProtocolModeNodeToNode {
+		switch {
+		case version >= versionNtNPeerSharingV13:
+			var tmpVersionData VersionDataNtN13andUp
+			_, err = cbor.Decode(data, &tmpVersionData)
+			ret = tmpVersionData
+		case version >= versionNtNPeerSharingQuery:
+			var tmpVersionData VersionDataNtN11to12
+			_, err = cbor.Decode(data, &tmpVersionData)
+			ret = tmpVersionData
+		default:
+			var tmpVersionData VersionDataNtN7to10
+			_, err = cbor.Decode(data, &tmpVersionData)
+			ret = tmpVersionData
+		}
+	} else {
+		if version&^protocolVersionNtCFlag >= versionNtCQuery {
+			var tmpVersionData VersionDataNtC15andUp
+			_, err = cbor.Decode(data, &tmpVersionData)
+			ret = tmpVersionData
+		} else {
+			var tmpVersionData VersionDataNtC9to14
+			_, err = cbor.Decode(data, &tmpVersionData)
+			ret = tmpVersionData
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid version data for version %d: %s", ProtocolName, version, err)
+	}
+	return ret, nil
+}
+
+// newVersionData builds the version data for the specified version from our config
+func (c *Config) newVersionData(mode protocol.ProtocolMode, version uint16, query bool) VersionData {
+	initiatorOnly := DiffusionModeInitiatorOnly
+	if c.ClientFullDuplex {
+		initiatorOnly = DiffusionModeInitiatorAndResponder
+	}
+	return NewVersionData(mode, version, c.NetworkMagic, initiatorOnly, c.PeerSharing, query)
+}
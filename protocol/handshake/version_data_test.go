@@ -0,0 +1,149 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handshake
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/protocol"
+)
+
+type versionDataTestDefinition struct {
+	CborHex     string
+	Mode        protocol.ProtocolMode
+	Version     uint16
+	VersionData VersionData
+}
+
+var versionDataTests = []versionDataTestDefinition{
+	{
+		CborHex:     "02",
+		Mode:        protocol.ProtocolModeNodeToClient,
+		Version:     0x8000 + 14,
+		VersionData: VersionDataNtC9to14(2),
+	},
+	{
+		CborHex: "8202f5",
+		Mode:    protocol.ProtocolModeNodeToClient,
+		Version: 0x8000 + 15,
+		VersionData: VersionDataNtC15andUp{
+			CborNetworkMagic: 2,
+			CborQuery:        true,
+		},
+	},
+	{
+		CborHex: "8202f5",
+		Mode:    protocol.ProtocolModeNodeToNode,
+		Version: 10,
+		VersionData: VersionDataNtN7to10{
+			CborNetworkMagic:               2,
+			CborInitiatorOnlyDiffusionMode: DiffusionModeInitiatorOnly,
+		},
+	},
+	{
+		CborHex: "8402f401f4",
+		Mode:    protocol.ProtocolModeNodeToNode,
+		Version: 11,
+		VersionData: VersionDataNtN11to12{
+			CborNetworkMagic:               2,
+			CborInitiatorOnlyDiffusionMode: DiffusionModeInitiatorAndResponder,
+			CborPeerSharing:                PeerSharingModePeerSharingPrivate,
+		},
+	},
+	{
+		CborHex: "8402f402f4",
+		Mode:    protocol.ProtocolModeNodeToNode,
+		Version: 12,
+		VersionData: VersionDataNtN11to12{
+			CborNetworkMagic:               2,
+			CborInitiatorOnlyDiffusionMode: DiffusionModeInitiatorAndResponder,
+			CborPeerSharing:                PeerSharingModePeerSharingPublic,
+		},
+	},
+	{
+		CborHex: "841a2d964a09f500f4",
+		Mode:    protocol.ProtocolModeNodeToNode,
+		Version: 13,
+		VersionData: VersionDataNtN13andUp{
+			CborNetworkMagic:               764824073,
+			CborInitiatorOnlyDiffusionMode: DiffusionModeInitiatorOnly,
+		},
+	},
+}
+
+func TestVersionDataDecode(t *testing.T) {
+	for _, test := range versionDataTests {
+		cborData, err := hex.DecodeString(test.CborHex)
+		if err != nil {
+			t.Fatalf("failed to decode CBOR hex: %s", err)
+		}
+		// Decode to a generic value first, like in a handshake message
+		var genericVersionData interface{}
+		if _, err := cbor.Decode(cborData, &genericVersionData); err != nil {
+			t.Fatalf("failed to decode CBOR: %s", err)
+		}
+		versionData, err := DecodeVersionData(test.Mode, test.Version, genericVersionData)
+		if err != nil {
+			t.Fatalf("failed to decode version data: %s", err)
+		}
+		if !reflect.DeepEqual(versionData, test.VersionData) {
+			t.Fatalf("CBOR did not decode to expected version data\n  got:    %#v\n  wanted: %#v", versionData, test.VersionData)
+		}
+	}
+}
+
+func TestVersionDataEncode(t *testing.T) {
+	for _, test := range versionDataTests {
+		cborData, err := cbor.Encode(test.VersionData)
+		if err != nil {
+			t.Fatalf("failed to encode version data to CBOR: %s", err)
+		}
+		cborHex := hex.EncodeToString(cborData)
+		if cborHex != test.CborHex {
+			t.Fatalf("version data did not encode to expected CBOR\n  got:    %s\n  wanted: %s", cborHex, test.CborHex)
+		}
+	}
+}
+
+func TestNewVersionDataPeerSharing(t *testing.T) {
+	testDefs := []struct {
+		version     uint16
+		peerSharing uint
+		expected    uint
+	}{
+		{version: 11, peerSharing: PeerSharingModePeerSharingPrivate, expected: 1},
+		{version: 12, peerSharing: PeerSharingModePeerSharingPublic, expected: 2},
+		{version: 12, peerSharing: PeerSharingModeNoPeerSharing, expected: 0},
+		{version: 13, peerSharing: PeerSharingModePeerSharingPublic, expected: 1},
+		{version: 13, peerSharing: PeerSharingModePeerSharingPrivate, expected: 1},
+		{version: 13, peerSharing: PeerSharingModeNoPeerSharing, expected: 0},
+	}
+	for _, testDef := range testDefs {
+		versionData := NewVersionData(protocol.ProtocolModeNodeToNode, testDef.version, 2, false, testDef.peerSharing, false)
+		if versionData.PeerSharing() != testDef.expected {
+			t.Fatalf("did not get expected peer sharing value for version %d and mode %d: got %d, wanted %d", testDef.version, testDef.peerSharing, versionData.PeerSharing(), testDef.expected)
+		}
+	}
+}
+
+func TestVersionDataDecodeInvalid(t *testing.T) {
+	// NtN v11 version data is missing the peer sharing and query fields
+	if _, err := DecodeVersionData(protocol.ProtocolModeNodeToNode, 11, []interface{}{uint64(2), true}); err == nil {
+		t.Fatalf("did not get expected error")
+	}
+}
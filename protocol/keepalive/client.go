@@ -24,9 +24,12 @@ import (
 
 type Client struct {
 	*protocol.Protocol
-	config    *Config
-	timer     *time.Timer
-	onceStart sync.Once
+	config     *Config
+	timer      *time.Timer
+	timerMutex sync.Mutex
+	stopped    bool
+	onceStart  sync.Once
+	onceStop   sync.Once
 }
 
 func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
@@ -49,6 +52,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          PROTOCOL_ID,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.messageHandler,
@@ -60,12 +66,7 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 	// Start goroutine to cleanup resources on protocol shutdown
 	go func() {
 		<-c.Protocol.DoneChan()
-		if c.timer != nil {
-			// Stop timer and drain channel
-			if ok := c.timer.Stop(); !ok {
-				<-c.timer.C
-			}
-		}
+		c.stopTimer()
 	}()
 	return c
 }
@@ -77,8 +78,31 @@ func (c *Client) Start() {
 	})
 }
 
+// Stop stops sending keep-alives and transitions the protocol to the Done state. A pending keep-alive response
+// is received first
+func (c *Client) Stop() error {
+	var err error
+	c.onceStop.Do(func() {
+		c.stopTimer()
+		msg := NewMsgDone()
+		err = c.SendMessage(msg)
+	})
+	return err
+}
+
 func (c *Client) startTimer() {
+	c.timerMutex.Lock()
+	defer c.timerMutex.Unlock()
+	if c.stopped {
+		return
+	}
 	c.timer = time.AfterFunc(c.config.Period, func() {
+		// We hold the lock while queueing the message so that it can't end up after a Done message
+		c.timerMutex.Lock()
+		defer c.timerMutex.Unlock()
+		if c.stopped {
+			return
+		}
 		msg := NewMsgKeepAlive(0)
 		if err := c.SendMessage(msg); err != nil {
 			c.SendError(err)
@@ -86,6 +110,15 @@ func (c *Client) startTimer() {
 	})
 }
 
+func (c *Client) stopTimer() {
+	c.timerMutex.Lock()
+	defer c.timerMutex.Unlock()
+	c.stopped = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
 func (c *Client) messageHandler(msg protocol.Message, isResponse bool) error {
 	var err error
 	switch msg.Type() {
@@ -100,7 +133,10 @@ func (c *Client) messageHandler(msg protocol.Message, isResponse bool) error {
 func (c *Client) handleKeepAliveResponse(msgGeneric protocol.Message) error {
 	msg := msgGeneric.(*MsgKeepAliveResponse)
 	// Start the timer again if we had one previously
-	if c.timer != nil {
+	c.timerMutex.Lock()
+	hasTimer := c.timer != nil
+	c.timerMutex.Unlock()
+	if hasTimer {
 		defer c.startTimer()
 	}
 	if c.config != nil && c.config.KeepAliveResponseFunc != nil {
@@ -15,6 +15,7 @@
 package localstatequery
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -27,14 +28,23 @@ import (
 type Client struct {
 	*protocol.Protocol
 	config                        *Config
+	version                       uint16
 	enableGetChainBlockNo         bool
 	enableGetChainPoint           bool
 	enableGetRewardInfoPoolsBlock bool
+	enableGetStakeDelegDeposits   bool
+	enableConwayQueries           bool
+	enableGetSPOStakeDistr        bool
+	enableGetProposals            bool
+	enableGetRatifyState          bool
 	busyMutex                     sync.Mutex
+	onceStop                      sync.Once
+	stateMutex                    sync.Mutex
 	acquired                      bool
 	queryResultChan               chan []byte
 	acquireResultChan             chan error
 	currentEra                    int
+	discardDoneChan               chan struct{}
 }
 
 // NewClient returns a new LocalStateQuery client object
@@ -66,6 +76,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.messageHandler,
@@ -74,6 +87,7 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		InitialState:        stateIdle,
 	}
 	// Enable version-dependent features
+	c.version = protoOptions.Version
 	if protoOptions.Version >= 10 {
 		c.enableGetChainBlockNo = true
 		c.enableGetChainPoint = true
@@ -81,6 +95,17 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 	if protoOptions.Version >= 11 {
 		c.enableGetRewardInfoPoolsBlock = true
 	}
+	if protoOptions.Version >= 15 {
+		c.enableGetStakeDelegDeposits = true
+	}
+	if protoOptions.Version >= 16 {
+		c.enableConwayQueries = true
+	}
+	if protoOptions.Version >= 17 {
+		c.enableGetSPOStakeDistr = true
+		c.enableGetProposals = true
+		c.enableGetRatifyState = true
+	}
 	c.Protocol = protocol.New(protoConfig)
 	// Start goroutine to cleanup resources on protocol shutdown
 	go func() {
@@ -107,14 +132,15 @@ func (c *Client) messageHandler(msg protocol.Message, isResponse bool) error {
 }
 
 func (c *Client) handleAcquired() error {
-	c.acquired = true
+	c.setAcquired(true)
 	c.acquireResultChan <- nil
-	c.currentEra = -1
 	return nil
 }
 
 func (c *Client) handleFailure(msg protocol.Message) error {
 	msgFailure := msg.(*MsgFailure)
+	// A failed acquire returns the protocol to the Idle state, even if a point was previously acquired
+	c.setAcquired(false)
 	switch msgFailure.Failure {
 	case AcquireFailurePointTooOld:
 		c.acquireResultChan <- AcquireFailurePointTooOldError{}
@@ -132,9 +158,30 @@ func (c *Client) handleResult(msg protocol.Message) error {
 	return nil
 }
 
-func (c *Client) acquire(point *common.Point) error {
+// setAcquired updates whether a chain point is currently acquired. The cached era is reset, since it may be
+// different for the new point
+func (c *Client) setAcquired(acquired bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.acquired = acquired
+	c.currentEra = -1
+}
+
+// isAcquired returns whether a chain point is currently acquired. The response to any abandoned request must be
+// consumed first for this to reflect the protocol state
+func (c *Client) isAcquired() bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.acquired
+}
+
+func (c *Client) acquire(ctx context.Context, point *common.Point) error {
+	// An abandoned acquire may still complete, so we wait for it before deciding which message to send
+	if err := c.waitForDiscard(ctx); err != nil {
+		return err
+	}
 	var msg protocol.Message
-	if c.acquired {
+	if c.isAcquired() {
 		if point != nil {
 			msg = NewMsgReAcquire(*point)
 		} else {
@@ -150,8 +197,13 @@ func (c *Client) acquire(point *common.Point) error {
 	if err := c.SendMessage(msg); err != nil {
 		return err
 	}
-	err := <-c.acquireResultChan
-	return err
+	select {
+	case <-ctx.Done():
+		c.discardResponse(c.acquireResultChan)
+		return ctx.Err()
+	case err := <-c.acquireResultChan:
+		return err
+	}
 }
 
 func (c *Client) release() error {
@@ -159,73 +211,167 @@ func (c *Client) release() error {
 	if err := c.SendMessage(msg); err != nil {
 		return err
 	}
-	c.acquired = false
-	c.currentEra = -1
+	c.setAcquired(false)
 	return nil
 }
 
-func (c *Client) runQuery(query interface{}, result interface{}) error {
+func (c *Client) runQuery(ctx context.Context, query interface{}, result interface{}) error {
 	msg := NewMsgQuery(query)
-	if !c.acquired {
-		if err := c.acquire(nil); err != nil {
+	if err := c.waitForDiscard(ctx); err != nil {
+		return err
+	}
+	if !c.isAcquired() {
+		if err := c.acquire(ctx, nil); err != nil {
 			return err
 		}
 	}
 	if err := c.SendMessage(msg); err != nil {
 		return err
 	}
-	resultCbor := <-c.queryResultChan
+	var resultCbor []byte
+	select {
+	case <-ctx.Done():
+		c.discardResponse(c.queryResultChan)
+		return ctx.Err()
+	case resultCbor = <-c.queryResultChan:
+	}
 	if _, err := cbor.Decode(resultCbor, result); err != nil {
 		return err
 	}
 	return nil
 }
 
+// discardResponse consumes the response for an abandoned request in the background. The server cannot be
+// told to abandon the request, so we have to wait for it to respond before the next request
+func (c *Client) discardResponse(respChan interface{}) {
+	discardDoneChan := make(chan struct{})
+	c.discardDoneChan = discardDoneChan
+	go func() {
+		defer close(discardDoneChan)
+		switch respChan := respChan.(type) {
+		case chan error:
+			select {
+			case <-c.DoneChan():
+			case <-respChan:
+			}
+		case chan []byte:
+			select {
+			case <-c.DoneChan():
+			case <-respChan:
+			}
+		}
+	}()
+}
+
+// waitForDiscard waits for the response to any previously abandoned request to be consumed
+func (c *Client) waitForDiscard(ctx context.Context) error {
+	if c.discardDoneChan == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.discardDoneChan:
+		c.discardDoneChan = nil
+	}
+	return nil
+}
+
 // Helper function for getting the current era
 // The current era is needed for many other queries
-func (c *Client) getCurrentEra() (int, error) {
+func (c *Client) getCurrentEra(ctx context.Context) (int, error) {
 	// Return cached era, if available
-	if c.currentEra > -1 {
-		return c.currentEra, nil
+	c.stateMutex.Lock()
+	currentEra := c.currentEra
+	c.stateMutex.Unlock()
+	if currentEra > -1 {
+		return currentEra, nil
 	}
 	query := buildHardForkQuery(QueryTypeHardForkCurrentEra)
 	var result int
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return -1, err
 	}
 	return result, nil
 }
 
+func (c *Client) featureNotSupported(feature string) error {
+	return protocol.FeatureNotSupportedError{
+		Protocol: ProtocolName,
+		Feature:  feature,
+		Version:  c.version,
+	}
+}
+
+// Stop transitions the protocol to the Done state. Any acquired chain point is released first. No more operations
+// will be possible afterward
+func (c *Client) Stop() error {
+	var err error
+	c.onceStop.Do(func() {
+		c.busyMutex.Lock()
+		defer c.busyMutex.Unlock()
+		if err = c.waitForDiscard(context.Background()); err != nil {
+			return
+		}
+		if c.isAcquired() {
+			if err = c.release(); err != nil {
+				return
+			}
+		}
+		msg := NewMsgDone()
+		err = c.SendMessage(msg)
+	})
+	return err
+}
+
 // Acquire starts the acquire process for the specified chain point
 func (c *Client) Acquire(point *common.Point) error {
+	return c.AcquireContext(context.Background(), point)
+}
+
+// AcquireContext is like Acquire, but the provided context can be used to abort waiting for the response
+func (c *Client) AcquireContext(ctx context.Context, point *common.Point) error {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	return c.acquire(point)
+	return c.acquire(ctx, point)
 }
 
 // Release releases the previously acquired chain point
 func (c *Client) Release() error {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
+	if err := c.waitForDiscard(context.Background()); err != nil {
+		return err
+	}
 	return c.release()
 }
 
 // GetCurrentEra returns the current era ID
 func (c *Client) GetCurrentEra() (int, error) {
+	return c.GetCurrentEraContext(context.Background())
+}
+
+// GetCurrentEraContext is like GetCurrentEra, but the provided context can be used to abort waiting for the response
+func (c *Client) GetCurrentEraContext(ctx context.Context) (int, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	return c.getCurrentEra()
+	return c.getCurrentEra(ctx)
 }
 
 // GetSystemStart returns the SystemStart value
 func (c *Client) GetSystemStart() (*SystemStartResult, error) {
+	return c.GetSystemStartContext(context.Background())
+}
+
+// GetSystemStartContext is like GetSystemStart, but the provided context can be used to abort waiting for the response
+func (c *Client) GetSystemStartContext(ctx context.Context) (*SystemStartResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
 	query := buildQuery(
 		QueryTypeSystemStart,
 	)
 	var result SystemStartResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -233,13 +379,21 @@ func (c *Client) GetSystemStart() (*SystemStartResult, error) {
 
 // GetChainBlockNo returns the latest block number
 func (c *Client) GetChainBlockNo() (int64, error) {
+	return c.GetChainBlockNoContext(context.Background())
+}
+
+// GetChainBlockNoContext is like GetChainBlockNo, but the provided context can be used to abort waiting for the response
+func (c *Client) GetChainBlockNoContext(ctx context.Context) (int64, error) {
+	if !c.enableGetChainBlockNo {
+		return 0, c.featureNotSupported("GetChainBlockNo")
+	}
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
 	query := buildQuery(
 		QueryTypeChainBlockNo,
 	)
 	var result []int64
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return 0, err
 	}
 	return result[1], nil
@@ -247,13 +401,21 @@ func (c *Client) GetChainBlockNo() (int64, error) {
 
 // GetChainPoint returns the current chain tip
 func (c *Client) GetChainPoint() (*common.Point, error) {
+	return c.GetChainPointContext(context.Background())
+}
+
+// GetChainPointContext is like GetChainPoint, but the provided context can be used to abort waiting for the response
+func (c *Client) GetChainPointContext(ctx context.Context) (*common.Point, error) {
+	if !c.enableGetChainPoint {
+		return nil, c.featureNotSupported("GetChainPoint")
+	}
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
 	query := buildQuery(
 		QueryTypeChainPoint,
 	)
 	var result common.Point
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -261,11 +423,16 @@ func (c *Client) GetChainPoint() (*common.Point, error) {
 
 // GetEraHistory returns the era history
 func (c *Client) GetEraHistory() ([]EraHistoryResult, error) {
+	return c.GetEraHistoryContext(context.Background())
+}
+
+// GetEraHistoryContext is like GetEraHistory, but the provided context can be used to abort waiting for the response
+func (c *Client) GetEraHistoryContext(ctx context.Context) ([]EraHistoryResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
 	query := buildHardForkQuery(QueryTypeHardForkEraHistory)
 	var result []EraHistoryResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return []EraHistoryResult{}, err
 	}
 	return result, nil
@@ -273,9 +440,14 @@ func (c *Client) GetEraHistory() ([]EraHistoryResult, error) {
 
 // GetEpochNo returns the current epoch number
 func (c *Client) GetEpochNo() (int, error) {
+	return c.GetEpochNoContext(context.Background())
+}
+
+// GetEpochNoContext is like GetEpochNo, but the provided context can be used to abort waiting for the response
+func (c *Client) GetEpochNoContext(ctx context.Context) (int, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -284,7 +456,7 @@ func (c *Client) GetEpochNo() (int, error) {
 		QueryTypeShelleyEpochNo,
 	)
 	var result []int
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return 0, err
 	}
 	return result[0], nil
@@ -292,9 +464,14 @@ func (c *Client) GetEpochNo() (int, error) {
 
 // TODO
 func (c *Client) GetNonMyopicMemberRewards() (*NonMyopicMemberRewardsResult, error) {
+	return c.GetNonMyopicMemberRewardsContext(context.Background())
+}
+
+// GetNonMyopicMemberRewardsContext is like GetNonMyopicMemberRewards, but the provided context can be used to abort waiting for the response
+func (c *Client) GetNonMyopicMemberRewardsContext(ctx context.Context) (*NonMyopicMemberRewardsResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -303,7 +480,7 @@ func (c *Client) GetNonMyopicMemberRewards() (*NonMyopicMemberRewardsResult, err
 		QueryTypeShelleyNonMyopicMemberRewards,
 	)
 	var result NonMyopicMemberRewardsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -311,9 +488,14 @@ func (c *Client) GetNonMyopicMemberRewards() (*NonMyopicMemberRewardsResult, err
 
 // GetCurrentProtocolParams returns the set of protocol params that are currently in effect
 func (c *Client) GetCurrentProtocolParams() (*CurrentProtocolParamsResult, error) {
+	return c.GetCurrentProtocolParamsContext(context.Background())
+}
+
+// GetCurrentProtocolParamsContext is like GetCurrentProtocolParams, but the provided context can be used to abort waiting for the response
+func (c *Client) GetCurrentProtocolParamsContext(ctx context.Context) (*CurrentProtocolParamsResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -322,7 +504,7 @@ func (c *Client) GetCurrentProtocolParams() (*CurrentProtocolParamsResult, error
 		QueryTypeShelleyCurrentProtocolParams,
 	)
 	var result []CurrentProtocolParamsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result[0], nil
@@ -331,9 +513,14 @@ func (c *Client) GetCurrentProtocolParams() (*CurrentProtocolParamsResult, error
 
 // TODO
 func (c *Client) GetProposedProtocolParamsUpdates() (*ProposedProtocolParamsUpdatesResult, error) {
+	return c.GetProposedProtocolParamsUpdatesContext(context.Background())
+}
+
+// GetProposedProtocolParamsUpdatesContext is like GetProposedProtocolParamsUpdates, but the provided context can be used to abort waiting for the response
+func (c *Client) GetProposedProtocolParamsUpdatesContext(ctx context.Context) (*ProposedProtocolParamsUpdatesResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -342,7 +529,7 @@ func (c *Client) GetProposedProtocolParamsUpdates() (*ProposedProtocolParamsUpda
 		QueryTypeShelleyProposedProtocolParamsUpdates,
 	)
 	var result ProposedProtocolParamsUpdatesResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -351,9 +538,14 @@ func (c *Client) GetProposedProtocolParamsUpdates() (*ProposedProtocolParamsUpda
 
 // GetStakeDistribution returns the stake distribution
 func (c *Client) GetStakeDistribution() (*StakeDistributionResult, error) {
+	return c.GetStakeDistributionContext(context.Background())
+}
+
+// GetStakeDistributionContext is like GetStakeDistribution, but the provided context can be used to abort waiting for the response
+func (c *Client) GetStakeDistributionContext(ctx context.Context) (*StakeDistributionResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -362,7 +554,7 @@ func (c *Client) GetStakeDistribution() (*StakeDistributionResult, error) {
 		QueryTypeShelleyStakeDistribution,
 	)
 	var result StakeDistributionResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -370,9 +562,14 @@ func (c *Client) GetStakeDistribution() (*StakeDistributionResult, error) {
 
 // TODO
 func (c *Client) GetUTxOByAddress(addrs []interface{}) (*UTxOByAddressResult, error) {
+	return c.GetUTxOByAddressContext(context.Background(), addrs)
+}
+
+// GetUTxOByAddressContext is like GetUTxOByAddress, but the provided context can be used to abort waiting for the response
+func (c *Client) GetUTxOByAddressContext(ctx context.Context, addrs []interface{}) (*UTxOByAddressResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -381,7 +578,7 @@ func (c *Client) GetUTxOByAddress(addrs []interface{}) (*UTxOByAddressResult, er
 		QueryTypeShelleyUtxoByAddress,
 	)
 	var result UTxOByAddressResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -389,9 +586,14 @@ func (c *Client) GetUTxOByAddress(addrs []interface{}) (*UTxOByAddressResult, er
 
 // TODO
 func (c *Client) GetUTxOWhole() (*UTxOWholeResult, error) {
+	return c.GetUTxOWholeContext(context.Background())
+}
+
+// GetUTxOWholeContext is like GetUTxOWhole, but the provided context can be used to abort waiting for the response
+func (c *Client) GetUTxOWholeContext(ctx context.Context) (*UTxOWholeResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -400,7 +602,7 @@ func (c *Client) GetUTxOWhole() (*UTxOWholeResult, error) {
 		QueryTypeShelleyUtxoWhole,
 	)
 	var result UTxOWholeResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -408,9 +610,14 @@ func (c *Client) GetUTxOWhole() (*UTxOWholeResult, error) {
 
 // TODO
 func (c *Client) DebugEpochState() (*DebugEpochStateResult, error) {
+	return c.DebugEpochStateContext(context.Background())
+}
+
+// DebugEpochStateContext is like DebugEpochState, but the provided context can be used to abort waiting for the response
+func (c *Client) DebugEpochStateContext(ctx context.Context) (*DebugEpochStateResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -419,7 +626,7 @@ func (c *Client) DebugEpochState() (*DebugEpochStateResult, error) {
 		QueryTypeShelleyDebugEpochState,
 	)
 	var result DebugEpochStateResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -427,9 +634,14 @@ func (c *Client) DebugEpochState() (*DebugEpochStateResult, error) {
 
 // TODO
 func (c *Client) GetFilteredDelegationsAndRewardAccounts(creds []interface{}) (*FilteredDelegationsAndRewardAccountsResult, error) {
+	return c.GetFilteredDelegationsAndRewardAccountsContext(context.Background(), creds)
+}
+
+// GetFilteredDelegationsAndRewardAccountsContext is like GetFilteredDelegationsAndRewardAccounts, but the provided context can be used to abort waiting for the response
+func (c *Client) GetFilteredDelegationsAndRewardAccountsContext(ctx context.Context, creds []interface{}) (*FilteredDelegationsAndRewardAccountsResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -438,7 +650,7 @@ func (c *Client) GetFilteredDelegationsAndRewardAccounts(creds []interface{}) (*
 		QueryTypeShelleyFilteredDelegationAndRewardAccounts,
 	)
 	var result FilteredDelegationsAndRewardAccountsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -446,9 +658,14 @@ func (c *Client) GetFilteredDelegationsAndRewardAccounts(creds []interface{}) (*
 
 // TODO
 func (c *Client) GetGenesisConfig() (*GenesisConfigResult, error) {
+	return c.GetGenesisConfigContext(context.Background())
+}
+
+// GetGenesisConfigContext is like GetGenesisConfig, but the provided context can be used to abort waiting for the response
+func (c *Client) GetGenesisConfigContext(ctx context.Context) (*GenesisConfigResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -457,7 +674,7 @@ func (c *Client) GetGenesisConfig() (*GenesisConfigResult, error) {
 		QueryTypeShelleyGenesisConfig,
 	)
 	var result []GenesisConfigResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result[0], nil
@@ -465,9 +682,14 @@ func (c *Client) GetGenesisConfig() (*GenesisConfigResult, error) {
 
 // TODO
 func (c *Client) DebugNewEpochState() (*DebugNewEpochStateResult, error) {
+	return c.DebugNewEpochStateContext(context.Background())
+}
+
+// DebugNewEpochStateContext is like DebugNewEpochState, but the provided context can be used to abort waiting for the response
+func (c *Client) DebugNewEpochStateContext(ctx context.Context) (*DebugNewEpochStateResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -476,7 +698,7 @@ func (c *Client) DebugNewEpochState() (*DebugNewEpochStateResult, error) {
 		QueryTypeShelleyDebugNewEpochState,
 	)
 	var result DebugNewEpochStateResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -484,9 +706,14 @@ func (c *Client) DebugNewEpochState() (*DebugNewEpochStateResult, error) {
 
 // TODO
 func (c *Client) DebugChainDepState() (*DebugChainDepStateResult, error) {
+	return c.DebugChainDepStateContext(context.Background())
+}
+
+// DebugChainDepStateContext is like DebugChainDepState, but the provided context can be used to abort waiting for the response
+func (c *Client) DebugChainDepStateContext(ctx context.Context) (*DebugChainDepStateResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -495,7 +722,7 @@ func (c *Client) DebugChainDepState() (*DebugChainDepStateResult, error) {
 		QueryTypeShelleyDebugChainDepState,
 	)
 	var result DebugChainDepStateResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -503,9 +730,14 @@ func (c *Client) DebugChainDepState() (*DebugChainDepStateResult, error) {
 
 // TODO
 func (c *Client) GetRewardProvenance() (*RewardProvenanceResult, error) {
+	return c.GetRewardProvenanceContext(context.Background())
+}
+
+// GetRewardProvenanceContext is like GetRewardProvenance, but the provided context can be used to abort waiting for the response
+func (c *Client) GetRewardProvenanceContext(ctx context.Context) (*RewardProvenanceResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -514,7 +746,7 @@ func (c *Client) GetRewardProvenance() (*RewardProvenanceResult, error) {
 		QueryTypeShelleyRewardProvenance,
 	)
 	var result RewardProvenanceResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -522,9 +754,14 @@ func (c *Client) GetRewardProvenance() (*RewardProvenanceResult, error) {
 
 // TODO
 func (c *Client) GetUTxOByTxIn(txins []interface{}) (*UTxOByTxInResult, error) {
+	return c.GetUTxOByTxInContext(context.Background(), txins)
+}
+
+// GetUTxOByTxInContext is like GetUTxOByTxIn, but the provided context can be used to abort waiting for the response
+func (c *Client) GetUTxOByTxInContext(ctx context.Context, txins []interface{}) (*UTxOByTxInResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -533,7 +770,7 @@ func (c *Client) GetUTxOByTxIn(txins []interface{}) (*UTxOByTxInResult, error) {
 		QueryTypeShelleyUtxoByTxin,
 	)
 	var result UTxOByTxInResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -541,9 +778,14 @@ func (c *Client) GetUTxOByTxIn(txins []interface{}) (*UTxOByTxInResult, error) {
 
 // TODO
 func (c *Client) GetStakePools() (*StakePoolsResult, error) {
+	return c.GetStakePoolsContext(context.Background())
+}
+
+// GetStakePoolsContext is like GetStakePools, but the provided context can be used to abort waiting for the response
+func (c *Client) GetStakePoolsContext(ctx context.Context) (*StakePoolsResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -552,7 +794,7 @@ func (c *Client) GetStakePools() (*StakePoolsResult, error) {
 		QueryTypeShelleyStakePools,
 	)
 	var result StakePoolsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -560,9 +802,14 @@ func (c *Client) GetStakePools() (*StakePoolsResult, error) {
 
 // TODO
 func (c *Client) GetStakePoolParams(poolIds []interface{}) (*StakePoolParamsResult, error) {
+	return c.GetStakePoolParamsContext(context.Background(), poolIds)
+}
+
+// GetStakePoolParamsContext is like GetStakePoolParams, but the provided context can be used to abort waiting for the response
+func (c *Client) GetStakePoolParamsContext(ctx context.Context, poolIds []interface{}) (*StakePoolParamsResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -571,7 +818,7 @@ func (c *Client) GetStakePoolParams(poolIds []interface{}) (*StakePoolParamsResu
 		QueryTypeShelleyStakePoolParams,
 	)
 	var result StakePoolParamsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -579,9 +826,17 @@ func (c *Client) GetStakePoolParams(poolIds []interface{}) (*StakePoolParamsResu
 
 // TODO
 func (c *Client) GetRewardInfoPools() (*RewardInfoPoolsResult, error) {
+	return c.GetRewardInfoPoolsContext(context.Background())
+}
+
+// GetRewardInfoPoolsContext is like GetRewardInfoPools, but the provided context can be used to abort waiting for the response
+func (c *Client) GetRewardInfoPoolsContext(ctx context.Context) (*RewardInfoPoolsResult, error) {
+	if !c.enableGetRewardInfoPoolsBlock {
+		return nil, c.featureNotSupported("GetRewardInfoPools")
+	}
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -590,7 +845,7 @@ func (c *Client) GetRewardInfoPools() (*RewardInfoPoolsResult, error) {
 		QueryTypeShelleyRewardInfoPools,
 	)
 	var result RewardInfoPoolsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -598,9 +853,14 @@ func (c *Client) GetRewardInfoPools() (*RewardInfoPoolsResult, error) {
 
 // TODO
 func (c *Client) GetPoolState(poolIds []interface{}) (*PoolStateResult, error) {
+	return c.GetPoolStateContext(context.Background(), poolIds)
+}
+
+// GetPoolStateContext is like GetPoolState, but the provided context can be used to abort waiting for the response
+func (c *Client) GetPoolStateContext(ctx context.Context, poolIds []interface{}) (*PoolStateResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -609,7 +869,7 @@ func (c *Client) GetPoolState(poolIds []interface{}) (*PoolStateResult, error) {
 		QueryTypeShelleyPoolState,
 	)
 	var result PoolStateResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -617,9 +877,14 @@ func (c *Client) GetPoolState(poolIds []interface{}) (*PoolStateResult, error) {
 
 // TODO
 func (c *Client) GetStakeSnapshots(poolId interface{}) (*StakeSnapshotsResult, error) {
+	return c.GetStakeSnapshotsContext(context.Background(), poolId)
+}
+
+// GetStakeSnapshotsContext is like GetStakeSnapshots, but the provided context can be used to abort waiting for the response
+func (c *Client) GetStakeSnapshotsContext(ctx context.Context, poolId interface{}) (*StakeSnapshotsResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -628,7 +893,7 @@ func (c *Client) GetStakeSnapshots(poolId interface{}) (*StakeSnapshotsResult, e
 		QueryTypeShelleyStakeSnapshots,
 	)
 	var result StakeSnapshotsResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -636,9 +901,14 @@ func (c *Client) GetStakeSnapshots(poolId interface{}) (*StakeSnapshotsResult, e
 
 // TODO
 func (c *Client) GetPoolDistr(poolIds []interface{}) (*PoolDistrResult, error) {
+	return c.GetPoolDistrContext(context.Background(), poolIds)
+}
+
+// GetPoolDistrContext is like GetPoolDistr, but the provided context can be used to abort waiting for the response
+func (c *Client) GetPoolDistrContext(ctx context.Context, poolIds []interface{}) (*PoolDistrResult, error) {
 	c.busyMutex.Lock()
 	defer c.busyMutex.Unlock()
-	currentEra, err := c.getCurrentEra()
+	currentEra, err := c.getCurrentEra(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -647,7 +917,314 @@ func (c *Client) GetPoolDistr(poolIds []interface{}) (*PoolDistrResult, error) {
 		QueryTypeShelleyPoolDistr,
 	)
 	var result PoolDistrResult
-	if err := c.runQuery(query, &result); err != nil {
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetStakeDelegDeposits returns the deposits for the specified stake credentials
+func (c *Client) GetStakeDelegDeposits(creds []interface{}) (*StakeDelegDepositsResult, error) {
+	return c.GetStakeDelegDepositsContext(context.Background(), creds)
+}
+
+// GetStakeDelegDepositsContext is like GetStakeDelegDeposits, but the provided context can be used to abort waiting for the response
+func (c *Client) GetStakeDelegDepositsContext(ctx context.Context, creds []interface{}) (*StakeDelegDepositsResult, error) {
+	if !c.enableGetStakeDelegDeposits {
+		return nil, c.featureNotSupported("GetStakeDelegDeposits")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyStakeDelegDeposits,
+		creds,
+	)
+	var result StakeDelegDepositsResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetConstitution returns the current constitution
+func (c *Client) GetConstitution() (*ConstitutionResult, error) {
+	return c.GetConstitutionContext(context.Background())
+}
+
+// GetConstitutionContext is like GetConstitution, but the provided context can be used to abort waiting for the response
+func (c *Client) GetConstitutionContext(ctx context.Context) (*ConstitutionResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetConstitution")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyConstitution,
+	)
+	var result ConstitutionResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetGovState returns the current governance state
+func (c *Client) GetGovState() (*GovStateResult, error) {
+	return c.GetGovStateContext(context.Background())
+}
+
+// GetGovStateContext is like GetGovState, but the provided context can be used to abort waiting for the response
+func (c *Client) GetGovStateContext(ctx context.Context) (*GovStateResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetGovState")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyGovState,
+	)
+	var result GovStateResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDRepState returns the state of the specified DRep credentials
+func (c *Client) GetDRepState(creds []interface{}) (*DRepStateResult, error) {
+	return c.GetDRepStateContext(context.Background(), creds)
+}
+
+// GetDRepStateContext is like GetDRepState, but the provided context can be used to abort waiting for the response
+func (c *Client) GetDRepStateContext(ctx context.Context, creds []interface{}) (*DRepStateResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetDRepState")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyDRepState,
+		creds,
+	)
+	var result DRepStateResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDRepStakeDistr returns the stake distribution for the specified DReps
+func (c *Client) GetDRepStakeDistr(dreps []interface{}) (*DRepStakeDistrResult, error) {
+	return c.GetDRepStakeDistrContext(context.Background(), dreps)
+}
+
+// GetDRepStakeDistrContext is like GetDRepStakeDistr, but the provided context can be used to abort waiting for the response
+func (c *Client) GetDRepStakeDistrContext(ctx context.Context, dreps []interface{}) (*DRepStakeDistrResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetDRepStakeDistr")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyDRepStakeDistr,
+		dreps,
+	)
+	var result DRepStakeDistrResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetCommitteeMembersState returns the state of constitutional committee members, filtered by the specified
+// cold credentials, hot credentials, and member statuses. An empty filter matches all members
+func (c *Client) GetCommitteeMembersState(coldCreds []interface{}, hotCreds []interface{}, statuses []interface{}) (*CommitteeMembersStateResult, error) {
+	return c.GetCommitteeMembersStateContext(context.Background(), coldCreds, hotCreds, statuses)
+}
+
+// GetCommitteeMembersStateContext is like GetCommitteeMembersState, but the provided context can be used to abort waiting for the response
+func (c *Client) GetCommitteeMembersStateContext(ctx context.Context, coldCreds []interface{}, hotCreds []interface{}, statuses []interface{}) (*CommitteeMembersStateResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetCommitteeMembersState")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyCommitteeMembersState,
+		coldCreds,
+		hotCreds,
+		statuses,
+	)
+	var result CommitteeMembersStateResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetFilteredVoteDelegatees returns the DReps that the specified stake credentials delegate their votes to
+func (c *Client) GetFilteredVoteDelegatees(creds []interface{}) (*FilteredVoteDelegateesResult, error) {
+	return c.GetFilteredVoteDelegateesContext(context.Background(), creds)
+}
+
+// GetFilteredVoteDelegateesContext is like GetFilteredVoteDelegatees, but the provided context can be used to abort waiting for the response
+func (c *Client) GetFilteredVoteDelegateesContext(ctx context.Context, creds []interface{}) (*FilteredVoteDelegateesResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetFilteredVoteDelegatees")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyFilteredVoteDelegatees,
+		creds,
+	)
+	var result FilteredVoteDelegateesResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetAccountState returns the treasury and reserves
+func (c *Client) GetAccountState() (*AccountStateResult, error) {
+	return c.GetAccountStateContext(context.Background())
+}
+
+// GetAccountStateContext is like GetAccountState, but the provided context can be used to abort waiting for the response
+func (c *Client) GetAccountStateContext(ctx context.Context) (*AccountStateResult, error) {
+	if !c.enableConwayQueries {
+		return nil, c.featureNotSupported("GetAccountState")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyAccountState,
+	)
+	var result AccountStateResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetSPOStakeDistr returns the stake distribution for the specified pools, as used for governance voting
+func (c *Client) GetSPOStakeDistr(poolIds []interface{}) (*SPOStakeDistrResult, error) {
+	return c.GetSPOStakeDistrContext(context.Background(), poolIds)
+}
+
+// GetSPOStakeDistrContext is like GetSPOStakeDistr, but the provided context can be used to abort waiting for the response
+func (c *Client) GetSPOStakeDistrContext(ctx context.Context, poolIds []interface{}) (*SPOStakeDistrResult, error) {
+	if !c.enableGetSPOStakeDistr {
+		return nil, c.featureNotSupported("GetSPOStakeDistr")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleySPOStakeDistr,
+		poolIds,
+	)
+	var result SPOStakeDistrResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetProposals returns the governance proposals with the specified action IDs
+func (c *Client) GetProposals(actionIds []interface{}) (*ProposalsResult, error) {
+	return c.GetProposalsContext(context.Background(), actionIds)
+}
+
+// GetProposalsContext is like GetProposals, but the provided context can be used to abort waiting for the response
+func (c *Client) GetProposalsContext(ctx context.Context, actionIds []interface{}) (*ProposalsResult, error) {
+	if !c.enableGetProposals {
+		return nil, c.featureNotSupported("GetProposals")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyProposals,
+		actionIds,
+	)
+	var result ProposalsResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetRatifyState returns the governance ratification state
+func (c *Client) GetRatifyState() (*RatifyStateResult, error) {
+	return c.GetRatifyStateContext(context.Background())
+}
+
+// GetRatifyStateContext is like GetRatifyState, but the provided context can be used to abort waiting for the response
+func (c *Client) GetRatifyStateContext(ctx context.Context) (*RatifyStateResult, error) {
+	if !c.enableGetRatifyState {
+		return nil, c.featureNotSupported("GetRatifyState")
+	}
+	c.busyMutex.Lock()
+	defer c.busyMutex.Unlock()
+	currentEra, err := c.getCurrentEra(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := buildShelleyQuery(
+		currentEra,
+		QueryTypeShelleyRatifyState,
+	)
+	var result RatifyStateResult
+	if err := c.runQuery(ctx, query, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
@@ -0,0 +1,96 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstatequery_test
+
+import (
+	"errors"
+	"testing"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/handshake"
+	"github.com/blinklabs-io/gouroboros/protocol/localstatequery"
+)
+
+// newVersionClient returns a LocalStateQuery client for a connection that negotiated the specified NtC version
+func newVersionClient(t *testing.T, version uint16) (*ouroboros.Connection, *localstatequery.Client) {
+	mockConn := ouroboros_mock.NewConnection(
+		ouroboros_mock.ProtocolRoleClient,
+		[]ouroboros_mock.ConversationEntry{
+			ouroboros_mock.ConversationEntryHandshakeRequestGeneric,
+			{
+				Type:       ouroboros_mock.EntryTypeOutput,
+				ProtocolId: handshake.ProtocolId,
+				IsResponse: true,
+				OutputMessages: []protocol.Message{
+					handshake.NewMsgAcceptVersion(
+						version,
+						handshake.NewVersionData(protocol.ProtocolModeNodeToClient, version, ouroboros_mock.MockNetworkMagic, false, 0, false),
+					),
+				},
+			},
+		},
+	)
+	oConn, err := ouroboros.New(
+		ouroboros.WithConnection(mockConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating Connection object: %s", err)
+	}
+	return oConn, oConn.LocalStateQuery().Client
+}
+
+func TestClientFeatureNotSupported(t *testing.T) {
+	// GetChainBlockNo and GetChainPoint were added in NtC version 10
+	oConn, client := newVersionClient(t, 9)
+	defer oConn.Close()
+	var featureErr protocol.FeatureNotSupportedError
+	if _, err := client.GetChainPoint(); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+	if featureErr.Feature != "GetChainPoint" || featureErr.Version != 9 {
+		t.Fatalf("did not get expected error details: %#v", featureErr)
+	}
+	if _, err := client.GetChainBlockNo(); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+}
+
+func TestClientConwayQueriesNotSupported(t *testing.T) {
+	// The Conway-era queries were added in NtC version 16
+	oConn, client := newVersionClient(t, 15)
+	defer oConn.Close()
+	var featureErr protocol.FeatureNotSupportedError
+	if _, err := client.GetConstitution(); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+	if _, err := client.GetDRepState(nil); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+	if _, err := client.GetCommitteeMembersState(nil, nil, nil); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+	if _, err := client.GetFilteredVoteDelegatees(nil); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+	if _, err := client.GetProposals(nil); !errors.As(err, &featureErr) {
+		t.Fatalf("did not get expected error: got %v", err)
+	}
+	if featureErr.Feature != "GetProposals" || featureErr.Version != 15 {
+		t.Fatalf("did not get expected error details: %#v", featureErr)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/blinklabs-io/gouroboros/protocol"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
 )
 
 // Protocol identifiers
@@ -112,6 +113,7 @@ type LocalStateQuery struct {
 type Config struct {
 	AcquireFunc    AcquireFunc
 	QueryFunc      QueryFunc
+	QueryHandler   QueryHandler
 	ReleaseFunc    ReleaseFunc
 	ReAcquireFunc  ReAcquireFunc
 	DoneFunc       DoneFunc
@@ -127,6 +129,88 @@ type ReleaseFunc func() error
 type ReAcquireFunc func(interface{}) error
 type DoneFunc func() error
 
+// QueryHandler answers typed queries when acting as a server. Each method receives the decoded
+// query and returns the result, which the server encodes in the form expected by the client
+type QueryHandler interface {
+	SystemStart(*SystemStartQuery) (*SystemStartResult, error)
+	ChainBlockNo(*ChainBlockNoQuery) (int64, error)
+	ChainPoint(*ChainPointQuery) (*common.Point, error)
+	EraHistory(*HardForkEraHistoryQuery) ([]EraHistoryResult, error)
+	CurrentEra(*HardForkCurrentEraQuery) (int, error)
+	EpochNo(*ShelleyEpochNoQuery) (int, error)
+	CurrentProtocolParams(*ShelleyCurrentProtocolParamsQuery) (*CurrentProtocolParamsResult, error)
+	StakeDistribution(*ShelleyStakeDistributionQuery) (StakeDistributionResult, error)
+	GenesisConfig(*ShelleyGenesisConfigQuery) (*GenesisConfigResult, error)
+	StakePools(*ShelleyStakePoolsQuery) (StakePoolsResult, error)
+}
+
+// The following interfaces may optionally be implemented by a QueryHandler to answer additional queries.
+// Queries without a matching handler are rejected as unsupported
+
+type LedgerTipQueryHandler interface {
+	LedgerTip(*ShelleyLedgerTipQuery) (*common.Point, error)
+}
+
+type NonMyopicMemberRewardsQueryHandler interface {
+	NonMyopicMemberRewards(*ShelleyNonMyopicMemberRewardsQuery) (NonMyopicMemberRewardsResult, error)
+}
+
+type ProposedProtocolParamsUpdatesQueryHandler interface {
+	ProposedProtocolParamsUpdates(*ShelleyProposedProtocolParamsUpdatesQuery) (ProposedProtocolParamsUpdatesResult, error)
+}
+
+type UTxOByAddressQueryHandler interface {
+	UTxOByAddress(*ShelleyUtxoByAddressQuery) (UTxOByAddressResult, error)
+}
+
+type UTxOWholeQueryHandler interface {
+	UTxOWhole(*ShelleyUtxoWholeQuery) (UTxOWholeResult, error)
+}
+
+type DebugEpochStateQueryHandler interface {
+	DebugEpochState(*ShelleyDebugEpochStateQuery) (DebugEpochStateResult, error)
+}
+
+type FilteredDelegationsAndRewardAccountsQueryHandler interface {
+	FilteredDelegationsAndRewardAccounts(*ShelleyFilteredDelegationAndRewardAccountsQuery) (FilteredDelegationsAndRewardAccountsResult, error)
+}
+
+type DebugNewEpochStateQueryHandler interface {
+	DebugNewEpochState(*ShelleyDebugNewEpochStateQuery) (DebugNewEpochStateResult, error)
+}
+
+type DebugChainDepStateQueryHandler interface {
+	DebugChainDepState(*ShelleyDebugChainDepStateQuery) (DebugChainDepStateResult, error)
+}
+
+type RewardProvenanceQueryHandler interface {
+	RewardProvenance(*ShelleyRewardProvenanceQuery) (RewardProvenanceResult, error)
+}
+
+type UTxOByTxInQueryHandler interface {
+	UTxOByTxIn(*ShelleyUtxoByTxinQuery) (UTxOByTxInResult, error)
+}
+
+type StakePoolParamsQueryHandler interface {
+	StakePoolParams(*ShelleyStakePoolParamsQuery) (StakePoolParamsResult, error)
+}
+
+type RewardInfoPoolsQueryHandler interface {
+	RewardInfoPools(*ShelleyRewardInfoPoolsQuery) (RewardInfoPoolsResult, error)
+}
+
+type PoolStateQueryHandler interface {
+	PoolState(*ShelleyPoolStateQuery) (PoolStateResult, error)
+}
+
+type StakeSnapshotsQueryHandler interface {
+	StakeSnapshots(*ShelleyStakeSnapshotsQuery) (StakeSnapshotsResult, error)
+}
+
+type PoolDistrQueryHandler interface {
+	PoolDistr(*ShelleyPoolDistrQuery) (PoolDistrResult, error)
+}
+
 // New returns a new LocalStateQuery object
 func New(protoOptions protocol.ProtocolOptions, cfg *Config) *LocalStateQuery {
 	l := &LocalStateQuery{
@@ -166,6 +250,14 @@ func WithQueryFunc(queryFunc QueryFunc) LocalStateQueryOptionFunc {
 	}
 }
 
+// WithQueryHandler specifies the handler for typed queries when acting as a server. It takes
+// precedence over the Query callback function
+func WithQueryHandler(queryHandler QueryHandler) LocalStateQueryOptionFunc {
+	return func(c *Config) {
+		c.QueryHandler = queryHandler
+	}
+}
+
 // WithReleaseFunc specifies the Release callback function when acting as a server
 func WithReleaseFunc(releaseFunc ReleaseFunc) LocalStateQueryOptionFunc {
 	return func(c *Config) {
@@ -15,6 +15,8 @@
 package localstatequery
 
 import (
+	"fmt"
+
 	"github.com/blinklabs-io/gouroboros/cbor"
 )
 
@@ -56,8 +58,22 @@ const (
 	QueryTypeShelleyPoolState                           = 19
 	QueryTypeShelleyStakeSnapshots                      = 20
 	QueryTypeShelleyPoolDistr                           = 21
+	QueryTypeShelleyStakeDelegDeposits                  = 22
+	QueryTypeShelleyConstitution                        = 23
+	QueryTypeShelleyGovState                            = 24
+	QueryTypeShelleyDRepState                           = 25
+	QueryTypeShelleyDRepStakeDistr                      = 26
+	QueryTypeShelleyCommitteeMembersState               = 27
+	QueryTypeShelleyFilteredVoteDelegatees              = 28
+	QueryTypeShelleyAccountState                        = 29
+	QueryTypeShelleySPOStakeDistr                       = 30
+	QueryTypeShelleyProposals                           = 31
+	QueryTypeShelleyRatifyState                         = 32
 )
 
+// CBOR tag used for sets in query params
+const cborTagSet = 258
+
 func buildQuery(queryType int, params ...interface{}) []interface{} {
 	ret := []interface{}{queryType}
 	if len(params) > 0 {
@@ -97,6 +113,334 @@ func buildShelleyQuery(era int, queryType int, params ...interface{}) []interfac
 	return ret
 }
 
+// SystemStartQuery is the decoded form of a system start query
+type SystemStartQuery struct{}
+
+// ChainBlockNoQuery is the decoded form of a chain block number query
+type ChainBlockNoQuery struct{}
+
+// ChainPointQuery is the decoded form of a chain point query
+type ChainPointQuery struct{}
+
+// HardForkEraHistoryQuery is the decoded form of a hard-fork era history query
+type HardForkEraHistoryQuery struct{}
+
+// HardForkCurrentEraQuery is the decoded form of a hard-fork current era query
+type HardForkCurrentEraQuery struct{}
+
+// ShelleyEpochNoQuery is the decoded form of a Shelley epoch number query
+type ShelleyEpochNoQuery struct {
+	Era int
+}
+
+// ShelleyCurrentProtocolParamsQuery is the decoded form of a Shelley current protocol params query
+type ShelleyCurrentProtocolParamsQuery struct {
+	Era int
+}
+
+// ShelleyStakeDistributionQuery is the decoded form of a Shelley stake distribution query
+type ShelleyStakeDistributionQuery struct {
+	Era int
+}
+
+// ShelleyGenesisConfigQuery is the decoded form of a Shelley genesis config query
+type ShelleyGenesisConfigQuery struct {
+	Era int
+}
+
+// ShelleyStakePoolsQuery is the decoded form of a Shelley stake pools query
+type ShelleyStakePoolsQuery struct {
+	Era int
+}
+
+// ShelleyLedgerTipQuery is the decoded form of a Shelley ledger tip query
+type ShelleyLedgerTipQuery struct {
+	Era int
+}
+
+// ShelleyNonMyopicMemberRewardsQuery is the decoded form of a Shelley non-myopic member rewards query. Each
+// item in Stakes is either an amount of lovelace or a stake credential
+type ShelleyNonMyopicMemberRewardsQuery struct {
+	Era    int
+	Stakes []interface{}
+}
+
+// ShelleyProposedProtocolParamsUpdatesQuery is the decoded form of a Shelley proposed protocol params updates query
+type ShelleyProposedProtocolParamsUpdatesQuery struct {
+	Era int
+}
+
+// ShelleyUtxoByAddressQuery is the decoded form of a Shelley UTxO by address query
+type ShelleyUtxoByAddressQuery struct {
+	Era   int
+	Addrs []interface{}
+}
+
+// ShelleyUtxoWholeQuery is the decoded form of a Shelley whole UTxO query
+type ShelleyUtxoWholeQuery struct {
+	Era int
+}
+
+// ShelleyDebugEpochStateQuery is the decoded form of a Shelley debug epoch state query
+type ShelleyDebugEpochStateQuery struct {
+	Era int
+}
+
+// ShelleyFilteredDelegationAndRewardAccountsQuery is the decoded form of a Shelley filtered delegations and
+// reward accounts query
+type ShelleyFilteredDelegationAndRewardAccountsQuery struct {
+	Era   int
+	Creds []interface{}
+}
+
+// ShelleyDebugNewEpochStateQuery is the decoded form of a Shelley debug new epoch state query
+type ShelleyDebugNewEpochStateQuery struct {
+	Era int
+}
+
+// ShelleyDebugChainDepStateQuery is the decoded form of a Shelley debug chain dependent state query
+type ShelleyDebugChainDepStateQuery struct {
+	Era int
+}
+
+// ShelleyRewardProvenanceQuery is the decoded form of a Shelley reward provenance query
+type ShelleyRewardProvenanceQuery struct {
+	Era int
+}
+
+// ShelleyUtxoByTxinQuery is the decoded form of a Shelley UTxO by TxIn query
+type ShelleyUtxoByTxinQuery struct {
+	Era   int
+	TxIns []interface{}
+}
+
+// ShelleyStakePoolParamsQuery is the decoded form of a Shelley stake pool params query
+type ShelleyStakePoolParamsQuery struct {
+	Era     int
+	PoolIds []interface{}
+}
+
+// ShelleyRewardInfoPoolsQuery is the decoded form of a Shelley reward info pools query
+type ShelleyRewardInfoPoolsQuery struct {
+	Era int
+}
+
+// ShelleyPoolStateQuery is the decoded form of a Shelley pool state query. PoolIds is nil when the query is
+// for all pools
+type ShelleyPoolStateQuery struct {
+	Era     int
+	PoolIds []interface{}
+}
+
+// ShelleyStakeSnapshotsQuery is the decoded form of a Shelley stake snapshots query. PoolIds is nil when the
+// query is for all pools
+type ShelleyStakeSnapshotsQuery struct {
+	Era     int
+	PoolIds []interface{}
+}
+
+// ShelleyPoolDistrQuery is the decoded form of a Shelley pool distribution query. PoolIds is nil when the query
+// is for all pools
+type ShelleyPoolDistrQuery struct {
+	Era     int
+	PoolIds []interface{}
+}
+
+// DecodeQuery converts a generic query, as found in MsgQuery, into one of the typed query
+// values (*SystemStartQuery, *ShelleyEpochNoQuery, etc.). It is the inverse of the query
+// building done by the client
+func DecodeQuery(query interface{}) (interface{}, error) {
+	queryType, params, err := splitQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	switch queryType {
+	case QueryTypeBlock:
+		if len(params) != 1 {
+			return nil, fmt.Errorf("%s: invalid block query", ProtocolName)
+		}
+		return decodeBlockQuery(params[0])
+	case QueryTypeSystemStart:
+		return &SystemStartQuery{}, nil
+	case QueryTypeChainBlockNo:
+		return &ChainBlockNoQuery{}, nil
+	case QueryTypeChainPoint:
+		return &ChainPointQuery{}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported query type %d", ProtocolName, queryType)
+	}
+}
+
+func decodeBlockQuery(query interface{}) (interface{}, error) {
+	blockQueryType, params, err := splitQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) != 1 {
+		return nil, fmt.Errorf("%s: invalid block query", ProtocolName)
+	}
+	switch blockQueryType {
+	case QueryTypeShelley:
+		era, eraParams, err := splitQuery(params[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(eraParams) != 1 {
+			return nil, fmt.Errorf("%s: invalid Shelley query", ProtocolName)
+		}
+		return decodeShelleyQuery(era, eraParams[0])
+	case QueryTypeHardFork:
+		hardForkQueryType, _, err := splitQuery(params[0])
+		if err != nil {
+			return nil, err
+		}
+		switch hardForkQueryType {
+		case QueryTypeHardForkEraHistory:
+			return &HardForkEraHistoryQuery{}, nil
+		case QueryTypeHardForkCurrentEra:
+			return &HardForkCurrentEraQuery{}, nil
+		default:
+			return nil, fmt.Errorf("%s: unsupported hard-fork query type %d", ProtocolName, hardForkQueryType)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported block query type %d", ProtocolName, blockQueryType)
+	}
+}
+
+func decodeShelleyQuery(era int, query interface{}) (interface{}, error) {
+	shelleyQueryType, params, err := splitQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	switch shelleyQueryType {
+	case QueryTypeShelleyLedgerTip:
+		return &ShelleyLedgerTipQuery{Era: era}, nil
+	case QueryTypeShelleyEpochNo:
+		return &ShelleyEpochNoQuery{Era: era}, nil
+	case QueryTypeShelleyNonMyopicMemberRewards:
+		stakes, err := decodeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyNonMyopicMemberRewardsQuery{Era: era, Stakes: stakes}, nil
+	case QueryTypeShelleyCurrentProtocolParams:
+		return &ShelleyCurrentProtocolParamsQuery{Era: era}, nil
+	case QueryTypeShelleyProposedProtocolParamsUpdates:
+		return &ShelleyProposedProtocolParamsUpdatesQuery{Era: era}, nil
+	case QueryTypeShelleyStakeDistribution:
+		return &ShelleyStakeDistributionQuery{Era: era}, nil
+	case QueryTypeShelleyUtxoByAddress:
+		addrs, err := decodeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyUtxoByAddressQuery{Era: era, Addrs: addrs}, nil
+	case QueryTypeShelleyUtxoWhole:
+		return &ShelleyUtxoWholeQuery{Era: era}, nil
+	case QueryTypeShelleyDebugEpochState:
+		return &ShelleyDebugEpochStateQuery{Era: era}, nil
+	case QueryTypeShelleyFilteredDelegationAndRewardAccounts:
+		creds, err := decodeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyFilteredDelegationAndRewardAccountsQuery{Era: era, Creds: creds}, nil
+	case QueryTypeShelleyGenesisConfig:
+		return &ShelleyGenesisConfigQuery{Era: era}, nil
+	case QueryTypeShelleyDebugNewEpochState:
+		return &ShelleyDebugNewEpochStateQuery{Era: era}, nil
+	case QueryTypeShelleyDebugChainDepState:
+		return &ShelleyDebugChainDepStateQuery{Era: era}, nil
+	case QueryTypeShelleyRewardProvenance:
+		return &ShelleyRewardProvenanceQuery{Era: era}, nil
+	case QueryTypeShelleyUtxoByTxin:
+		txIns, err := decodeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyUtxoByTxinQuery{Era: era, TxIns: txIns}, nil
+	case QueryTypeShelleyStakePools:
+		return &ShelleyStakePoolsQuery{Era: era}, nil
+	case QueryTypeShelleyStakePoolParams:
+		poolIds, err := decodeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyStakePoolParamsQuery{Era: era, PoolIds: poolIds}, nil
+	case QueryTypeShelleyRewardInfoPools:
+		return &ShelleyRewardInfoPoolsQuery{Era: era}, nil
+	case QueryTypeShelleyPoolState:
+		poolIds, err := decodeMaybeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyPoolStateQuery{Era: era, PoolIds: poolIds}, nil
+	case QueryTypeShelleyStakeSnapshots:
+		poolIds, err := decodeMaybeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyStakeSnapshotsQuery{Era: era, PoolIds: poolIds}, nil
+	case QueryTypeShelleyPoolDistr:
+		poolIds, err := decodeMaybeSetParam(params)
+		if err != nil {
+			return nil, err
+		}
+		return &ShelleyPoolDistrQuery{Era: era, PoolIds: poolIds}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported Shelley query type %d", ProtocolName, shelleyQueryType)
+	}
+}
+
+// decodeSetParam returns the items of a query param that is a set. Sets may be encoded as a plain list or
+// as a list with the set tag. A missing param is treated as an empty set
+func decodeSetParam(params []interface{}) ([]interface{}, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	return decodeSet(params[0])
+}
+
+// decodeMaybeSetParam returns the items of a query param that is an optional set, which is encoded as an
+// empty list or a list containing the set. The result is nil if the set is missing
+func decodeMaybeSetParam(params []interface{}) ([]interface{}, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	maybe, ok := params[0].([]interface{})
+	if !ok || len(maybe) > 1 {
+		return nil, fmt.Errorf("%s: invalid optional query param: %#v", ProtocolName, params[0])
+	}
+	if len(maybe) == 0 {
+		return nil, nil
+	}
+	return decodeSet(maybe[0])
+}
+
+func decodeSet(value interface{}) ([]interface{}, error) {
+	if tag, ok := value.(cbor.Tag); ok && tag.Number == cborTagSet {
+		value = tag.Content
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid set query param: %#v", ProtocolName, value)
+	}
+	return items, nil
+}
+
+// splitQuery returns the type and any params from a generic query list
+func splitQuery(query interface{}) (int, []interface{}, error) {
+	tmpQuery, ok := query.([]interface{})
+	if !ok || len(tmpQuery) == 0 {
+		return 0, nil, fmt.Errorf("%s: invalid query: %#v", ProtocolName, query)
+	}
+	queryType, ok := tmpQuery[0].(uint64)
+	if !ok {
+		return 0, nil, fmt.Errorf("%s: invalid query type: %#v", ProtocolName, tmpQuery[0])
+	}
+	return int(queryType), tmpQuery[1:], nil
+}
+
 type SystemStartResult struct {
 	// Tells the CBOR decoder to convert to/from a struct and a CBOR array
 	_           struct{} `cbor:",toarray"`
@@ -234,3 +578,14 @@ type RewardInfoPoolsResult interface{}
 type PoolStateResult interface{}
 type StakeSnapshotsResult interface{}
 type PoolDistrResult interface{}
+type StakeDelegDepositsResult interface{}
+type ConstitutionResult interface{}
+type GovStateResult interface{}
+type DRepStateResult interface{}
+type DRepStakeDistrResult interface{}
+type CommitteeMembersStateResult interface{}
+type FilteredVoteDelegateesResult interface{}
+type AccountStateResult interface{}
+type SPOStakeDistrResult interface{}
+type ProposalsResult interface{}
+type RatifyStateResult interface{}
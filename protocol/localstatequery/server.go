@@ -17,6 +17,7 @@ package localstatequery
 import (
 	"fmt"
 
+	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/gouroboros/protocol"
 )
 
@@ -39,6 +40,9 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleServer,
 		MessageHandlerFunc:  s.messageHandler,
@@ -82,31 +86,45 @@ func (s *Server) messageHandler(msg protocol.Message, isResponse bool) error {
 }
 
 func (s *Server) handleAcquire(msg protocol.Message) error {
-	if s.config.AcquireFunc == nil {
+	if s.config == nil || (s.config.AcquireFunc == nil && s.config.QueryHandler == nil) {
 		return fmt.Errorf("received local-state-query Acquire message but no callback function is defined")
 	}
-	switch msgAcquire := msg.(type) {
-	case *MsgAcquire:
-		// Call the user callback function
-		return s.config.AcquireFunc(msgAcquire.Point)
-	case *MsgAcquireNoPoint:
-		// Call the user callback function
-		return s.config.AcquireFunc(nil)
+	var point interface{}
+	if msgAcquire, ok := msg.(*MsgAcquire); ok {
+		point = msgAcquire.Point
 	}
-	return nil
+	return s.acquire(point, s.config.AcquireFunc)
 }
 
 func (s *Server) handleQuery(msg protocol.Message) error {
-	if s.config.QueryFunc == nil {
+	msgQuery := msg.(*MsgQuery)
+	if s.config != nil && s.config.QueryHandler != nil {
+		query, err := DecodeQuery(msgQuery.Query)
+		if err != nil {
+			return err
+		}
+		result, err := s.runQuery(query)
+		if err != nil {
+			return err
+		}
+		resultCbor, err := cbor.Encode(result)
+		if err != nil {
+			return err
+		}
+		return s.SendMessage(NewMsgResult(resultCbor))
+	}
+	if s.config == nil || s.config.QueryFunc == nil {
 		return fmt.Errorf("received local-state-query Query message but no callback function is defined")
 	}
-	msgQuery := msg.(*MsgQuery)
 	// Call the user callback function
 	return s.config.QueryFunc(msgQuery.Query)
 }
 
 func (s *Server) handleRelease() error {
-	if s.config.ReleaseFunc == nil {
+	if s.config == nil || s.config.ReleaseFunc == nil {
+		if s.config != nil && s.config.QueryHandler != nil {
+			return nil
+		}
 		return fmt.Errorf("received local-state-query Release message but no callback function is defined")
 	}
 	// Call the user callback function
@@ -114,24 +132,170 @@ func (s *Server) handleRelease() error {
 }
 
 func (s *Server) handleReAcquire(msg protocol.Message) error {
-	if s.config.ReAcquireFunc == nil {
+	if s.config == nil || (s.config.ReAcquireFunc == nil && s.config.QueryHandler == nil) {
 		return fmt.Errorf("received local-state-query ReAcquire message but no callback function is defined")
 	}
-	switch msgReAcquire := msg.(type) {
-	case *MsgReAcquire:
-		// Call the user callback function
-		return s.config.ReAcquireFunc(msgReAcquire.Point)
-	case *MsgReAcquireNoPoint:
+	var point interface{}
+	if msgReAcquire, ok := msg.(*MsgReAcquire); ok {
+		point = msgReAcquire.Point
+	}
+	return s.acquire(point, s.config.ReAcquireFunc)
+}
+
+func (s *Server) handleDone() error {
+	// The client is allowed to end the protocol at any time, so there's nothing to do without a callback
+	if s.config != nil && s.config.DoneFunc != nil {
 		// Call the user callback function
-		return s.config.ReAcquireFunc(nil)
+		return s.config.DoneFunc()
 	}
 	return nil
 }
 
-func (s *Server) handleDone() error {
-	if s.config.DoneFunc == nil {
-		return fmt.Errorf("received local-state-query Done message but no callback function is defined")
+// acquire calls the provided callback function, if any, and responds to the client when a
+// QueryHandler is configured. Without a QueryHandler, responding is left to the callback
+func (s *Server) acquire(point interface{}, callbackFunc func(interface{}) error) error {
+	if callbackFunc != nil {
+		// Call the user callback function
+		err := callbackFunc(point)
+		if s.config.QueryHandler == nil {
+			return err
+		}
+		if err != nil {
+			switch err.(type) {
+			case AcquireFailurePointTooOldError:
+				return s.SendMessage(NewMsgFailure(AcquireFailurePointTooOld))
+			case AcquireFailurePointNotOnChainError:
+				return s.SendMessage(NewMsgFailure(AcquireFailurePointNotOnChain))
+			default:
+				return err
+			}
+		}
 	}
-	// Call the user callback function
-	return s.config.DoneFunc()
+	return s.SendMessage(NewMsgAcquired())
+}
+
+// runQuery dispatches a typed query to the QueryHandler and returns the result in the form
+// expected by the client
+func (s *Server) runQuery(query interface{}) (interface{}, error) {
+	handler := s.config.QueryHandler
+	switch q := query.(type) {
+	case *SystemStartQuery:
+		return handler.SystemStart(q)
+	case *ChainBlockNoQuery:
+		if !s.enableGetChainBlockNo {
+			return nil, fmt.Errorf("%s: chain block number query is not supported by protocol version", ProtocolName)
+		}
+		blockNo, err := handler.ChainBlockNo(q)
+		if err != nil {
+			return nil, err
+		}
+		// The block number is wrapped in a WithOrigin value
+		return []interface{}{1, blockNo}, nil
+	case *ChainPointQuery:
+		if !s.enableGetChainPoint {
+			return nil, fmt.Errorf("%s: chain point query is not supported by protocol version", ProtocolName)
+		}
+		return handler.ChainPoint(q)
+	case *HardForkEraHistoryQuery:
+		return handler.EraHistory(q)
+	case *HardForkCurrentEraQuery:
+		return handler.CurrentEra(q)
+	// Results for era-specific queries are wrapped in a single-element list, which indicates
+	// that there was no era mismatch
+	case *ShelleyEpochNoQuery:
+		return wrapShelleyResult(handler.EpochNo(q))
+	case *ShelleyCurrentProtocolParamsQuery:
+		return wrapShelleyResult(handler.CurrentProtocolParams(q))
+	case *ShelleyStakeDistributionQuery:
+		return wrapShelleyResult(handler.StakeDistribution(q))
+	case *ShelleyGenesisConfigQuery:
+		return wrapShelleyResult(handler.GenesisConfig(q))
+	case *ShelleyStakePoolsQuery:
+		return wrapShelleyResult(handler.StakePools(q))
+	case *ShelleyRewardInfoPoolsQuery:
+		if !s.enableGetRewardInfoPoolsBlock {
+			return nil, fmt.Errorf("%s: reward info pools query is not supported by protocol version", ProtocolName)
+		}
+		if h, ok := handler.(RewardInfoPoolsQueryHandler); ok {
+			return wrapShelleyResult(h.RewardInfoPools(q))
+		}
+	default:
+		return s.runOptionalQuery(query)
+	}
+	return nil, fmt.Errorf("%s: unsupported query: %#v", ProtocolName, query)
+}
+
+// runOptionalQuery answers queries that are handled by the optional per-query handler interfaces
+func (s *Server) runOptionalQuery(query interface{}) (interface{}, error) {
+	handler := s.config.QueryHandler
+	switch q := query.(type) {
+	case *ShelleyLedgerTipQuery:
+		if h, ok := handler.(LedgerTipQueryHandler); ok {
+			return wrapShelleyResult(h.LedgerTip(q))
+		}
+	case *ShelleyNonMyopicMemberRewardsQuery:
+		if h, ok := handler.(NonMyopicMemberRewardsQueryHandler); ok {
+			return wrapShelleyResult(h.NonMyopicMemberRewards(q))
+		}
+	case *ShelleyProposedProtocolParamsUpdatesQuery:
+		if h, ok := handler.(ProposedProtocolParamsUpdatesQueryHandler); ok {
+			return wrapShelleyResult(h.ProposedProtocolParamsUpdates(q))
+		}
+	case *ShelleyUtxoByAddressQuery:
+		if h, ok := handler.(UTxOByAddressQueryHandler); ok {
+			return wrapShelleyResult(h.UTxOByAddress(q))
+		}
+	case *ShelleyUtxoWholeQuery:
+		if h, ok := handler.(UTxOWholeQueryHandler); ok {
+			return wrapShelleyResult(h.UTxOWhole(q))
+		}
+	case *ShelleyDebugEpochStateQuery:
+		if h, ok := handler.(DebugEpochStateQueryHandler); ok {
+			return wrapShelleyResult(h.DebugEpochState(q))
+		}
+	case *ShelleyFilteredDelegationAndRewardAccountsQuery:
+		if h, ok := handler.(FilteredDelegationsAndRewardAccountsQueryHandler); ok {
+			return wrapShelleyResult(h.FilteredDelegationsAndRewardAccounts(q))
+		}
+	case *ShelleyDebugNewEpochStateQuery:
+		if h, ok := handler.(DebugNewEpochStateQueryHandler); ok {
+			return wrapShelleyResult(h.DebugNewEpochState(q))
+		}
+	case *ShelleyDebugChainDepStateQuery:
+		if h, ok := handler.(DebugChainDepStateQueryHandler); ok {
+			return wrapShelleyResult(h.DebugChainDepState(q))
+		}
+	case *ShelleyRewardProvenanceQuery:
+		if h, ok := handler.(RewardProvenanceQueryHandler); ok {
+			return wrapShelleyResult(h.RewardProvenance(q))
+		}
+	case *ShelleyUtxoByTxinQuery:
+		if h, ok := handler.(UTxOByTxInQueryHandler); ok {
+			return wrapShelleyResult(h.UTxOByTxIn(q))
+		}
+	case *ShelleyStakePoolParamsQuery:
+		if h, ok := handler.(StakePoolParamsQueryHandler); ok {
+			return wrapShelleyResult(h.StakePoolParams(q))
+		}
+	case *ShelleyPoolStateQuery:
+		if h, ok := handler.(PoolStateQueryHandler); ok {
+			return wrapShelleyResult(h.PoolState(q))
+		}
+	case *ShelleyStakeSnapshotsQuery:
+		if h, ok := handler.(StakeSnapshotsQueryHandler); ok {
+			return wrapShelleyResult(h.StakeSnapshots(q))
+		}
+	case *ShelleyPoolDistrQuery:
+		if h, ok := handler.(PoolDistrQueryHandler); ok {
+			return wrapShelleyResult(h.PoolDistr(q))
+		}
+	}
+	return nil, fmt.Errorf("%s: unsupported query: %#v", ProtocolName, query)
+}
+
+func wrapShelleyResult(result interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{result}, nil
 }
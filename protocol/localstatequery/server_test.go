@@ -0,0 +1,417 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localstatequery_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/protocol/common"
+	"github.com/blinklabs-io/gouroboros/protocol/localstatequery"
+)
+
+const testEra = 5
+
+// testQueryHandler answers queries with static values
+type testQueryHandler struct{}
+
+func (h *testQueryHandler) SystemStart(*localstatequery.SystemStartQuery) (*localstatequery.SystemStartResult, error) {
+	return &localstatequery.SystemStartResult{
+		Year:        2022,
+		Day:         150,
+		Picoseconds: 12345,
+	}, nil
+}
+
+func (h *testQueryHandler) ChainBlockNo(*localstatequery.ChainBlockNoQuery) (int64, error) {
+	return 999, nil
+}
+
+func (h *testQueryHandler) ChainPoint(*localstatequery.ChainPointQuery) (*common.Point, error) {
+	point := common.NewPoint(12345, []byte{0xab, 0xcd})
+	return &point, nil
+}
+
+func (h *testQueryHandler) EraHistory(*localstatequery.HardForkEraHistoryQuery) ([]localstatequery.EraHistoryResult, error) {
+	era := localstatequery.EraHistoryResult{}
+	era.Begin.SlotNo = 0
+	era.End.SlotNo = 86400
+	era.End.EpochNo = 4
+	era.Params.EpochLength = 21600
+	era.Params.SlotLength = 20000
+	return []localstatequery.EraHistoryResult{era}, nil
+}
+
+func (h *testQueryHandler) CurrentEra(*localstatequery.HardForkCurrentEraQuery) (int, error) {
+	return testEra, nil
+}
+
+func (h *testQueryHandler) EpochNo(q *localstatequery.ShelleyEpochNoQuery) (int, error) {
+	if q.Era != testEra {
+		return 0, fmt.Errorf("unexpected era: %d", q.Era)
+	}
+	return 42, nil
+}
+
+func (h *testQueryHandler) CurrentProtocolParams(*localstatequery.ShelleyCurrentProtocolParamsQuery) (*localstatequery.CurrentProtocolParamsResult, error) {
+	return &localstatequery.CurrentProtocolParamsResult{
+		MinFeeA: 44,
+		MinFeeB: 155381,
+	}, nil
+}
+
+func (h *testQueryHandler) StakeDistribution(*localstatequery.ShelleyStakeDistributionQuery) (localstatequery.StakeDistributionResult, error) {
+	return map[string]uint64{"pool1": 1000}, nil
+}
+
+func (h *testQueryHandler) GenesisConfig(*localstatequery.ShelleyGenesisConfigQuery) (*localstatequery.GenesisConfigResult, error) {
+	return &localstatequery.GenesisConfigResult{
+		NetworkMagic:  int(ouroboros_mock.MockNetworkMagic),
+		SecurityParam: 2160,
+		EpochLength:   432000,
+		// Empty map
+		GenDelegs: []byte{0xa0},
+	}, nil
+}
+
+func (h *testQueryHandler) StakePools(*localstatequery.ShelleyStakePoolsQuery) (localstatequery.StakePoolsResult, error) {
+	return []string{"pool1", "pool2"}, nil
+}
+
+// UTxOByAddress implements localstatequery.UTxOByAddressQueryHandler
+func (h *testQueryHandler) UTxOByAddress(q *localstatequery.ShelleyUtxoByAddressQuery) (localstatequery.UTxOByAddressResult, error) {
+	return uint64(len(q.Addrs)), nil
+}
+
+// PoolState implements localstatequery.PoolStateQueryHandler
+func (h *testQueryHandler) PoolState(q *localstatequery.ShelleyPoolStateQuery) (localstatequery.PoolStateResult, error) {
+	if q.PoolIds != nil {
+		return nil, fmt.Errorf("unexpected pool IDs: %#v", q.PoolIds)
+	}
+	return "all pools", nil
+}
+
+// slowQueryHandler waits to answer SystemStart queries until signalled
+type slowQueryHandler struct {
+	testQueryHandler
+	releaseChan chan struct{}
+}
+
+func (h *slowQueryHandler) SystemStart(q *localstatequery.SystemStartQuery) (*localstatequery.SystemStartResult, error) {
+	<-h.releaseChan
+	return h.testQueryHandler.SystemStart(q)
+}
+
+func TestServerQueryHandler(t *testing.T) {
+	oClient, oServer := newTestConnections(t, &testQueryHandler{})
+	client := oClient.LocalStateQuery().Client
+	systemStart, err := client.GetSystemStart()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetSystemStart: %s", err)
+	}
+	expectedSystemStart, _ := (&testQueryHandler{}).SystemStart(nil)
+	if !reflect.DeepEqual(systemStart, expectedSystemStart) {
+		t.Fatalf("did not get expected system start: got %#v, wanted %#v", systemStart, expectedSystemStart)
+	}
+	blockNo, err := client.GetChainBlockNo()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetChainBlockNo: %s", err)
+	}
+	if blockNo != 999 {
+		t.Fatalf("did not get expected block number: got %d, wanted %d", blockNo, 999)
+	}
+	point, err := client.GetChainPoint()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetChainPoint: %s", err)
+	}
+	if point.Slot != 12345 {
+		t.Fatalf("did not get expected chain point: got %#v", point)
+	}
+	era, err := client.GetCurrentEra()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetCurrentEra: %s", err)
+	}
+	if era != testEra {
+		t.Fatalf("did not get expected era: got %d, wanted %d", era, testEra)
+	}
+	epochNo, err := client.GetEpochNo()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetEpochNo: %s", err)
+	}
+	if epochNo != 42 {
+		t.Fatalf("did not get expected epoch: got %d, wanted %d", epochNo, 42)
+	}
+	params, err := client.GetCurrentProtocolParams()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetCurrentProtocolParams: %s", err)
+	}
+	if params.MinFeeA != 44 || params.MinFeeB != 155381 {
+		t.Fatalf("did not get expected protocol params: got %#v", params)
+	}
+	eraHistory, err := client.GetEraHistory()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetEraHistory: %s", err)
+	}
+	if len(eraHistory) != 1 || eraHistory[0].End.SlotNo != 86400 || eraHistory[0].Params.EpochLength != 21600 {
+		t.Fatalf("did not get expected era history: got %#v", eraHistory)
+	}
+	stakeDistribution, err := client.GetStakeDistribution()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetStakeDistribution: %s", err)
+	}
+	// The client returns untyped results with the era mismatch wrapper intact
+	expectedStakeDistribution := []interface{}{map[interface{}]interface{}{"pool1": uint64(1000)}}
+	if !reflect.DeepEqual(*stakeDistribution, expectedStakeDistribution) {
+		t.Fatalf("did not get expected stake distribution: got %#v, wanted %#v", *stakeDistribution, expectedStakeDistribution)
+	}
+	genesisConfig, err := client.GetGenesisConfig()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetGenesisConfig: %s", err)
+	}
+	if genesisConfig.NetworkMagic != int(ouroboros_mock.MockNetworkMagic) || genesisConfig.SecurityParam != 2160 || genesisConfig.EpochLength != 432000 {
+		t.Fatalf("did not get expected genesis config: got %#v", genesisConfig)
+	}
+	stakePools, err := client.GetStakePools()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetStakePools: %s", err)
+	}
+	expectedStakePools := []interface{}{[]interface{}{"pool1", "pool2"}}
+	if !reflect.DeepEqual(*stakePools, expectedStakePools) {
+		t.Fatalf("did not get expected stake pools: got %#v, wanted %#v", *stakePools, expectedStakePools)
+	}
+	utxos, err := client.GetUTxOByAddress(nil)
+	if err != nil {
+		t.Fatalf("unexpected error calling GetUTxOByAddress: %s", err)
+	}
+	if !reflect.DeepEqual(*utxos, []interface{}{uint64(0)}) {
+		t.Fatalf("did not get expected UTxO result: got %#v", *utxos)
+	}
+	poolState, err := client.GetPoolState(nil)
+	if err != nil {
+		t.Fatalf("unexpected error calling GetPoolState: %s", err)
+	}
+	if !reflect.DeepEqual(*poolState, []interface{}{"all pools"}) {
+		t.Fatalf("did not get expected pool state: got %#v", *poolState)
+	}
+	if err := client.Release(); err != nil {
+		t.Fatalf("unexpected error calling Release: %s", err)
+	}
+	closeTestConnections(t, oClient, oServer)
+}
+
+func TestDecodeQuery(t *testing.T) {
+	shelleyQuery := func(queryType int, params ...interface{}) []interface{} {
+		return []interface{}{
+			localstatequery.QueryTypeBlock,
+			[]interface{}{
+				localstatequery.QueryTypeShelley,
+				[]interface{}{
+					testEra,
+					append([]interface{}{queryType}, params...),
+				},
+			},
+		}
+	}
+	poolIds := []interface{}{[]byte{0x01}, []byte{0x02}}
+	testDefs := []struct {
+		query    interface{}
+		expected interface{}
+	}{
+		{
+			query:    []interface{}{localstatequery.QueryTypeSystemStart},
+			expected: &localstatequery.SystemStartQuery{},
+		},
+		{
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyLedgerTip),
+			expected: &localstatequery.ShelleyLedgerTipQuery{Era: testEra},
+		},
+		{
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyUtxoByAddress, []interface{}{[]byte{0xab}}),
+			expected: &localstatequery.ShelleyUtxoByAddressQuery{Era: testEra, Addrs: []interface{}{[]byte{0xab}}},
+		},
+		{
+			// Set with the set tag
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyStakePoolParams, cbor.Tag{Number: 258, Content: poolIds}),
+			expected: &localstatequery.ShelleyStakePoolParamsQuery{Era: testEra, PoolIds: poolIds},
+		},
+		{
+			// Missing param
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyUtxoByTxin),
+			expected: &localstatequery.ShelleyUtxoByTxinQuery{Era: testEra},
+		},
+		{
+			// Empty optional set
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyPoolState, []interface{}{}),
+			expected: &localstatequery.ShelleyPoolStateQuery{Era: testEra},
+		},
+		{
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyStakeSnapshots, []interface{}{poolIds}),
+			expected: &localstatequery.ShelleyStakeSnapshotsQuery{Era: testEra, PoolIds: poolIds},
+		},
+		{
+			query:    shelleyQuery(localstatequery.QueryTypeShelleyPoolDistr, []interface{}{cbor.Tag{Number: 258, Content: poolIds}}),
+			expected: &localstatequery.ShelleyPoolDistrQuery{Era: testEra, PoolIds: poolIds},
+		},
+	}
+	for _, testDef := range testDefs {
+		// Round-trip the query through CBOR to get the same types as a received message
+		queryCbor, err := cbor.Encode(testDef.query)
+		if err != nil {
+			t.Fatalf("unexpected error encoding query: %s", err)
+		}
+		var query interface{}
+		if _, err := cbor.Decode(queryCbor, &query); err != nil {
+			t.Fatalf("unexpected error decoding query: %s", err)
+		}
+		decoded, err := localstatequery.DecodeQuery(query)
+		if err != nil {
+			t.Fatalf("unexpected error decoding query %x: %s", queryCbor, err)
+		}
+		if !reflect.DeepEqual(decoded, testDef.expected) {
+			t.Fatalf("did not get expected query from %x: got %#v, wanted %#v", queryCbor, decoded, testDef.expected)
+		}
+	}
+	// An optional set with more than one item is invalid
+	queryCbor, _ := cbor.Encode(shelleyQuery(localstatequery.QueryTypeShelleyPoolDistr, []interface{}{poolIds, poolIds}))
+	var query interface{}
+	if _, err := cbor.Decode(queryCbor, &query); err != nil {
+		t.Fatalf("unexpected error decoding query: %s", err)
+	}
+	if _, err := localstatequery.DecodeQuery(query); err == nil {
+		t.Fatalf("did not get expected error decoding invalid query %x", queryCbor)
+	}
+}
+
+func TestClientQueryContextCancel(t *testing.T) {
+	handler := &slowQueryHandler{
+		releaseChan: make(chan struct{}),
+	}
+	oClient, oServer := newTestConnections(t, handler)
+	client := oClient.LocalStateQuery().Client
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetSystemStartContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("did not get expected error: got %v, wanted %s", err, context.DeadlineExceeded)
+	}
+	// Allow the server to answer the abandoned query
+	close(handler.releaseChan)
+	// The next query should get its own result rather than the one for the abandoned query
+	blockNo, err := client.GetChainBlockNo()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetChainBlockNo: %s", err)
+	}
+	if blockNo != 999 {
+		t.Fatalf("did not get expected block number: got %d, wanted %d", blockNo, 999)
+	}
+	closeTestConnections(t, oClient, oServer)
+}
+
+func TestClientAcquireContextCancel(t *testing.T) {
+	releaseChan := make(chan struct{})
+	oClient, oServer := newTestConnections(
+		t,
+		&testQueryHandler{},
+		localstatequery.WithAcquireFunc(func(point interface{}) error {
+			<-releaseChan
+			return nil
+		}),
+	)
+	client := oClient.LocalStateQuery().Client
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := client.AcquireContext(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("did not get expected error: got %v, wanted %s", err, context.DeadlineExceeded)
+	}
+	// Allow the server to answer the abandoned acquire
+	close(releaseChan)
+	// The next query should use the point acquired by the abandoned request rather than sending another acquire
+	blockNo, err := client.GetChainBlockNo()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetChainBlockNo: %s", err)
+	}
+	if blockNo != 999 {
+		t.Fatalf("did not get expected block number: got %d, wanted %d", blockNo, 999)
+	}
+	closeTestConnections(t, oClient, oServer)
+}
+
+func newTestConnections(t *testing.T, handler localstatequery.QueryHandler, options ...localstatequery.LocalStateQueryOptionFunc) (*ouroboros.Connection, *ouroboros.Connection) {
+	clientConn, serverConn := net.Pipe()
+	// Start server side of the connection
+	serverResultChan := make(chan error)
+	var oServer *ouroboros.Connection
+	go func() {
+		var err error
+		oServer, err = ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+			ouroboros.WithLocalStateQueryConfig(
+				localstatequery.NewConfig(
+					append(
+						[]localstatequery.LocalStateQueryOptionFunc{
+							localstatequery.WithQueryHandler(handler),
+						},
+						options...,
+					)...,
+				),
+			),
+		)
+		serverResultChan <- err
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := <-serverResultChan; err != nil {
+		t.Fatalf("unexpected error when creating server Connection object: %s", err)
+	}
+	// Async error handler
+	go func() {
+		err, ok := <-oServer.ErrorChan()
+		if !ok {
+			return
+		}
+		// The server will see an EOF when we close the client connection
+		if err == io.EOF {
+			return
+		}
+		// We can't call t.Fatalf() from a different Goroutine, so we panic instead
+		panic(fmt.Sprintf("unexpected Ouroboros server connection error: %s", err))
+	}()
+	return oClient, oServer
+}
+
+func closeTestConnections(t *testing.T, oClient *ouroboros.Connection, oServer *ouroboros.Connection) {
+	// Close Ouroboros connections
+	if err := oClient.Close(); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	if err := oServer.Close(); err != nil {
+		t.Fatalf("unexpected error when closing server Connection object: %s", err)
+	}
+}
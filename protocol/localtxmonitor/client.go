@@ -64,6 +64,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.messageHandler,
@@ -133,12 +136,18 @@ func (c *Client) Release() error {
 	return c.release()
 }
 
-// Stop transitions the protocol to the Done state. No more operations will be possible
+// Stop transitions the protocol to the Done state. Any acquired mempool snapshot is released first. No more
+// operations will be possible
 func (c *Client) Stop() error {
 	var err error
 	c.onceStop.Do(func() {
 		c.busyMutex.Lock()
 		defer c.busyMutex.Unlock()
+		if c.acquired {
+			if err = c.release(); err != nil {
+				return
+			}
+		}
 		msg := NewMsgDone()
 		if err = c.SendMessage(msg); err != nil {
 			return
@@ -114,10 +114,35 @@ type LocalTxMonitor struct {
 
 // Config is used to configure the LocalTxMonitor protocol instance
 type Config struct {
+	Mempool        Mempool
 	AcquireTimeout time.Duration
 	QueryTimeout   time.Duration
 }
 
+// Mempool provides access to mempool contents when acting as a server
+type Mempool interface {
+	// Snapshot returns the current mempool contents. It's called each time a client acquires a snapshot,
+	// and the result is used to answer all queries until the next acquire
+	Snapshot() (*MempoolSnapshot, error)
+	// ChangedChan returns a channel that is closed the next time the mempool contents change. It's used to
+	// block a client's AwaitAcquire until there's a snapshot that differs from the one it holds
+	ChangedChan() <-chan struct{}
+}
+
+// MempoolSnapshot represents the mempool contents at a particular slot
+type MempoolSnapshot struct {
+	SlotNo   uint64
+	Capacity uint32
+	Txs      []MempoolTx
+}
+
+// MempoolTx represents a transaction in a mempool snapshot
+type MempoolTx struct {
+	TxId  []byte
+	EraId uint8
+	Tx    []byte
+}
+
 // New returns a new LocalTxMonitor object
 func New(protoOptions protocol.ProtocolOptions, cfg *Config) *LocalTxMonitor {
 	l := &LocalTxMonitor{
@@ -143,6 +168,13 @@ func NewConfig(options ...LocalTxMonitorOptionFunc) Config {
 	return c
 }
 
+// WithMempool specifies the source of mempool data when acting as a server
+func WithMempool(mempool Mempool) LocalTxMonitorOptionFunc {
+	return func(c *Config) {
+		c.Mempool = mempool
+	}
+}
+
 // WithAcquireTimeout specifies the timeout for acquire operations when acting as a client
 func WithAcquireTimeout(timeout time.Duration) LocalTxMonitorOptionFunc {
 	return func(c *Config) {
@@ -15,14 +15,20 @@
 package localtxmonitor
 
 import (
+	"bytes"
 	"fmt"
+	"sync"
+
 	"github.com/blinklabs-io/gouroboros/protocol"
 )
 
 // Server implements the LocalTxMonitor server
 type Server struct {
 	*protocol.Protocol
-	config *Config
+	config        *Config
+	snapshotMutex sync.Mutex
+	snapshot      *MempoolSnapshot
+	nextTxIdx     int
 }
 
 // NewServer returns a new Server object
@@ -35,6 +41,9 @@ func NewServer(protoOptions protocol.ProtocolOptions, cfg *Config) *Server {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleServer,
 		MessageHandlerFunc:  s.messageHandler,
@@ -68,25 +77,138 @@ func (s *Server) messageHandler(msg protocol.Message, isResponse bool) error {
 }
 
 func (s *Server) handleAcquire() error {
+	if s.config == nil || s.config.Mempool == nil {
+		return fmt.Errorf("received local-tx-monitor Acquire message but no mempool is defined")
+	}
+	s.snapshotMutex.Lock()
+	heldSnapshot := s.snapshot
+	s.snapshotMutex.Unlock()
+	if heldSnapshot == nil {
+		snapshot, err := s.config.Mempool.Snapshot()
+		if err != nil {
+			return err
+		}
+		return s.sendAcquired(snapshot)
+	}
+	// The client already holds a snapshot, so this is an AwaitAcquire. Wait in the background for the mempool
+	// contents to change before replying
+	go func() {
+		snapshot, err := s.awaitChange(heldSnapshot)
+		if err != nil {
+			s.SendError(err)
+			return
+		}
+		if snapshot == nil {
+			// Protocol shutdown
+			return
+		}
+		if err := s.sendAcquired(snapshot); err != nil {
+			s.SendError(err)
+		}
+	}()
 	return nil
 }
 
+// awaitChange waits for a mempool snapshot that differs from the held snapshot. It returns nil if the protocol
+// shuts down first
+func (s *Server) awaitChange(heldSnapshot *MempoolSnapshot) (*MempoolSnapshot, error) {
+	for {
+		// Get the change notification channel before taking the snapshot so that we don't miss a change
+		// that happens in between
+		changedChan := s.config.Mempool.ChangedChan()
+		snapshot, err := s.config.Mempool.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			return nil, fmt.Errorf("%s: mempool returned an empty snapshot", ProtocolName)
+		}
+		if !snapshotsEqual(snapshot, heldSnapshot) {
+			return snapshot, nil
+		}
+		select {
+		case <-s.DoneChan():
+			return nil, nil
+		case <-changedChan:
+		}
+	}
+}
+
+func (s *Server) sendAcquired(snapshot *MempoolSnapshot) error {
+	if snapshot == nil {
+		return fmt.Errorf("%s: mempool returned an empty snapshot", ProtocolName)
+	}
+	s.snapshotMutex.Lock()
+	s.snapshot = snapshot
+	s.nextTxIdx = 0
+	s.snapshotMutex.Unlock()
+	msg := NewMsgAcquired(snapshot.SlotNo)
+	return s.SendMessage(msg)
+}
+
 func (s *Server) handleDone() error {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	s.snapshot = nil
 	return nil
 }
 
 func (s *Server) handleRelease() error {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	s.snapshot = nil
 	return nil
 }
 
-func (s *Server) handleHasTx(msg protocol.Message) error {
-	return nil
+func (s *Server) handleHasTx(msgGeneric protocol.Message) error {
+	msg := msgGeneric.(*MsgHasTx)
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	result := false
+	for _, tx := range s.snapshot.Txs {
+		if bytes.Equal(tx.TxId, msg.TxId) {
+			result = true
+			break
+		}
+	}
+	resp := NewMsgReplyHasTx(result)
+	return s.SendMessage(resp)
 }
 
 func (s *Server) handleNextTx() error {
-	return nil
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	if s.nextTxIdx >= len(s.snapshot.Txs) {
+		// Send an empty reply to signify that there are no more TXs
+		resp := NewMsgReplyNextTx(0, nil)
+		return s.SendMessage(resp)
+	}
+	tx := s.snapshot.Txs[s.nextTxIdx]
+	s.nextTxIdx++
+	resp := NewMsgReplyNextTx(tx.EraId, tx.Tx)
+	return s.SendMessage(resp)
 }
 
 func (s *Server) handleGetSizes() error {
-	return nil
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	var size uint32
+	for _, tx := range s.snapshot.Txs {
+		size += uint32(len(tx.Tx))
+	}
+	resp := NewMsgReplyGetSizes(s.snapshot.Capacity, size, uint32(len(s.snapshot.Txs)))
+	return s.SendMessage(resp)
+}
+
+// snapshotsEqual returns whether two mempool snapshots have the same slot, capacity, and transactions
+func snapshotsEqual(a *MempoolSnapshot, b *MempoolSnapshot) bool {
+	if a.SlotNo != b.SlotNo || a.Capacity != b.Capacity || len(a.Txs) != len(b.Txs) {
+		return false
+	}
+	for idx := range a.Txs {
+		if !bytes.Equal(a.Txs[idx].TxId, b.Txs[idx].TxId) {
+			return false
+		}
+	}
+	return true
 }
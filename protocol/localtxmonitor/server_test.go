@@ -0,0 +1,246 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localtxmonitor_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	"github.com/blinklabs-io/gouroboros/internal/test/ouroboros_mock"
+	"github.com/blinklabs-io/gouroboros/protocol/localtxmonitor"
+)
+
+// testMempool returns the same snapshot every time until it's changed with setSnapshot
+type testMempool struct {
+	sync.Mutex
+	snapshot    localtxmonitor.MempoolSnapshot
+	changedChan chan struct{}
+}
+
+func (m *testMempool) Snapshot() (*localtxmonitor.MempoolSnapshot, error) {
+	m.Lock()
+	defer m.Unlock()
+	// Return a copy so that changes don't leak between snapshots
+	snapshot := m.snapshot
+	return &snapshot, nil
+}
+
+func (m *testMempool) ChangedChan() <-chan struct{} {
+	m.Lock()
+	defer m.Unlock()
+	if m.changedChan == nil {
+		m.changedChan = make(chan struct{})
+	}
+	return m.changedChan
+}
+
+func (m *testMempool) setSnapshot(snapshot localtxmonitor.MempoolSnapshot) {
+	m.Lock()
+	defer m.Unlock()
+	m.snapshot = snapshot
+	if m.changedChan != nil {
+		close(m.changedChan)
+		m.changedChan = nil
+	}
+}
+
+func newTestConnections(t *testing.T, mempool localtxmonitor.Mempool) (*ouroboros.Connection, *ouroboros.Connection) {
+	clientConn, serverConn := net.Pipe()
+	// Start server side of the connection
+	serverResultChan := make(chan error)
+	var oServer *ouroboros.Connection
+	go func() {
+		var err error
+		oServer, err = ouroboros.New(
+			ouroboros.WithConnection(serverConn),
+			ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+			ouroboros.WithServer(true),
+			ouroboros.WithLocalTxMonitorConfig(
+				localtxmonitor.NewConfig(
+					localtxmonitor.WithMempool(mempool),
+				),
+			),
+		)
+		serverResultChan <- err
+	}()
+	oClient, err := ouroboros.New(
+		ouroboros.WithConnection(clientConn),
+		ouroboros.WithNetworkMagic(ouroboros_mock.MockNetworkMagic),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when creating client Connection object: %s", err)
+	}
+	if err := <-serverResultChan; err != nil {
+		t.Fatalf("unexpected error when creating server Connection object: %s", err)
+	}
+	// Async error handler
+	go func() {
+		err, ok := <-oServer.ErrorChan()
+		if !ok {
+			return
+		}
+		// The server will see an EOF when we close the client connection
+		if err == io.EOF {
+			return
+		}
+		// We can't call t.Fatalf() from a different Goroutine, so we panic instead
+		panic(fmt.Sprintf("unexpected Ouroboros server connection error: %s", err))
+	}()
+	return oClient, oServer
+}
+
+func TestServer(t *testing.T) {
+	mempool := &testMempool{
+		snapshot: localtxmonitor.MempoolSnapshot{
+			SlotNo:   12345,
+			Capacity: 1000,
+			Txs: []localtxmonitor.MempoolTx{
+				{
+					TxId:  []byte{0x01},
+					EraId: 5,
+					Tx:    []byte{0x80},
+				},
+				{
+					TxId:  []byte{0x02},
+					EraId: 5,
+					Tx:    []byte{0x81, 0x00},
+				},
+			},
+		},
+	}
+	oClient, oServer := newTestConnections(t, mempool)
+	client := oClient.LocalTxMonitor().Client
+	capacity, size, numberOfTxs, err := client.GetSizes()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetSizes: %s", err)
+	}
+	if capacity != 1000 || size != 3 || numberOfTxs != 2 {
+		t.Fatalf("did not get expected sizes: got capacity %d, size %d, TXs %d", capacity, size, numberOfTxs)
+	}
+	hasTx, err := client.HasTx([]byte{0x02})
+	if err != nil {
+		t.Fatalf("unexpected error calling HasTx: %s", err)
+	}
+	if !hasTx {
+		t.Fatalf("did not find expected TX")
+	}
+	hasTx, err = client.HasTx([]byte{0x03})
+	if err != nil {
+		t.Fatalf("unexpected error calling HasTx: %s", err)
+	}
+	if hasTx {
+		t.Fatalf("found unexpected TX")
+	}
+	for _, expectedTx := range mempool.snapshot.Txs {
+		tx, err := client.NextTx()
+		if err != nil {
+			t.Fatalf("unexpected error calling NextTx: %s", err)
+		}
+		if !bytes.Equal(tx, expectedTx.Tx) {
+			t.Fatalf("did not get expected TX: got %x, wanted %x", tx, expectedTx.Tx)
+		}
+	}
+	tx, err := client.NextTx()
+	if err != nil {
+		t.Fatalf("unexpected error calling NextTx: %s", err)
+	}
+	if tx != nil {
+		t.Fatalf("got unexpected TX after end of snapshot: %x", tx)
+	}
+	// Close Ouroboros connections
+	if err := oClient.Close(); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	if err := oServer.Close(); err != nil {
+		t.Fatalf("unexpected error when closing server Connection object: %s", err)
+	}
+}
+
+func TestServerAwaitAcquire(t *testing.T) {
+	mempool := &testMempool{
+		snapshot: localtxmonitor.MempoolSnapshot{
+			SlotNo:   12345,
+			Capacity: 1000,
+			Txs: []localtxmonitor.MempoolTx{
+				{
+					TxId:  []byte{0x01},
+					EraId: 5,
+					Tx:    []byte{0x80},
+				},
+			},
+		},
+	}
+	oClient, oServer := newTestConnections(t, mempool)
+	client := oClient.LocalTxMonitor().Client
+	if err := client.Acquire(); err != nil {
+		t.Fatalf("unexpected error calling Acquire: %s", err)
+	}
+	// Acquiring again while holding a snapshot sends AwaitAcquire, which shouldn't return until the mempool changes
+	acquireResultChan := make(chan error, 1)
+	go func() {
+		acquireResultChan <- client.Acquire()
+	}()
+	select {
+	case err := <-acquireResultChan:
+		t.Fatalf("AwaitAcquire returned before the mempool changed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	mempool.setSnapshot(
+		localtxmonitor.MempoolSnapshot{
+			SlotNo:   12346,
+			Capacity: 1000,
+			Txs: []localtxmonitor.MempoolTx{
+				{
+					TxId:  []byte{0x01},
+					EraId: 5,
+					Tx:    []byte{0x80},
+				},
+				{
+					TxId:  []byte{0x02},
+					EraId: 5,
+					Tx:    []byte{0x81, 0x00},
+				},
+			},
+		},
+	)
+	select {
+	case err := <-acquireResultChan:
+		if err != nil {
+			t.Fatalf("unexpected error calling Acquire: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("AwaitAcquire did not return after the mempool changed")
+	}
+	_, _, numberOfTxs, err := client.GetSizes()
+	if err != nil {
+		t.Fatalf("unexpected error calling GetSizes: %s", err)
+	}
+	if numberOfTxs != 2 {
+		t.Fatalf("did not get expected number of TXs from new snapshot: got %d, wanted 2", numberOfTxs)
+	}
+	// Close Ouroboros connections
+	if err := oClient.Close(); err != nil {
+		t.Fatalf("unexpected error when closing client Connection object: %s", err)
+	}
+	if err := oServer.Close(); err != nil {
+		t.Fatalf("unexpected error when closing server Connection object: %s", err)
+	}
+}
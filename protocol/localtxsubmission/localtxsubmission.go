@@ -42,6 +42,10 @@ var StateMap = protocol.StateMap{
 				MsgType:  MessageTypeSubmitTx,
 				NewState: stateBusy,
 			},
+			{
+				MsgType:  MessageTypeDone,
+				NewState: stateDone,
+			},
 		},
 	},
 	stateBusy: protocol.StateMapEntry{
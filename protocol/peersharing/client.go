@@ -16,6 +16,7 @@ package peersharing
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/blinklabs-io/gouroboros/protocol"
 )
@@ -25,6 +26,7 @@ type Client struct {
 	*protocol.Protocol
 	config         *Config
 	sharePeersChan chan []interface{}
+	onceStop       sync.Once
 }
 
 // NewClient returns a new PeerSharing client object
@@ -49,6 +51,9 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 		ProtocolId:          ProtocolId,
 		Muxer:               protoOptions.Muxer,
 		ErrorChan:           protoOptions.ErrorChan,
+		Logger:              protoOptions.Logger,
+		Metrics:             protoOptions.Metrics,
+		StartedFunc:         protoOptions.StartedFunc,
 		Mode:                protoOptions.Mode,
 		Role:                protocol.ProtocolRoleClient,
 		MessageHandlerFunc:  c.handleMessage,
@@ -60,6 +65,16 @@ func NewClient(protoOptions protocol.ProtocolOptions, cfg *Config) *Client {
 	return c
 }
 
+// Stop transitions the protocol to the Done state. No more operations will be possible afterward
+func (c *Client) Stop() error {
+	var err error
+	c.onceStop.Do(func() {
+		msg := NewMsgDone()
+		err = c.SendMessage(msg)
+	})
+	return err
+}
+
 func (c *Client) GetPeers(amount uint8) ([]interface{}, error) {
 	msg := NewMsgShareRequest(amount)
 	if err := c.SendMessage(msg); err != nil {
@@ -24,6 +24,8 @@ import (
 	"time"
 
 	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/logging"
+	"github.com/blinklabs-io/gouroboros/metrics"
 	"github.com/blinklabs-io/gouroboros/muxer"
 )
 
@@ -38,15 +40,49 @@ type Protocol struct {
 	muxerDoneChan        chan bool
 	state                State
 	stateMutex           sync.Mutex
-	recvBuffer           *bytes.Buffer
+	recvBuffer           *receiveBuffer
+	ingressLimit         int
 	sendQueueChan        chan Message
 	sendStateQueueChan   chan Message
 	recvReadyChan        chan bool
 	sendReadyChan        chan bool
 	doneChan             chan bool
+	startedChan          chan struct{}
+	finishedChan         chan struct{}
+	onceFinished         sync.Once
 	waitGroup            sync.WaitGroup
 	stateTransitionTimer *time.Timer
 	onceStart            sync.Once
+	logger               logging.Logger
+	metrics              protocolMetrics
+	stateEnteredAt       time.Time
+}
+
+// protocolMetrics holds the metrics updated by Protocol. The label values are set up front where possible, so
+// that updating the metrics for each message is cheap
+type protocolMetrics struct {
+	messages       metrics.Counter
+	messageCounts  map[messageMetricsKey]metrics.Counter
+	stateDurations map[State]metrics.Histogram
+	stateTimeouts  map[State]metrics.Counter
+	sendQueueDepth metrics.Gauge
+}
+
+type messageMetricsKey struct {
+	direction string
+	msgType   reflect.Type
+}
+
+// messageCount returns the counter for messages of the same type as the provided message. The caller must hold
+// the state mutex
+func (m *protocolMetrics) messageCount(direction string, msg Message) metrics.Counter {
+	key := messageMetricsKey{direction: direction, msgType: reflect.TypeOf(msg)}
+	counter, ok := m.messageCounts[key]
+	if !ok {
+		counter = m.messages.With(direction, messageTypeName(msg))
+		m.messageCounts[key] = counter
+	}
+	return counter
 }
 
 // ProtocolConfig provides the configuration for Protocol
@@ -61,6 +97,9 @@ type ProtocolConfig struct {
 	MessageFromCborFunc MessageFromCborFunc
 	StateMap            StateMap
 	InitialState        State
+	Logger              logging.Logger
+	Metrics             metrics.Provider
+	StartedFunc         StartedFunc
 }
 
 // ProtocolMode is an enum of the protocol modes
@@ -82,27 +121,84 @@ const (
 	ProtocolRoleServer ProtocolRole = 2 // Server protocol role
 )
 
+func (r ProtocolRole) String() string {
+	switch r {
+	case ProtocolRoleClient:
+		return "client"
+	case ProtocolRoleServer:
+		return "server"
+	default:
+		return "none"
+	}
+}
+
 // ProtocolOptions provides common arguments for all mini-protocols
 type ProtocolOptions struct {
 	Muxer     *muxer.Muxer
 	ErrorChan chan error
 	Mode      ProtocolMode
 	// TODO: remove me
-	Role    ProtocolRole
-	Version uint16
+	Role        ProtocolRole
+	Version     uint16
+	Logger      logging.Logger
+	Metrics     metrics.Provider
+	StartedFunc StartedFunc
 }
 
 // MessageHandlerFunc represents a function that handles an incoming message
 type MessageHandlerFunc func(Message, bool) error
 
-// MessageFromCborFunc represents a function that parses a mini-protocol message
+// MessageFromCborFunc represents a function that parses a mini-protocol message. The provided CBOR is only valid
+// until the function returns, so the function must copy it if it's kept
 type MessageFromCborFunc func(uint, []byte) (Message, error)
 
+// StartedFunc represents a function that is called with the name and role of a mini-protocol when it's started
+type StartedFunc func(string, ProtocolRole)
+
 // New returns a new Protocol object
 func New(config ProtocolConfig) *Protocol {
 	p := &Protocol{
-		config:   config,
-		doneChan: make(chan bool),
+		config:       config,
+		doneChan:     make(chan bool),
+		startedChan:  make(chan struct{}),
+		finishedChan: make(chan struct{}),
+	}
+	p.logger = logging.With(
+		config.Logger,
+		"protocol", config.Name,
+		"role", config.Role,
+	)
+	provider := metrics.OrNop(config.Metrics)
+	roleLabel := config.Role.String()
+	stateDuration := provider.Histogram(
+		"ouroboros_protocol_state_duration_seconds",
+		"Time spent in each mini-protocol state",
+		metrics.DefaultBuckets,
+		"protocol", "role", "state",
+	)
+	stateTimeouts := provider.Counter(
+		"ouroboros_protocol_state_timeouts_total",
+		"Number of mini-protocol state transition timeouts",
+		"protocol", "role", "state",
+	)
+	p.metrics = protocolMetrics{
+		messages: provider.Counter(
+			"ouroboros_protocol_messages_total",
+			"Number of mini-protocol messages sent and received",
+			"protocol", "role", "direction", "message_type",
+		).With(config.Name, roleLabel),
+		messageCounts:  make(map[messageMetricsKey]metrics.Counter),
+		stateDurations: make(map[State]metrics.Histogram),
+		stateTimeouts:  make(map[State]metrics.Counter),
+		sendQueueDepth: provider.Gauge(
+			"ouroboros_protocol_send_queue_depth",
+			"Number of mini-protocol messages waiting to be sent",
+			"protocol", "role",
+		).With(config.Name, roleLabel),
+	}
+	for state := range config.StateMap {
+		p.metrics.stateDurations[state] = stateDuration.With(config.Name, roleLabel, state.String())
+		p.metrics.stateTimeouts[state] = stateTimeouts.With(config.Name, roleLabel, state.String())
 	}
 	return p
 }
@@ -116,8 +212,9 @@ func (p *Protocol) Start() {
 			muxerProtocolRole = muxer.ProtocolRoleResponder
 		}
 		p.muxerSendChan, p.muxerRecvChan, p.muxerDoneChan = p.config.Muxer.RegisterProtocol(p.config.ProtocolId, muxerProtocolRole)
+		p.ingressLimit = p.config.Muxer.IngressLimit(p.config.ProtocolId)
 		// Create buffers and channels
-		p.recvBuffer = bytes.NewBuffer(nil)
+		p.recvBuffer = &receiveBuffer{}
 		p.sendQueueChan = make(chan Message, 50)
 		p.sendStateQueueChan = make(chan Message, 50)
 		p.recvReadyChan = make(chan bool, 1)
@@ -128,17 +225,17 @@ func (p *Protocol) Start() {
 			<-p.doneChan
 			// Wait for all other goroutines to finish
 			p.waitGroup.Wait()
+			// Messages that were never sent no longer count towards the queue depth
+			p.metrics.sendQueueDepth.Add(-float64(len(p.sendQueueChan)))
 			// Close channels
-			close(p.sendQueueChan)
+			// The send queue is left open, since SendMessage() may still be called after shutdown
 			close(p.sendStateQueueChan)
 			close(p.recvReadyChan)
 			close(p.sendReadyChan)
 			// Cancel any timer
 			if p.stateTransitionTimer != nil {
-				// Stop timer and drain channel
-				if !p.stateTransitionTimer.Stop() {
-					<-p.stateTransitionTimer.C
-				}
+				// Timers from AfterFunc() have no channel to drain
+				p.stateTransitionTimer.Stop()
 				p.stateTransitionTimer = nil
 			}
 		}()
@@ -148,9 +245,23 @@ func (p *Protocol) Start() {
 		p.waitGroup.Add(2)
 		go p.recvLoop()
 		go p.sendLoop()
+		close(p.startedChan)
+		if p.config.StartedFunc != nil {
+			p.config.StartedFunc(p.config.Name, p.config.Role)
+		}
 	})
 }
 
+// Started returns whether the protocol has been started
+func (p *Protocol) Started() bool {
+	select {
+	case <-p.startedChan:
+		return true
+	default:
+		return false
+	}
+}
+
 // Mode returns the protocol mode
 func (p *Protocol) Mode() ProtocolMode {
 	return p.config.Mode
@@ -166,15 +277,32 @@ func (p *Protocol) DoneChan() chan bool {
 	return p.doneChan
 }
 
+// FinishedChan returns a channel that is closed when the protocol reaches a state where neither side has agency.
+// Any message that caused the transition to that state has already been passed to the muxer at that point
+func (p *Protocol) FinishedChan() chan struct{} {
+	return p.finishedChan
+}
+
 // SendMessage appends a message to the send queue
 func (p *Protocol) SendMessage(msg Message) error {
-	p.sendQueueChan <- msg
+	p.metrics.sendQueueDepth.Add(1)
+	select {
+	case <-p.doneChan:
+		p.metrics.sendQueueDepth.Add(-1)
+		return ProtocolShuttingDownError
+	case p.sendQueueChan <- msg:
+	}
 	return nil
 }
 
 // SendError sends an error to the handler in the Ouroboros object
 func (p *Protocol) SendError(err error) {
-	p.config.ErrorChan <- err
+	p.logger.Error("protocol error", "error", err)
+	p.config.ErrorChan <- ProtocolError{
+		Protocol: p.config.Name,
+		Role:     p.config.Role,
+		Err:      err,
+	}
 }
 
 func (p *Protocol) sendLoop() {
@@ -201,7 +329,7 @@ func (p *Protocol) sendLoop() {
 			msg := <-p.sendStateQueueChan
 			newState, err = p.getNewState(msg)
 			if err != nil {
-				p.SendError(fmt.Errorf("%s: error sending message: %s", p.config.Name, err))
+				p.SendError(fmt.Errorf("%s: error sending message: %w", p.config.Name, err))
 				return
 			}
 			setNewState = true
@@ -216,12 +344,17 @@ func (p *Protocol) sendLoop() {
 		payloadBuf := bytes.NewBuffer(nil)
 		msgCount := 0
 		for {
-			// Get next message from send queue
-			msg, ok := <-p.sendQueueChan
-			if !ok {
-				// We're shutting down
+			// Get next message from send queue. We may be waiting on the first message when the protocol shuts
+			// down, since we're marked as ready to send whenever we have agency
+			var msg Message
+			select {
+			case <-p.doneChan:
+				p.stateMutex.Unlock()
+				close(p.muxerSendChan)
 				return
+			case msg = <-p.sendQueueChan:
 			}
+			p.metrics.sendQueueDepth.Add(-1)
 			msgCount = msgCount + 1
 			// Write the message into the send state queue if we already have a new state
 			if setNewState {
@@ -239,10 +372,14 @@ func (p *Protocol) sendLoop() {
 				}
 			}
 			payloadBuf.Write(data)
+			if logging.Enabled(p.logger, logging.LevelDebug) {
+				p.logger.Debug("sending message", "state", p.state, "message_type", messageTypeName(msg))
+			}
+			p.metrics.messageCount("sent", msg).Add(1)
 			if !setNewState {
 				newState, err = p.getNewState(msg)
 				if err != nil {
-					p.SendError(fmt.Errorf("%s: error sending message: %s", p.config.Name, err))
+					p.SendError(fmt.Errorf("%s: error sending message: %w", p.config.Name, err))
 					return
 				}
 				setNewState = true
@@ -294,6 +431,9 @@ func (p *Protocol) sendLoop() {
 
 func (p *Protocol) recvLoop() {
 	defer p.waitGroup.Done()
+	// The protocol is done once we stop receiving, including when we stop because of an error. This lets the
+	// send loop and the cleanup goroutine finish
+	defer close(p.doneChan)
 	leftoverData := false
 	isResponse := false
 	for {
@@ -303,56 +443,68 @@ func (p *Protocol) recvLoop() {
 			// Wait for segment
 			select {
 			case <-p.muxerDoneChan:
-				close(p.doneChan)
 				return
 			case segment, ok := <-p.muxerRecvChan:
 				if !ok {
-					close(p.doneChan)
 					return
 				}
-				// Add segment payload to buffer
+				// Add segment payload to buffer, and let the muxer reuse the segment's buffer
 				p.recvBuffer.Write(segment.Payload)
+				segment.Release()
+				// Don't let a peer make us buffer an arbitrarily large message
+				if p.ingressLimit > 0 && p.recvBuffer.Len() > p.ingressLimit {
+					p.SendError(muxer.IngressLimitExceededError{
+						ProtocolId: p.config.ProtocolId,
+						Limit:      p.ingressLimit,
+						Size:       p.recvBuffer.Len(),
+					})
+					return
+				}
 				// Save whether it's a response
 				isResponse = segment.IsResponse()
 			}
 		}
 		leftoverData = false
-		// Wait until ready to receive based on state map
+		// Wait until ready to receive based on state map. We check for that first, so that a message that
+		// was received right before the muxer shut down is still processed
 		select {
-		case <-p.muxerDoneChan:
-			close(p.doneChan)
-			return
 		case <-p.recvReadyChan:
+		default:
+			select {
+			case <-p.muxerDoneChan:
+				return
+			case <-p.recvReadyChan:
+			}
 		}
-		// Decode message into generic list until we can determine what type of message it is.
-		// This also lets us determine how many bytes the message is. We use RawMessage here to
-		// avoid parsing things that we may not be able to parse
-		var tmpMsg []cbor.RawMessage
-		numBytesRead, err := cbor.Decode(p.recvBuffer.Bytes(), &tmpMsg)
+		// Determine how many bytes the message is without decoding it. This is cheap enough that we can
+		// repeat it as each segment of a large message arrives
+		numBytesRead, err := cbor.ItemLength(p.recvBuffer.Bytes())
 		if err != nil {
-			if err == io.EOF && p.recvBuffer.Len() > 0 {
-				// This is probably a multi-part message, so we wait until we get more of the message
-				// before trying to process it
+			if err == io.ErrUnexpectedEOF {
+				// This is a multi-part message, so we wait until we get more of the message before
+				// trying to process it
 				p.recvReadyChan <- true
 				continue
 			}
-			p.SendError(fmt.Errorf("%s: decode error: %s", p.config.Name, err))
+			p.SendError(DecodeError{Protocol: p.config.Name, Err: err})
 			return
 		}
 		// Decode first list item to determine message type
-		var msgType uint
-		if _, err := cbor.Decode(tmpMsg[0], &msgType); err != nil {
-			p.SendError(fmt.Errorf("%s: decode error: %s", p.config.Name, err))
+		msgData := p.recvBuffer.Bytes()[:numBytesRead]
+		msgTypeId, err := cbor.DecodeIdFromList(msgData)
+		if err != nil {
+			p.SendError(DecodeError{Protocol: p.config.Name, Err: err})
+			return
 		}
+		msgType := uint(msgTypeId)
 		// Create Message object from CBOR
-		msgData := p.recvBuffer.Bytes()[:numBytesRead]
 		msg, err := p.config.MessageFromCborFunc(msgType, msgData)
 		if err != nil {
 			p.SendError(err)
 			return
 		}
 		if msg == nil {
-			p.SendError(fmt.Errorf("%s: received unknown message type: %#v", p.config.Name, tmpMsg))
+			p.SendError(UnknownMessageError{Protocol: p.config.Name, MessageType: msgType})
 			return
 		}
 		// Handle message
@@ -360,15 +512,10 @@ func (p *Protocol) recvLoop() {
 			p.SendError(err)
 			return
 		}
-		if numBytesRead < p.recvBuffer.Len() {
-			// There is another message in the same muxer segment, so we reset the buffer with just
-			// the remaining data
-			p.recvBuffer = bytes.NewBuffer(p.recvBuffer.Bytes()[numBytesRead:])
-			leftoverData = true
-		} else {
-			// Empty out our buffer since we successfully processed the message
-			p.recvBuffer.Reset()
-		}
+		// Drop the message from the buffer. There may be another message from the same muxer segment
+		// after it
+		p.recvBuffer.Consume(numBytesRead)
+		leftoverData = p.recvBuffer.Len() > 0
 	}
 }
 
@@ -389,7 +536,7 @@ func (p *Protocol) getNewState(msg Message) (State, error) {
 		}
 	}
 	if !matchFound {
-		return newState, fmt.Errorf("message %s not allowed in current protocol state %s", reflect.TypeOf(msg).Name(), p.state)
+		return newState, InvalidMessageError{MessageType: messageTypeName(msg), State: p.state}
 	}
 	return newState, nil
 }
@@ -397,14 +544,27 @@ func (p *Protocol) getNewState(msg Message) (State, error) {
 func (p *Protocol) setState(state State) {
 	// Disable any previous state transition timer
 	if p.stateTransitionTimer != nil {
-		// Stop timer and drain channel
-		if !p.stateTransitionTimer.Stop() {
-			<-p.stateTransitionTimer.C
-		}
+		// Timers from AfterFunc() have no channel to drain
+		p.stateTransitionTimer.Stop()
 		p.stateTransitionTimer = nil
 	}
+	if logging.Enabled(p.logger, logging.LevelDebug) {
+		p.logger.Debug("state transition", "from", p.state, "to", state)
+	}
+	// Record the time spent in the previous state
+	now := time.Now()
+	if stateDuration, ok := p.metrics.stateDurations[p.state]; ok && !p.stateEnteredAt.IsZero() {
+		stateDuration.Observe(now.Sub(p.stateEnteredAt).Seconds())
+	}
+	p.stateEnteredAt = now
 	// Set the new state
 	p.state = state
+	// Signal that the protocol has finished if neither side has agency
+	if p.config.StateMap[p.state].Agency == AgencyNone {
+		p.onceFinished.Do(func() {
+			close(p.finishedChan)
+		})
+	}
 	// Mark protocol as ready to send/receive based on role and agency of the new state
 	switch p.config.StateMap[p.state].Agency {
 	case AgencyClient:
@@ -427,7 +587,9 @@ func (p *Protocol) setState(state State) {
 		p.stateTransitionTimer = time.AfterFunc(
 			p.config.StateMap[p.state].Timeout,
 			func() {
-				p.SendError(fmt.Errorf("%s: timeout waiting on transition from protocol state %s", p.config.Name, p.state))
+				p.logger.Warn("state transition timeout", "state", state)
+				p.metrics.stateTimeouts[state].Add(1)
+				p.SendError(StateTimeoutError{Protocol: p.config.Name, State: p.state})
 			},
 		)
 	}
@@ -436,9 +598,14 @@ func (p *Protocol) setState(state State) {
 func (p *Protocol) handleMessage(msg Message, isResponse bool) error {
 	// Lock the state to prevent collisions
 	p.stateMutex.Lock()
+	if logging.Enabled(p.logger, logging.LevelDebug) {
+		p.logger.Debug("received message", "state", p.state, "message_type", messageTypeName(msg))
+	}
+	p.metrics.messageCount("received", msg).Add(1)
 	newState, err := p.getNewState(msg)
 	if err != nil {
-		return fmt.Errorf("%s: error handling message: %s", p.config.Name, err)
+		p.stateMutex.Unlock()
+		return fmt.Errorf("%s: error handling message: %w", p.config.Name, err)
 	}
 	// Set new state and unlock
 	p.setState(newState)
@@ -446,3 +613,12 @@ func (p *Protocol) handleMessage(msg Message, isResponse bool) error {
 	// Call handler function
 	return p.config.MessageHandlerFunc(msg, isResponse)
 }
+
+// messageTypeName returns the name of the underlying type of the message for use in log events and errors
+func messageTypeName(msg Message) string {
+	msgType := reflect.TypeOf(msg)
+	if msgType.Kind() == reflect.Ptr {
+		msgType = msgType.Elem()
+	}
+	return msgType.Name()
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+// Capacity above which the storage of an empty receive buffer is released, so that an unusually large message
+// doesn't pin a large buffer for the life of the protocol. This comfortably fits the largest blocks
+const receiveBufferMaxIdleCapacity = 256 * 1024
+
+// receiveBuffer holds received data until it forms complete messages. Messages are consumed from the front of
+// the buffer, and the remaining data is only moved back to the start of the storage when more space is needed,
+// so the storage is reused rather than reallocated for each message
+type receiveBuffer struct {
+	data  []byte
+	start int
+}
+
+// Write appends data to the buffer
+func (b *receiveBuffer) Write(data []byte) {
+	if b.start > 0 && len(b.data)+len(data) > cap(b.data) {
+		n := copy(b.data, b.data[b.start:])
+		b.data = b.data[:n]
+		b.start = 0
+	}
+	b.data = append(b.data, data...)
+}
+
+// Bytes returns the unconsumed data. It's only valid until the next call to Write or Consume
+func (b *receiveBuffer) Bytes() []byte {
+	return b.data[b.start:]
+}
+
+// Len returns the length of the unconsumed data
+func (b *receiveBuffer) Len() int {
+	return len(b.data) - b.start
+}
+
+// Consume drops the provided number of bytes from the front of the buffer
+func (b *receiveBuffer) Consume(n int) {
+	b.start += n
+	if b.start < len(b.data) {
+		return
+	}
+	b.start = 0
+	if cap(b.data) > receiveBufferMaxIdleCapacity {
+		b.data = nil
+	} else {
+		b.data = b.data[:0]
+	}
+}
@@ -17,6 +17,13 @@ package ouroboros
 // The NtC protocol versions have the 15th bit set in the handshake
 const protocolVersionNtCFlag = 0x8000
 
+// Highest protocol versions to propose when querying a peer's supported versions. No mini-protocols are run after a
+// version query, so we only need to know the version data format for these rather than fully support them
+const (
+	protocolVersionNtNQueryMax = 14
+	protocolVersionNtCQueryMax = 17
+)
+
 // Most of these are enabled in all of the protocol versions that we support, but
 // they are here for completeness
 type ProtocolVersionNtC struct {
@@ -26,6 +33,7 @@ type ProtocolVersionNtC struct {
 	EnableMaryEra                bool
 	EnableAlonzoEra              bool
 	EnableBabbageEra             bool
+	EnableConwayEra              bool
 	EnableLocalTxMonitorProtocol bool
 }
 
@@ -83,6 +91,46 @@ var protocolVersionMapNtC = map[uint16]ProtocolVersionNtC{
 		EnableBabbageEra:             true,
 		EnableLocalTxMonitorProtocol: true,
 	},
+	// added query flag in handshake, GetStakeDelegDeposits
+	15: ProtocolVersionNtC{
+		EnableLocalQueryProtocol:     true,
+		EnableShelleyEra:             true,
+		EnableAllegraEra:             true,
+		EnableMaryEra:                true,
+		EnableAlonzoEra:              true,
+		EnableBabbageEra:             true,
+		EnableLocalTxMonitorProtocol: true,
+	},
+	// added Conway era, GetConstitution, GetGovState, GetDRepState, GetDRepStakeDistr, GetCommitteeMembersState,
+	// GetFilteredVoteDelegatees, GetAccountState
+	16: ProtocolVersionNtC{
+		EnableLocalQueryProtocol:     true,
+		EnableShelleyEra:             true,
+		EnableAllegraEra:             true,
+		EnableMaryEra:                true,
+		EnableAlonzoEra:              true,
+		EnableBabbageEra:             true,
+		EnableConwayEra:              true,
+		EnableLocalTxMonitorProtocol: true,
+	},
+	// added GetSPOStakeDistr, GetProposals, GetRatifyState
+	17: ProtocolVersionNtC{
+		EnableLocalQueryProtocol:     true,
+		EnableShelleyEra:             true,
+		EnableAllegraEra:             true,
+		EnableMaryEra:                true,
+		EnableAlonzoEra:              true,
+		EnableBabbageEra:             true,
+		EnableConwayEra:              true,
+		EnableLocalTxMonitorProtocol: true,
+	},
+}
+
+// supported returns whether we can fully handle the protocol version. Newer versions are listed above so that their
+// features are known, but we don't propose them until the ledger package can decode the blocks and transactions
+// for all of the eras that they enable
+func (p ProtocolVersionNtC) supported() bool {
+	return !p.EnableConwayEra
 }
 
 type ProtocolVersionNtN struct {
@@ -133,25 +181,47 @@ var protocolVersionMapNtN = map[uint16]ProtocolVersionNtN{
 		EnableBabbageEra:        true,
 		EnableFullDuplex:        true,
 	},
-	// NOTE: this protocol version seems to still be in flux, so it's disabled for now
-	/*
-		11: ProtocolVersionNtN{
-			EnableShelleyEra:          true,
-			EnableKeepAliveProtocol:   true,
-			EnableAllegraEra:          true,
-			EnableMaryEra:             true,
-			EnableAlonzoEra:           true,
-			EnableBabbageEra:          true,
-			EnableFullDuplex:          true,
-			EnablePeerSharingProtocol: true,
-		},
-	*/
+	// added peer sharing protocol, peer sharing and query flags in version data
+	11: ProtocolVersionNtN{
+		EnableShelleyEra:          true,
+		EnableKeepAliveProtocol:   true,
+		EnableAllegraEra:          true,
+		EnableMaryEra:             true,
+		EnableAlonzoEra:           true,
+		EnableBabbageEra:          true,
+		EnableFullDuplex:          true,
+		EnablePeerSharingProtocol: true,
+	},
+	12: ProtocolVersionNtN{
+		EnableShelleyEra:          true,
+		EnableKeepAliveProtocol:   true,
+		EnableAllegraEra:          true,
+		EnableMaryEra:             true,
+		EnableAlonzoEra:           true,
+		EnableBabbageEra:          true,
+		EnableFullDuplex:          true,
+		EnablePeerSharingProtocol: true,
+	},
+	// peer sharing value in version data is a simple enabled/disabled flag
+	13: ProtocolVersionNtN{
+		EnableShelleyEra:          true,
+		EnableKeepAliveProtocol:   true,
+		EnableAllegraEra:          true,
+		EnableMaryEra:             true,
+		EnableAlonzoEra:           true,
+		EnableBabbageEra:          true,
+		EnableFullDuplex:          true,
+		EnablePeerSharingProtocol: true,
+	},
 }
 
 // GetProtocolVersionNtC returns a list of supported NtC protocol versions
 func GetProtocolVersionsNtC() []uint16 {
 	versions := []uint16{}
-	for key := range protocolVersionMapNtC {
+	for key, protocolVersion := range protocolVersionMapNtC {
+		if !protocolVersion.supported() {
+			continue
+		}
 		versions = append(versions, key+protocolVersionNtCFlag)
 	}
 	return versions
@@ -178,3 +248,33 @@ func GetProtocolVersionsNtN() []uint16 {
 func GetProtocolVersionNtN(version uint16) ProtocolVersionNtN {
 	return protocolVersionMapNtN[version]
 }
+
+// getQueryProtocolVersionsNtC returns the NtC protocol versions to propose when querying a peer's supported versions
+func getQueryProtocolVersionsNtC() []uint16 {
+	versions := GetProtocolVersionsNtC()
+	var maxVersion uint16
+	for _, version := range versions {
+		if version > maxVersion {
+			maxVersion = version
+		}
+	}
+	for version := maxVersion + 1; version <= protocolVersionNtCQueryMax+protocolVersionNtCFlag; version++ {
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// getQueryProtocolVersionsNtN returns the NtN protocol versions to propose when querying a peer's supported versions
+func getQueryProtocolVersionsNtN() []uint16 {
+	versions := GetProtocolVersionsNtN()
+	var maxVersion uint16
+	for _, version := range versions {
+		if version > maxVersion {
+			maxVersion = version
+		}
+	}
+	for version := maxVersion + 1; version <= protocolVersionNtNQueryMax; version++ {
+		versions = append(versions, version)
+	}
+	return versions
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Blink Labs, LLC.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ouroboros_test
+
+import (
+	"testing"
+
+	ouroboros "github.com/blinklabs-io/gouroboros"
+)
+
+func TestGetProtocolVersionsNtC(t *testing.T) {
+	for _, version := range ouroboros.GetProtocolVersionsNtC() {
+		if version&0x8000 == 0 {
+			t.Fatalf("NtC protocol version %d does not have the NtC flag set", version)
+		}
+		// We can't handle Conway blocks and transactions yet
+		if ouroboros.GetProtocolVersionNtC(version).EnableConwayEra {
+			t.Fatalf("NtC protocol version %d enables an unsupported era", version&^0x8000)
+		}
+	}
+}
+
+func TestGetProtocolVersionNtC(t *testing.T) {
+	if !ouroboros.GetProtocolVersionNtC(16).EnableConwayEra {
+		t.Fatalf("expected Conway era to be enabled for NtC protocol version 16")
+	}
+	if ouroboros.GetProtocolVersionNtC(15).EnableConwayEra {
+		t.Fatalf("did not expect Conway era to be enabled for NtC protocol version 15")
+	}
+}